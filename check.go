@@ -43,18 +43,43 @@ type SubdirSpecs struct {
 	Pods       []string
 	Services   []string // *.service (plain systemd unit, sidecar of a .container)
 	Timers     []string // *.timer (plain systemd unit, sidecar of a .container)
+	Quadlets   []string // *.volume, *.network, *.image, *.build, *.kube (sidecar of a .container)
+}
+
+// ownedQuadlets are the Quadlet unit types a repo may define next to its
+// containers, with the section each must have. Like .service and .timer
+// files they belong to a .container by filename prefix.
+var ownedQuadlets = []struct{ ext, section string }{
+	{".volume", "Volume"},
+	{".network", "Network"},
+	{".image", "Image"},
+	{".build", "Build"},
+	{".kube", "Kube"},
+}
+
+// ownedQuadletSection returns the section a repo file of the given
+// extension must have, or false if ext is not an owned Quadlet type.
+func ownedQuadletSection(ext string) (string, bool) {
+	for _, q := range ownedQuadlets {
+		if q.ext == ext {
+			return q.section, true
+		}
+	}
+	return "", false
 }
 
 // discoverContainers finds deployable files using the same two-level layout
 // that buildDesired uses: root-level files and one level of non-dot
-// subdirectories. Returns the root scope and a map of subdirectory scopes.
-func discoverContainers(repoPath string) (root SubdirSpecs, subdirs map[string]SubdirSpecs, err error) {
+// subdirectories. Returns the root scope and a map of subdirectory scopes,
+// keeping only the units sel picks for this host.
+func discoverContainers(repoPath string, sel Selector) (root SubdirSpecs, subdirs map[string]SubdirSpecs, err error) {
 	subdirs = map[string]SubdirSpecs{}
 
 	root, err = globScope(repoPath)
 	if err != nil {
 		return SubdirSpecs{}, nil, err
 	}
+	root = sel.filterScope(root, selectRootDir)
 
 	entries, err := os.ReadDir(repoPath)
 	if err != nil {
@@ -69,7 +94,8 @@ func discoverContainers(repoPath string) (root SubdirSpecs, subdirs map[string]S
 		if err != nil {
 			return SubdirSpecs{}, nil, err
 		}
-		if len(spec.Containers) > 0 || len(spec.Pods) > 0 || len(spec.Services) > 0 || len(spec.Timers) > 0 {
+		spec = sel.filterScope(spec, dirName)
+		if len(spec.Containers) > 0 || len(spec.Pods) > 0 || len(spec.Services) > 0 || len(spec.Timers) > 0 || len(spec.Quadlets) > 0 {
 			subdirs[dirName] = spec
 		}
 	}
@@ -93,6 +119,13 @@ func globScope(dir string) (SubdirSpecs, error) {
 	if spec.Timers, err = filepath.Glob(filepath.Join(dir, "*.timer")); err != nil {
 		return SubdirSpecs{}, err
 	}
+	for _, q := range ownedQuadlets {
+		files, err := filepath.Glob(filepath.Join(dir, "*"+q.ext))
+		if err != nil {
+			return SubdirSpecs{}, err
+		}
+		spec.Quadlets = append(spec.Quadlets, files...)
+	}
 	return spec, nil
 }
 
@@ -124,12 +157,12 @@ func containerStemsOf(containerFiles []string) []string {
 	return stems
 }
 
-// CheckDir validates all .container, .pod, .service, and .timer files in a
-// directory. Returns a list of errors found.
-func CheckDir(dir string) []error {
+// CheckDir validates the .container, .pod, .service, .timer and owned Quadlet
+// (.volume, .network, ...) files in a directory that sel selects. Returns a list of errors found.
+func CheckDir(dir string, sel Selector) []error {
 	var errs []error
 
-	root, subdirs, err := discoverContainers(dir)
+	root, subdirs, err := discoverContainers(dir, sel)
 	if err != nil {
 		return []error{fmt.Errorf("reading directory %s: %w", dir, err)}
 	}
@@ -172,7 +205,7 @@ func CheckDir(dir string) []error {
 				}
 			}
 			if !isMember {
-				errs = append(errs, fmt.Errorf("%s: container in directory %s does not belong to any pod (no matching pod prefix)", f, dirName))
+				errs = append(errs, fileError(f, "container in directory %s does not belong to any pod (no matching pod prefix)", dirName))
 				continue
 			}
 			errs = append(errs, checkFile(f, true)...)
@@ -182,12 +215,73 @@ func CheckDir(dir string) []error {
 		errs = append(errs, checkSidecars(specs, dirName, containerStemsOf(specs.Containers))...)
 	}
 
+	if len(errs) == 0 {
+		errs = checkRepoDirectives(root, subdirs)
+	}
 	return errs
 }
 
-// checkSidecars validates .service and .timer files in a scope: each must
-// parse, have the right section, and match a .container in the scope by
-// filename prefix. dirName is "root" or a subdirectory name (for messages).
+// checkRepoDirectives checks the After= and RenamedFrom= declarations of one
+// repo's specs, read from the spec files themselves: a standalone container
+// is one unit, a pod with its members another. An After= target the repo
+// does not define is allowed, since another source may provide it; the
+// sync's check of the merged state catches it if none does.
+func checkRepoDirectives(root SubdirSpecs, subdirs map[string]SubdirSpecs) []error {
+	var errs []error
+	units := map[Username]DesiredState{}
+	addUnit := func(name Username, files ...string) {
+		after, err := specAfter(files...)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		renamedFrom, err := specRenamedFrom(files[0])
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		units[name] = DesiredState{After: after, RenamedFrom: renamedFrom}
+	}
+
+	scopes := []SubdirSpecs{root}
+	for _, dirName := range sortedKeys(subdirs) {
+		scopes = append(scopes, subdirs[dirName])
+	}
+	for _, specs := range scopes {
+		members := map[string][]string{} // pod stem → member files
+		for _, f := range specs.Containers {
+			stem := unitStem(f)
+			pod := ""
+			for _, p := range specs.Pods {
+				if strings.HasPrefix(stem, unitStem(p)+"-") {
+					pod = unitStem(p)
+					break
+				}
+			}
+			if pod != "" {
+				members[pod] = append(members[pod], f)
+				continue
+			}
+			if name, err := NewUsername(stem); err == nil {
+				addUnit(name, f)
+			}
+		}
+		for _, p := range specs.Pods {
+			if name, err := NewPodUsername(unitStem(p)); err == nil {
+				addUnit(name, append([]string{p}, members[unitStem(p)]...)...)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	errs = dependencyErrors(units, false)
+	return append(errs, checkRenames(units)...)
+}
+
+// checkSidecars validates .service, .timer and owned Quadlet files in a
+// scope: each must parse, have the right section, and match a .container in
+// the scope by filename prefix. dirName is "root" or a subdirectory name (for messages).
 func checkSidecars(specs SubdirSpecs, dirName string, containerStems []string) []error {
 	var errs []error
 	for _, f := range specs.Services {
@@ -196,6 +290,11 @@ func checkSidecars(specs SubdirSpecs, dirName string, containerStems []string) [
 	for _, f := range specs.Timers {
 		errs = append(errs, checkSidecarFile(f, ".timer", "Timer", dirName, containerStems)...)
 	}
+	for _, f := range specs.Quadlets {
+		ext := filepath.Ext(f)
+		section, _ := ownedQuadletSection(ext)
+		errs = append(errs, checkSidecarFile(f, ext, section, dirName, containerStems)...)
+	}
 	return errs
 }
 
@@ -204,22 +303,56 @@ func checkSidecarFile(path, ext, requiredSection, dirName string, containerStems
 	var errs []error
 
 	if _, ok := findSidecarOwner(path, containerStems); !ok {
-		errs = append(errs, fmt.Errorf("%s: %s in %s has no matching <stem>.container (filename must start with `<container-stem>-`)", path, ext, dirName))
+		errs = append(errs, fileError(path, "%s in %s has no matching <stem>.container (filename must start with `<container-stem>-`)", ext, dirName))
 	}
 
 	data, err := os.ReadFile(path)
 	if err != nil {
-		errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		errs = append(errs, fileError(path, "%v", err))
 		return errs
 	}
 
-	f, err := ParseINI(strings.NewReader(string(data)))
+	f, err := ParseINIFrom(strings.NewReader(string(data)), path)
 	if err != nil {
-		errs = append(errs, fmt.Errorf("%s: parse error: %w", path, err))
+		errs = append(errs, fileError(path, "parse error: %v", err))
 		return errs
 	}
-	if f.GetSection(requiredSection) == nil {
-		errs = append(errs, fmt.Errorf("%s: missing [%s] section", path, requiredSection))
+	sec := f.GetSection(requiredSection)
+	if sec == nil {
+		errs = append(errs, fileError(path, "missing [%s] section", requiredSection))
+	} else if isDeployHook(filepath.Base(path)) {
+		errs = append(errs, checkHookService(path, sec)...)
+	}
+	errs = append(errs, checkSchema(f, ext, path)...)
+	return errs
+}
+
+// checkHookService checks that a deploy hook runs to completion on every
+// start: Type=oneshot, so that starting it waits for the command, and no
+// RemainAfterExit=yes, which would make later starts no-ops. The last
+// setting of each key wins, as in systemd.
+func checkHookService(path string, sec *Section) []error {
+	var typ, remain *Entry
+	for i, e := range sec.Entries {
+		switch e.Key {
+		case "Type":
+			typ = &sec.Entries[i]
+		case "RemainAfterExit":
+			remain = &sec.Entries[i]
+		}
+	}
+	var errs []error
+	const needOneshot = "deploy hook must set Type=oneshot so quadsync can wait for it to finish"
+	if typ == nil {
+		errs = append(errs, sectionError(path, sec, needOneshot))
+	} else if typ.Value != "oneshot" {
+		errs = append(errs, entryError(*typ, needOneshot))
+	}
+	if remain != nil {
+		switch strings.ToLower(remain.Value) {
+		case "yes", "true", "on", "1":
+			errs = append(errs, entryError(*remain, "deploy hook must not set RemainAfterExit=yes, or it only runs once"))
+		}
 	}
 	return errs
 }
@@ -231,13 +364,13 @@ func checkFile(path string, isPodMember bool) []error {
 
 	if !isPodMember {
 		if _, err := NewUsername(name); err != nil {
-			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			errs = append(errs, fileError(path, "%v", err))
 		}
 	}
 
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return []error{fmt.Errorf("%s: %w", path, err)}
+		return []error{fileError(path, "%v", err)}
 	}
 
 	errs = append(errs, checkContent(name, string(data), path)...)
@@ -250,12 +383,12 @@ func checkPodFile(path string) []error {
 	name := strings.TrimSuffix(filepath.Base(path), ".pod")
 
 	if _, err := NewPodUsername(name); err != nil {
-		errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		errs = append(errs, fileError(path, "%v", err))
 	}
 
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return []error{fmt.Errorf("%s: %w", path, err)}
+		return []error{fileError(path, "%v", err)}
 	}
 
 	errs = append(errs, checkPodContent(name, string(data), path)...)
@@ -266,42 +399,48 @@ func checkPodFile(path string) []error {
 func checkPodContent(_, content, source string) []error {
 	var errs []error
 
-	f, err := ParseINI(strings.NewReader(content))
+	f, err := ParseINIFrom(strings.NewReader(content), source)
 	if err != nil {
-		return []error{fmt.Errorf("%s: parse error: %w", source, err)}
+		return []error{fileError(source, "parse error: %v", err)}
 	}
 
 	if f.GetSection("Pod") == nil {
-		errs = append(errs, fmt.Errorf("%s: missing [Pod] section", source))
+		errs = append(errs, fileError(source, "missing [Pod] section"))
 	}
+	errs = append(errs, validateQuadsyncSection(f)...)
+	errs = append(errs, checkSchema(f, ".pod", source)...)
 
 	return errs
 }
 
 // checkContent validates parsed INI content for a .container file.
-// source is a human-readable label for error messages.
+// source is a human-readable label for error messages, usually the path.
 func checkContent(name, content, source string) []error {
 	var errs []error
 
-	f, err := ParseINI(strings.NewReader(content))
+	f, err := ParseINIFrom(strings.NewReader(content), source)
 	if err != nil {
-		return []error{fmt.Errorf("%s: parse error: %w", source, err)}
+		return []error{fileError(source, "parse error: %v", err)}
 	}
 	if err := validateSecretsSection(f); err != nil {
-		errs = append(errs, fmt.Errorf("%s: %w", source, err))
+		errs = append(errs, err)
 	}
+	errs = append(errs, validateQuadsyncSection(f)...)
+	errs = append(errs, checkSchema(f, ".container", source)...)
 
 	// Must have [Container] section with Image=
 	container := f.GetSection("Container")
 	if container == nil {
-		errs = append(errs, fmt.Errorf("%s: missing [Container] section", source))
+		errs = append(errs, fileError(source, "missing [Container] section"))
 	} else {
 		if !container.HasKey("Image") {
-			errs = append(errs, fmt.Errorf("%s: missing Image= in [Container]", source))
+			errs = append(errs, sectionError(source, container, "missing Image= in [Container]"))
 		}
 		// Specs must not contain Pod= — quadsync injects it automatically
-		if container.HasKey("Pod") {
-			errs = append(errs, fmt.Errorf("%s: Pod= must not be set in container spec (quadsync injects it automatically)", source))
+		for _, e := range container.Entries {
+			if e.Key == "Pod" {
+				errs = append(errs, entryError(e, "Pod= must not be set in container spec (quadsync injects it automatically)"))
+			}
 		}
 	}
 
@@ -309,7 +448,7 @@ func checkContent(name, content, source string) []error {
 	if container != nil {
 		for _, e := range container.Entries {
 			if e.Key == "ContainerName" && e.Value != name {
-				errs = append(errs, fmt.Errorf("%s: ContainerName=%s does not match filename stem %s", source, e.Value, name))
+				errs = append(errs, entryError(e, "ContainerName=%s does not match filename stem %s", e.Value, name))
 			}
 		}
 	}
@@ -317,11 +456,24 @@ func checkContent(name, content, source string) []error {
 	return errs
 }
 
-// CheckDesired validates .container and .pod files in each DesiredState entry.
-// Companion files (.volume etc.) are not validated as container specs.
+// CheckDesired validates .container and .pod files in each DesiredState entry,
+// and the After= dependencies between entries. Companion and sidecar files
+// (.volume, .timer etc.) are not validated as container specs, only against
+// the schema of their unit type. Template placeholders left without a value
+// are errors too. An entry using a host fact this host could not determine
+// is not validated: it fails on its own when deployed (see
+// missingFactsError).
 func CheckDesired(desired map[Username]DesiredState) []error {
-	var errs []error
+	errs := checkDependencies(desired)
+	errs = append(errs, checkRenames(desired)...)
 	for name, state := range desired {
+		for _, u := range state.Unresolved {
+			errs = append(errs, fileError("merged output for "+u.File, "unknown template variable %s", u.Var))
+		}
+		if len(state.MissingFacts) > 0 {
+			continue
+		}
+
 		// Validate pod file if present
 		podFile := string(name) + ".pod"
 		if content, ok := state.Files[podFile]; ok {
@@ -329,14 +481,17 @@ func CheckDesired(desired map[Username]DesiredState) []error {
 			errs = append(errs, checkPodContent(string(name), content, source)...)
 		}
 
-		// Validate all .container files in this state
 		for filename, content := range state.Files {
-			if !strings.HasSuffix(filename, ".container") {
-				continue
+			switch {
+			case filename == podFile:
+			case strings.HasSuffix(filename, ".container"):
+				containerName := strings.TrimSuffix(filename, ".container")
+				source := fmt.Sprintf("merged output for %s", containerName)
+				errs = append(errs, checkContentPostMerge(containerName, content, source)...)
+			default:
+				source := fmt.Sprintf("merged output for %s", filename)
+				errs = append(errs, checkUnitFile(filename, content, source)...)
 			}
-			containerName := strings.TrimSuffix(filename, ".container")
-			source := fmt.Sprintf("merged output for %s", containerName)
-			errs = append(errs, checkContentPostMerge(containerName, content, source)...)
 		}
 	}
 	return errs
@@ -347,29 +502,30 @@ func CheckDesired(desired map[Username]DesiredState) []error {
 func checkContentPostMerge(name, content, source string) []error {
 	var errs []error
 
-	f, err := ParseINI(strings.NewReader(content))
+	f, err := ParseINIFrom(strings.NewReader(content), source)
 	if err != nil {
-		return []error{fmt.Errorf("%s: parse error: %w", source, err)}
+		return []error{fileError(source, "parse error: %v", err)}
 	}
 
 	container := f.GetSection("Container")
 	if container == nil {
-		errs = append(errs, fmt.Errorf("%s: missing [Container] section", source))
+		errs = append(errs, fileError(source, "missing [Container] section"))
 	} else if !container.HasKey("Image") {
-		errs = append(errs, fmt.Errorf("%s: missing Image= in [Container]", source))
+		errs = append(errs, sectionError(source, container, "missing Image= in [Container]"))
 	}
 
 	if container != nil {
 		for _, e := range container.Entries {
 			if e.Key == "ContainerName" && e.Value != name {
-				errs = append(errs, fmt.Errorf("%s: ContainerName=%s does not match filename stem %s", source, e.Value, name))
+				errs = append(errs, entryError(e, "ContainerName=%s does not match filename stem %s", e.Value, name))
 			}
 		}
 	}
 
-	if f.GetSection(sectionSecrets) != nil {
-		errs = append(errs, fmt.Errorf("%s: [Secrets] section should have been stripped", source))
+	if sec := f.GetSection(sectionSecrets); sec != nil {
+		errs = append(errs, sectionError(source, sec, "[Secrets] section should have been stripped"))
 	}
+	errs = append(errs, checkSchema(f, ".container", source)...)
 
 	return errs
 }
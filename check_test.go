@@ -209,7 +209,7 @@ func TestCheckDirWithSidecars(t *testing.T) {
 		os.WriteFile(filepath.Join(dir, "library-refresh.timer"),
 			[]byte("[Timer]\nOnCalendar=03:00\n"), 0644)
 
-		errs := CheckDir(dir)
+		errs := CheckDir(dir, Selector{})
 		if len(errs) != 0 {
 			t.Fatalf("expected no errors, got %v", errs)
 		}
@@ -221,7 +221,7 @@ func TestCheckDirWithSidecars(t *testing.T) {
 		os.WriteFile(filepath.Join(dir, "library-refresh.timer"),
 			[]byte("[Timer]\nOnCalendar=03:00\n"), 0644)
 
-		errs := CheckDir(dir)
+		errs := CheckDir(dir, Selector{})
 		foundOrphan := false
 		for _, e := range errs {
 			if strings.Contains(e.Error(), "has no matching <stem>.container") {
@@ -239,7 +239,7 @@ func TestCheckDirWithSidecars(t *testing.T) {
 		os.WriteFile(filepath.Join(dir, "library-refresh.timer"),
 			[]byte("[Unit]\nDescription=oops\n"), 0644)
 
-		errs := CheckDir(dir)
+		errs := CheckDir(dir, Selector{})
 		foundMissing := false
 		for _, e := range errs {
 			if strings.Contains(e.Error(), "missing [Timer] section") {
@@ -257,7 +257,7 @@ func TestCheckDirWithSidecars(t *testing.T) {
 		os.WriteFile(filepath.Join(dir, "library-refresh.service"),
 			[]byte("[Unit]\nDescription=oops\n"), 0644)
 
-		errs := CheckDir(dir)
+		errs := CheckDir(dir, Selector{})
 		foundMissing := false
 		for _, e := range errs {
 			if strings.Contains(e.Error(), "missing [Service] section") {
@@ -280,11 +280,46 @@ func TestCheckDirWithSidecars(t *testing.T) {
 		os.WriteFile(filepath.Join(sub, "webapp-web-refresh.service"),
 			[]byte("[Service]\nExecStart=/bin/true\n"), 0644)
 
-		errs := CheckDir(dir)
+		errs := CheckDir(dir, Selector{})
 		if len(errs) != 0 {
 			t.Fatalf("expected no errors, got %v", errs)
 		}
 	})
+
+	t.Run("quadlet sidecars validate", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "library.container"), []byte("[Container]\nImage=lib\n"), 0644)
+		os.WriteFile(filepath.Join(dir, "library-data.volume"), []byte("[Volume]\nDriver=local\n"), 0644)
+		os.WriteFile(filepath.Join(dir, "library-net.network"), []byte("[Network]\nInternal=true\n"), 0644)
+
+		errs := CheckDir(dir, Selector{})
+		if len(errs) != 0 {
+			t.Fatalf("expected no errors, got %v", errs)
+		}
+	})
+
+	t.Run("quadlet sidecar problems rejected", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "library.container"), []byte("[Container]\nImage=lib\n"), 0644)
+		os.WriteFile(filepath.Join(dir, "library-data.volume"), []byte("[Unit]\nDescription=data\n"), 0644)
+		os.WriteFile(filepath.Join(dir, "library-net.network"), []byte("[Network]\nInternl=true\n"), 0644)
+		os.WriteFile(filepath.Join(dir, "other-cache.volume"), []byte("[Volume]\n"), 0644)
+
+		var msgs []string
+		for _, e := range CheckDir(dir, Selector{}) {
+			msgs = append(msgs, e.Error())
+		}
+		got := strings.Join(msgs, "\n")
+		for _, want := range []string{
+			"library-data.volume: missing [Volume] section",
+			"library-net.network:2: unknown key Internl",
+			"other-cache.volume: .volume in root has no matching <stem>.container",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("expected %q in errors, got:\n%s", want, got)
+			}
+		}
+	})
 }
 
 func TestFindSidecarOwner(t *testing.T) {
@@ -326,7 +361,7 @@ func TestCheckDirWithPods(t *testing.T) {
 		os.WriteFile(filepath.Join(sub, "webapp-web.container"), []byte("[Container]\nImage=nginx\n"), 0644)
 		os.WriteFile(filepath.Join(sub, "orphan.container"), []byte("[Container]\nImage=nginx\n"), 0644)
 
-		errs := CheckDir(dir)
+		errs := CheckDir(dir, Selector{})
 		hasOrphan := false
 		for _, e := range errs {
 			if strings.Contains(e.Error(), "does not belong to any pod") {
@@ -346,7 +381,7 @@ func TestCheckDirWithPods(t *testing.T) {
 		os.WriteFile(filepath.Join(sub, "webapp-web.container"), []byte("[Container]\nImage=nginx\n"), 0644)
 		os.WriteFile(filepath.Join(sub, "webapp-api.container"), []byte("[Container]\nImage=api\n"), 0644)
 
-		errs := CheckDir(dir)
+		errs := CheckDir(dir, Selector{})
 		if len(errs) != 0 {
 			t.Fatalf("expected no errors, got %v", errs)
 		}
@@ -359,7 +394,7 @@ func TestDiscoverContainers(t *testing.T) {
 		os.WriteFile(filepath.Join(dir, "app.container"), []byte("[Container]\nImage=x\n"), 0644)
 		os.WriteFile(filepath.Join(dir, "web.container"), []byte("[Container]\nImage=x\n"), 0644)
 
-		root, subdirs, err := discoverContainers(dir)
+		root, subdirs, err := discoverContainers(dir, Selector{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -379,7 +414,7 @@ func TestDiscoverContainers(t *testing.T) {
 		os.WriteFile(filepath.Join(dir, "webapp.pod"), []byte("[Pod]\n"), 0644)
 		os.WriteFile(filepath.Join(dir, "webapp-web.container"), []byte("[Container]\nImage=x\n"), 0644)
 
-		root, _, err := discoverContainers(dir)
+		root, _, err := discoverContainers(dir, Selector{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -397,7 +432,7 @@ func TestDiscoverContainers(t *testing.T) {
 		os.Mkdir(sub, 0755)
 		os.WriteFile(filepath.Join(sub, "svc.container"), []byte("[Container]\nImage=x\n"), 0644)
 
-		root, subdirs, err := discoverContainers(dir)
+		root, subdirs, err := discoverContainers(dir, Selector{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -420,7 +455,7 @@ func TestDiscoverContainers(t *testing.T) {
 		os.WriteFile(filepath.Join(sub, "webapp.pod"), []byte("[Pod]\n"), 0644)
 		os.WriteFile(filepath.Join(sub, "webapp-web.container"), []byte("[Container]\nImage=x\n"), 0644)
 
-		_, subdirs, err := discoverContainers(dir)
+		_, subdirs, err := discoverContainers(dir, Selector{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -439,7 +474,7 @@ func TestDiscoverContainers(t *testing.T) {
 		os.Mkdir(dot, 0755)
 		os.WriteFile(filepath.Join(dot, "config.container"), []byte("[Container]\nImage=x\n"), 0644)
 
-		root, subdirs, err := discoverContainers(dir)
+		root, subdirs, err := discoverContainers(dir, Selector{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -457,7 +492,7 @@ func TestDiscoverContainers(t *testing.T) {
 		os.WriteFile(filepath.Join(dir, "app.volume"), []byte("[Volume]\n"), 0644)
 		os.WriteFile(filepath.Join(dir, "svc.container"), []byte("[Container]\nImage=x\n"), 0644)
 
-		root, _, err := discoverContainers(dir)
+		root, _, err := discoverContainers(dir, Selector{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -472,7 +507,7 @@ func TestDiscoverContainers(t *testing.T) {
 		os.MkdirAll(deep, 0755)
 		os.WriteFile(filepath.Join(deep, "deep.container"), []byte("[Container]\nImage=x\n"), 0644)
 
-		root, subdirs, err := discoverContainers(dir)
+		root, subdirs, err := discoverContainers(dir, Selector{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -495,7 +530,7 @@ func TestDiscoverContainers(t *testing.T) {
 		os.WriteFile(filepath.Join(sub, "api.container"), []byte("[Container]\nImage=x\n"), 0644)
 		os.WriteFile(filepath.Join(sub, "web.container"), []byte("[Container]\nImage=x\n"), 0644)
 
-		root, subdirs, err := discoverContainers(dir)
+		root, subdirs, err := discoverContainers(dir, Selector{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -523,7 +558,7 @@ func TestDiscoverContainers(t *testing.T) {
 		os.WriteFile(filepath.Join(dir, "library-refresh.service"), []byte("[Service]\nExecStart=/bin/true\n"), 0644)
 		os.WriteFile(filepath.Join(dir, "library-refresh.timer"), []byte("[Timer]\nOnCalendar=03:00\n"), 0644)
 
-		root, _, err := discoverContainers(dir)
+		root, _, err := discoverContainers(dir, Selector{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -543,7 +578,7 @@ func TestDiscoverContainers(t *testing.T) {
 		os.WriteFile(filepath.Join(sub, "library-refresh.service"), []byte("[Service]\nExecStart=/bin/true\n"), 0644)
 		os.WriteFile(filepath.Join(sub, "library-refresh.timer"), []byte("[Timer]\nOnCalendar=03:00\n"), 0644)
 
-		_, subdirs, err := discoverContainers(dir)
+		_, subdirs, err := discoverContainers(dir, Selector{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -553,3 +588,23 @@ func TestDiscoverContainers(t *testing.T) {
 		}
 	})
 }
+
+func TestCheckDirLineNumbers(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "web.container"), []byte("[Container]\nImage=nginx\nEnvironment=A=1 \\\n  B=2\nPod=other\n\n[X-Quadsync]\nColour=red\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "db.container"), []byte("# database\n[Container]\nEnvironment=A=1\n"), 0644)
+
+	var got []string
+	for _, err := range CheckDir(dir, Selector{}) {
+		got = append(got, strings.TrimPrefix(err.Error(), dir+string(filepath.Separator)))
+	}
+	sort.Strings(got)
+	want := []string{
+		"db.container:2: missing Image= in [Container]",
+		"web.container:5: Pod= must not be set in container spec (quadsync injects it automatically)",
+		"web.container:8: [X-Quadsync] unknown key Colour",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
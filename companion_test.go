@@ -19,7 +19,7 @@ func TestCompanionNameSubstitution(t *testing.T) {
 		},
 	}
 
-	state := buildDesiredState("myapp", "[Container]\nImage=myapp\n", companions, nil)
+	state := buildDesiredState("myapp", "[Container]\nImage=myapp\n", companions, nil, nil)
 
 	if len(state.Files) != 3 {
 		t.Fatalf("expected 3 files, got %d", len(state.Files))
@@ -50,7 +50,7 @@ func TestCompanionNameSubstitution(t *testing.T) {
 }
 
 func TestContainerContentNameSubstitution(t *testing.T) {
-	state := buildDesiredState("myapp", "[Container]\nImage=myapp\nVolume={{.Name}}-data.volume:/data\n", nil, nil)
+	state := buildDesiredState("myapp", "[Container]\nImage=myapp\nVolume={{.Name}}-data.volume:/data\n", nil, nil, nil)
 
 	content := state.Files["myapp.container"]
 	if strings.Contains(content, "{{.Name}}") {
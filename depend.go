@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// specAfter collects the After= dependencies declared in the [X-Quadsync]
+// sections of a unit's spec files (a container, or a pod and its members),
+// deduplicated and sorted.
+func specAfter(files ...string) ([]Username, error) {
+	seen := map[Username]bool{}
+	var after []Username
+	for _, f := range files {
+		d, err := readDirectives(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", f, err)
+		}
+		for _, name := range d.After {
+			u, err := NewUsername(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: After=: %w", f, err)
+			}
+			if !seen[u] {
+				seen[u] = true
+				after = append(after, u)
+			}
+		}
+	}
+	sort.Slice(after, func(i, j int) bool { return after[i] < after[j] })
+	return after, nil
+}
+
+// checkDependencies verifies that every After= names another container in
+// the desired state and that the dependencies form no cycle.
+func checkDependencies(desired map[Username]DesiredState) []error {
+	return dependencyErrors(desired, true)
+}
+
+// dependencyErrors implements checkDependencies; with requireKnown false,
+// targets outside desired are not reported.
+func dependencyErrors(desired map[Username]DesiredState, requireKnown bool) []error {
+	var errs []error
+	for _, name := range sortedNames(desired) {
+		for _, dep := range desired[name].After {
+			switch {
+			case dep == name:
+				errs = append(errs, fmt.Errorf("%s: After=%s refers to itself", name, dep))
+			case requireKnown && !hasName(desired, dep):
+				errs = append(errs, fmt.Errorf("%s: After=%s is not a container deployed on this host", name, dep))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if cycle := findCycle(desired); cycle != nil {
+		parts := make([]string, len(cycle))
+		for i, n := range cycle {
+			parts[i] = string(n)
+		}
+		errs = append(errs, fmt.Errorf("dependency cycle: %s", strings.Join(parts, " -> ")))
+	}
+	return errs
+}
+
+// findCycle returns one After= cycle (first node repeated at the end), or
+// nil if the dependency graph is acyclic.
+func findCycle(desired map[Username]DesiredState) []Username {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[Username]int{}
+	var stack []Username
+	var visit func(n Username) []Username
+	visit = func(n Username) []Username {
+		state[n] = visiting
+		stack = append(stack, n)
+		for _, dep := range desired[n].After {
+			switch state[dep] {
+			case visiting:
+				for i, s := range stack {
+					if s == dep {
+						return append(append([]Username{}, stack[i:]...), dep)
+					}
+				}
+			case unvisited:
+				if c := visit(dep); c != nil {
+					return c
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[n] = visited
+		return nil
+	}
+	for _, name := range sortedNames(desired) {
+		if state[name] == unvisited {
+			if c := visit(name); c != nil {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// dependents returns, for each name, whether some other desired container
+// declares After= on it.
+func dependents(desired map[Username]DesiredState) map[Username]bool {
+	out := map[Username]bool{}
+	for _, state := range desired {
+		for _, dep := range state.After {
+			out[dep] = true
+		}
+	}
+	return out
+}
+
+func hasName(desired map[Username]DesiredState, name Username) bool {
+	_, ok := desired[name]
+	return ok
+}
+
+// sortedNames returns the names of a desired state in sorted order.
+func sortedNames(desired map[Username]DesiredState) []Username {
+	names := make([]Username, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
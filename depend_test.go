@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckDependencies(t *testing.T) {
+	cases := []struct {
+		name   string
+		after  map[Username][]Username
+		errMsg string
+	}{
+		{"ok", map[Username][]Username{"app": {"db"}, "db": nil}, ""},
+		{"unknown", map[Username][]Username{"app": {"cache"}}, "After=cache is not a container deployed on this host"},
+		{"self", map[Username][]Username{"app": {"app"}}, "refers to itself"},
+		{"cycle", map[Username][]Username{"a": {"b"}, "b": {"c"}, "c": {"a"}}, "dependency cycle: a -> b -> c -> a"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			desired := map[Username]DesiredState{}
+			for name, after := range tc.after {
+				desired[name] = DesiredState{After: after}
+			}
+			errs := checkDependencies(desired)
+			if tc.errMsg == "" {
+				if len(errs) != 0 {
+					t.Fatalf("unexpected errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tc.errMsg) {
+				t.Fatalf("errors = %v, want one containing %q", errs, tc.errMsg)
+			}
+		})
+	}
+}
+
+func TestBuildDesiredAfter(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "db.container"), []byte("[Container]\nImage=postgres\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "cache.container"), []byte("[Container]\nImage=redis\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "webapp.pod"), []byte("[Pod]\n\n[X-Quadsync]\nAfter=db\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "webapp-web.container"), []byte("[Container]\nImage=nginx\n\n[X-Quadsync]\nAfter=cache db\n"), 0644)
+
+	tr := Transforms{DirContainer: map[string]*INIFile{}, DirPod: map[string]*INIFile{}}
+	desired, err := buildDesiredFull(dir, tr)
+	if err != nil {
+		t.Fatalf("buildDesiredFull: %v", err)
+	}
+	state := desired["webapp"]
+	if want := []Username{"cache", "db"}; !reflect.DeepEqual(state.After, want) {
+		t.Errorf("After = %v, want %v", state.After, want)
+	}
+	for file, content := range state.Files {
+		if strings.Contains(content, sectionQuadsync) {
+			t.Errorf("%s still contains [%s]:\n%s", file, sectionQuadsync, content)
+		}
+	}
+	if errs := checkDependencies(desired); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestCheckDirDependencies(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.container"), []byte("[Container]\nImage=a\n\n[X-Quadsync]\nAfter=b other-source\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.container"), []byte("[Container]\nImage=b\n\n[X-Quadsync]\nAfter=a\n"), 0644)
+
+	errs := CheckDir(dir, Selector{})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "dependency cycle: a -> b -> a") {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestCheckDirDirectivesSubdir(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"web", "shop"} {
+		os.Mkdir(filepath.Join(dir, sub), 0755)
+	}
+	os.WriteFile(filepath.Join(dir, "web", "web.container"), []byte("[Container]\nImage=web\n\n[X-Quadsync]\nAfter=web\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "web", "api.container"), []byte("[Container]\nImage=api\n\n[X-Quadsync]\nRenamedFrom=api\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "shop", "shop.pod"), []byte("[Pod]\n\n[X-Quadsync]\nAfter=db\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "shop", "shop-app.container"), []byte("[Container]\nImage=app\n\n[X-Quadsync]\nAfter=shop\n"), 0644)
+
+	var msgs []string
+	for _, e := range CheckDir(dir, Selector{}) {
+		msgs = append(msgs, e.Error())
+	}
+	got := strings.Join(msgs, "\n")
+	for _, want := range []string{
+		"web: After=web refers to itself",
+		"shop: After=shop refers to itself",
+		"api: RenamedFrom=api refers to itself",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q, got %v", want, msgs)
+		}
+	}
+	if strings.Contains(got, "db") {
+		t.Errorf("After= a container outside the repo reported: %v", msgs)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// userAdminMu serialises useradd: concurrent calls race for the passwd and
+// group file locks.
+var userAdminMu sync.Mutex
+
+// deployOne is deployContainer; replaced in tests.
+var deployOne = deployContainer
+
+// dependencyTimeout bounds how long a redeployed container that others
+// depend on may take to become active (and healthy) before its dependents
+// are started, when QUADSYNC_ROLLBACK_TIMEOUT does not already wait for it.
+const dependencyTimeout = 2 * time.Minute
+
+// deployAll deploys names with up to config.DeployParallelism users in
+// flight. Each user's steps run as that user, so deployments are independent
+// except for After= dependencies: a container is only started once every
+// container it depends on has been deployed and come up, and is skipped if
+// one of them failed. Among runnable containers, names order decides who
+// goes first.
+//
+// Once more containers than config.FailureBudget have failed or not come up,
+// no further deployments start: the rest are reported as skipped and a budget
+// error is returned alongside the per-container errors. Reports are returned
+// in names order.
+func deployAll(config Config, hashDir string, names []Username, desired map[Username]DesiredState, currentSet map[Username]bool) ([]ContainerReport, []error, error) {
+	reports := make([]ContainerReport, len(names))
+	errs := make([]error, len(names))
+	hasDependents := dependents(desired)
+
+	type result struct {
+		i   int
+		cr  ContainerReport
+		err error
+	}
+	results := make(chan result)
+
+	started := make([]bool, len(names))
+	finished := map[Username]bool{}
+	blocked := map[Username]bool{} // failed, did not come up, or skipped for a blocked dependency
+	inFlight, failures := 0, 0
+	exhausted := false
+	parallel := max(1, config.DeployParallelism)
+
+	for {
+		// Start everything runnable, in names order. Skipping a container
+		// for a blocked dependency finishes it, which can make others
+		// runnable, so rescan until nothing changes.
+		for progress := true; progress && !exhausted; {
+			progress = false
+			for i, name := range names {
+				if started[i] || inFlight >= parallel {
+					continue
+				}
+				state := desired[name]
+				ready, blockedBy := true, Username("")
+				for _, dep := range state.After {
+					if _, ok := desired[dep]; !ok {
+						continue // rejected by CheckDesired; never wait on it
+					}
+					if !finished[dep] {
+						ready = false
+						break
+					}
+					if blocked[dep] && blockedBy == "" {
+						blockedBy = dep
+					}
+				}
+				if !ready {
+					continue
+				}
+				started[i] = true
+				if blockedBy != "" {
+					log.Printf("%s: not deploying, dependency %s did not come up", name, blockedBy)
+					reports[i] = ContainerReport{Name: string(name), Source: state.Source, Outcome: OutcomeSkipped}
+					reports[i].warn("not deployed: dependency %s did not come up", blockedBy)
+					finished[name] = true
+					blocked[name] = true
+					progress = true
+					continue
+				}
+				inFlight++
+				go func() {
+					cr, err := deployUnit(config, hashDir, name, state, currentSet[name], hasDependents[name])
+					results <- result{i, cr, err}
+				}()
+			}
+		}
+		if inFlight == 0 {
+			break
+		}
+
+		r := <-results
+		inFlight--
+		name := names[r.i]
+		reports[r.i] = r.cr
+		errs[r.i] = r.err
+		finished[name] = true
+		if r.cr.Outcome == OutcomeFailed || r.cr.Down {
+			blocked[name] = true
+			failures++
+			if config.FailureBudget >= 0 && failures > config.FailureBudget && !exhausted {
+				exhausted = true
+				log.Printf("error: %d container(s) failed, exceeding the failure budget of %d; not starting further deployments", failures, config.FailureBudget)
+			}
+		}
+	}
+
+	skipped := 0
+	for i, name := range names {
+		if started[i] {
+			continue
+		}
+		skipped++
+		reports[i] = ContainerReport{Name: string(name), Source: desired[name].Source, Outcome: OutcomeSkipped}
+		if exhausted {
+			reports[i].warn("not deployed: failure budget exhausted")
+		} else {
+			reports[i].warn("not deployed: dependencies never finished")
+		}
+	}
+
+	var nonNil []error
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+	if exhausted {
+		return reports, nonNil, fmt.Errorf("failure budget exhausted: %d container(s) failed (budget %d), %d not deployed", failures, config.FailureBudget, skipped)
+	}
+	return reports, nonNil, nil
+}
+
+// deployUnit deploys one container and, if others depend on it and it was
+// restarted, waits for it to come up so they start against a running
+// service.
+func deployUnit(config Config, hashDir string, name Username, state DesiredState, exists, hasDependents bool) (ContainerReport, error) {
+	cr := ContainerReport{Name: string(name), Source: state.Source}
+	start := time.Now()
+	defer func() { cr.DurationMS = time.Since(start).Milliseconds() }()
+
+	if err := saveSourceLabel(hashDir, name, state.Source); err != nil {
+		cr.warn("recording source: %v", err)
+	}
+	if err := deployOne(config, hashDir, name, state, exists, &cr); err != nil {
+		log.Printf("error: %v", err)
+		cr.Outcome = OutcomeFailed
+		cr.Error = err.Error()
+		return cr, err
+	}
+	restarted := cr.Outcome == OutcomeCreated || cr.Outcome == OutcomeDeployed
+	if hasDependents && restarted && !cr.Down && config.RollbackTimeout == 0 {
+		if err := waitHealthy(name, state.ServiceName, dependencyTimeout); err != nil {
+			log.Printf("warning: %s did not come up: %v", name, err)
+			cr.warn("did not come up for dependents: %v", err)
+			cr.Down = true
+		}
+	}
+	return cr, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubDeploy replaces deployOne for the duration of a test.
+func stubDeploy(t *testing.T, fn func(name Username, cr *ContainerReport) error) {
+	t.Helper()
+	orig := deployOne
+	t.Cleanup(func() { deployOne = orig })
+	deployOne = func(_ Config, _ string, name Username, _ DesiredState, _ bool, cr *ContainerReport) error {
+		return fn(name, cr)
+	}
+}
+
+func deployNames(n int) ([]Username, map[Username]DesiredState) {
+	var names []Username
+	desired := map[Username]DesiredState{}
+	for i := 0; i < n; i++ {
+		name := Username("c" + string(rune('a'+i)))
+		names = append(names, name)
+		desired[name] = DesiredState{ServiceName: string(name)}
+	}
+	return names, desired
+}
+
+func TestDeployAllParallelism(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	stubDeploy(t, func(name Username, cr *ContainerReport) error {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		cr.Outcome = OutcomeDeployed
+		return nil
+	})
+
+	names, desired := deployNames(8)
+	cfg := Config{DeployParallelism: 3, FailureBudget: -1}
+	reports, errs, budgetErr := deployAll(cfg, t.TempDir(), names, desired, map[Username]bool{})
+	if len(errs) != 0 || budgetErr != nil {
+		t.Fatalf("unexpected errors: %v %v", errs, budgetErr)
+	}
+	if peak != 3 {
+		t.Errorf("peak concurrency = %d, want 3", peak)
+	}
+	for i, r := range reports {
+		if r.Name != string(names[i]) || r.Outcome != OutcomeDeployed {
+			t.Errorf("report %d = %+v", i, r)
+		}
+	}
+}
+
+func TestDeployAllFailureBudget(t *testing.T) {
+	// Every container fails to come up; one of them also errors.
+	stubDeploy(t, func(name Username, cr *ContainerReport) error {
+		cr.Outcome = OutcomeDeployed
+		cr.Down = true
+		if name == "cb" {
+			return errors.New("writing files: boom")
+		}
+		return nil
+	})
+
+	names, desired := deployNames(6)
+	cfg := Config{DeployParallelism: 1, FailureBudget: 2}
+	reports, errs, budgetErr := deployAll(cfg, t.TempDir(), names, desired, map[Username]bool{})
+	if budgetErr == nil || !strings.Contains(budgetErr.Error(), "3 container(s) failed (budget 2), 3 not deployed") {
+		t.Fatalf("budget error = %v", budgetErr)
+	}
+	if len(errs) != 1 {
+		t.Errorf("errs = %v", errs)
+	}
+	want := []string{OutcomeDeployed, OutcomeFailed, OutcomeDeployed, OutcomeSkipped, OutcomeSkipped, OutcomeSkipped}
+	for i, r := range reports {
+		if r.Outcome != want[i] {
+			t.Errorf("%s: outcome %s, want %s", r.Name, r.Outcome, want[i])
+		}
+	}
+
+	// Without a budget everything is attempted.
+	cfg.FailureBudget = -1
+	reports, _, budgetErr = deployAll(cfg, t.TempDir(), names, desired, map[Username]bool{})
+	if budgetErr != nil {
+		t.Errorf("unexpected budget error: %v", budgetErr)
+	}
+	for _, r := range reports {
+		if r.Outcome == OutcomeSkipped {
+			t.Errorf("%s skipped without a budget", r.Name)
+		}
+	}
+}
+
+func TestDeployAllDependencies(t *testing.T) {
+	stubHealth(t, [2]string{"active", ""})
+	var mu sync.Mutex
+	var order []Username
+	stubDeploy(t, func(name Username, cr *ContainerReport) error {
+		mu.Lock()
+		order = append(order, name)
+		mu.Unlock()
+		cr.Outcome = OutcomeDeployed
+		if name == "cb" {
+			return errors.New("writing files: boom")
+		}
+		return nil
+	})
+
+	// ca waits for cc; cd waits for cb, which fails, so cd is never started.
+	names, desired := deployNames(4)
+	desired["ca"] = DesiredState{ServiceName: "ca", After: []Username{"cc"}}
+	desired["cd"] = DesiredState{ServiceName: "cd", After: []Username{"cb"}}
+
+	cfg := Config{DeployParallelism: 1, FailureBudget: -1}
+	reports, errs, budgetErr := deployAll(cfg, t.TempDir(), names, desired, map[Username]bool{})
+	if len(errs) != 1 || budgetErr != nil {
+		t.Fatalf("errors = %v %v", errs, budgetErr)
+	}
+	if want := []Username{"cb", "cc", "ca"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("deploy order = %v, want %v", order, want)
+	}
+	want := []string{OutcomeDeployed, OutcomeFailed, OutcomeDeployed, OutcomeSkipped}
+	for i, r := range reports {
+		if r.Name != string(names[i]) || r.Outcome != want[i] {
+			t.Errorf("report %d = %s %s, want %s %s", i, r.Name, r.Outcome, names[i], want[i])
+		}
+	}
+	if w := reports[3].Warnings; len(w) != 1 || !strings.Contains(w[0], "dependency cb did not come up") {
+		t.Errorf("cd warnings = %v", w)
+	}
+}
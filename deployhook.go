@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Deploy hooks are .service sidecars (or _base-predeploy.service /
+// _base-postdeploy.service companions from the transform directory) that run
+// as the container's user around a deploy: pre-deploy hooks before the new
+// quadlets are written, post-deploy hooks once the service has restarted.
+const (
+	preDeploySuffix  = "-predeploy.service"
+	postDeploySuffix = "-postdeploy.service"
+
+	defaultHookTimeout = 10 * time.Minute
+)
+
+// startHook runs one hook unit and waits for it to finish. Replaced in tests.
+var startHook = startUnit
+
+// isDeployHook reports whether a sidecar filename is a pre- or post-deploy
+// hook unit.
+func isDeployHook(filename string) bool {
+	return strings.HasSuffix(filename, preDeploySuffix) || strings.HasSuffix(filename, postDeploySuffix)
+}
+
+// hookUnits returns the filenames in files that end in suffix, sorted.
+func hookUnits(files map[string]string, suffix string) []string {
+	var units []string
+	for filename := range files {
+		if strings.HasSuffix(filename, suffix) {
+			units = append(units, filename)
+		}
+	}
+	sort.Strings(units)
+	return units
+}
+
+// runPreDeployHooks installs and runs the pre-deploy hooks of a desired
+// state before any of its other files are written, so that a hook sees the
+// previous revision still running. The first failing hook aborts the deploy:
+// nothing else is written and the hash is not saved, so the next sync tries
+// again.
+func runPreDeployHooks(config Config, name Username, state DesiredState) error {
+	hooks := hookUnits(state.Files, preDeploySuffix)
+	if len(hooks) == 0 {
+		return nil
+	}
+	for _, unit := range hooks {
+		if err := writeQuadletFile(name, unit, state.Files[unit]); err != nil {
+			return fmt.Errorf("writing %s for %s: %w", unit, name, err)
+		}
+	}
+	if err := waitForUserManager(name); err != nil {
+		return fmt.Errorf("waiting for user manager %s: %w", name, err)
+	}
+	if err := daemonReload(name); err != nil {
+		return fmt.Errorf("daemon-reload for %s: %w", name, err)
+	}
+	for _, unit := range hooks {
+		log.Printf("%s: running pre-deploy hook %s", name, unit)
+		if err := startHook(name, unit, config.HookTimeout); err != nil {
+			return fmt.Errorf("pre-deploy hook %s for %s failed, not deploying: %w", unit, name, err)
+		}
+	}
+	return nil
+}
+
+// runPostDeployHooks runs the post-deploy hooks of a desired state after its
+// service has restarted. The deploy has already happened, so a failing hook
+// is recorded as a warning and the remaining hooks still run.
+func runPostDeployHooks(config Config, name Username, state DesiredState, cr *ContainerReport) {
+	for _, unit := range hookUnits(state.Files, postDeploySuffix) {
+		log.Printf("%s: running post-deploy hook %s", name, unit)
+		if err := startHook(name, unit, config.HookTimeout); err != nil {
+			log.Printf("warning: post-deploy hook %s for %s: %v", unit, name, err)
+			cr.warn("post-deploy hook %s failed: %v", unit, err)
+		}
+	}
+}
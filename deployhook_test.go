@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHookUnits(t *testing.T) {
+	files := map[string]string{
+		"app.container":            "",
+		"app-predeploy.service":    "",
+		"app-db-predeploy.service": "",
+		"app-postdeploy.service":   "",
+		"app-refresh.service":      "",
+	}
+	if got, want := hookUnits(files, preDeploySuffix), []string{"app-db-predeploy.service", "app-predeploy.service"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pre = %v, want %v", got, want)
+	}
+	if got, want := hookUnits(files, postDeploySuffix), []string{"app-postdeploy.service"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("post = %v, want %v", got, want)
+	}
+}
+
+func TestRunPostDeployHooks(t *testing.T) {
+	orig := startHook
+	t.Cleanup(func() { startHook = orig })
+	var ran []string
+	startHook = func(_ Username, unit string, timeout time.Duration) error {
+		ran = append(ran, unit)
+		if timeout != time.Minute {
+			t.Errorf("timeout = %s", timeout)
+		}
+		if unit == "a-postdeploy.service" {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+
+	state := DesiredState{Files: map[string]string{
+		"a-postdeploy.service": "",
+		"b-postdeploy.service": "",
+		"a-predeploy.service":  "",
+	}}
+	var cr ContainerReport
+	runPostDeployHooks(Config{HookTimeout: time.Minute}, "app", state, &cr)
+	if want := []string{"a-postdeploy.service", "b-postdeploy.service"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+	if len(cr.Warnings) != 1 || !strings.Contains(cr.Warnings[0], "post-deploy hook a-postdeploy.service failed") {
+		t.Errorf("warnings = %v", cr.Warnings)
+	}
+}
+
+func TestCheckDeployHookOneshot(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.container"), []byte("[Container]\nImage=app\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "app-predeploy.service"), []byte("[Service]\nType=oneshot\nRemainAfterExit=yes\nExecStart=/bin/migrate\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "app-postdeploy.service"), []byte("[Service]\nExecStart=/bin/warm\n"), 0644)
+
+	errs := CheckDir(dir, Selector{})
+	if len(errs) != 2 {
+		t.Fatalf("errors = %v", errs)
+	}
+	got := errs[0].Error() + "\n" + errs[1].Error()
+	for _, want := range []string{
+		"app-predeploy.service:3: deploy hook must not set RemainAfterExit=yes",
+		"app-postdeploy.service:1: deploy hook must set Type=oneshot",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("errors missing %q:\n%s", want, got)
+		}
+	}
+}
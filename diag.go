@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SpecError is a problem found in a spec file, positioned at the line it
+// concerns when that is known. It renders as "file:line: message", the form
+// editors and CI annotations understand.
+type SpecError struct {
+	File string // path of the file, or a label such as "merged output for x"; may be ""
+	Line int    // 1-based line, 0 when the error concerns the whole file
+	Msg  string
+}
+
+func (e *SpecError) Error() string {
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	case e.File != "":
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	default:
+		return e.Msg
+	}
+}
+
+// fileError reports a problem with a file as a whole.
+func fileError(file, format string, args ...any) error {
+	return &SpecError{File: file, Msg: fmt.Sprintf(format, args...)}
+}
+
+// entryError reports a problem with one entry of a parsed file.
+func entryError(e Entry, format string, args ...any) error {
+	return &SpecError{File: e.File, Line: e.Line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// sectionError reports a problem with a section of a parsed file as a whole.
+func sectionError(file string, sec *Section, format string, args ...any) error {
+	return &SpecError{File: file, Line: sec.Line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// githubAnnotation renders err as a GitHub Actions workflow command, so that
+// `quadsync check --format=github` failures show up on the offending line of
+// a pull request.
+func githubAnnotation(err error) string {
+	var se *SpecError
+	if !errors.As(err, &se) || se.File == "" {
+		return "::error::" + escapeGithubData(err.Error())
+	}
+	props := "file=" + escapeGithubProperty(se.File)
+	if se.Line > 0 {
+		props += fmt.Sprintf(",line=%d", se.Line)
+	}
+	return fmt.Sprintf("::error %s::%s", props, escapeGithubData(se.Msg))
+}
+
+// escapeGithubData escapes a workflow command message.
+func escapeGithubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeGithubProperty escapes a workflow command property value.
+func escapeGithubProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
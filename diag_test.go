@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSpecErrorString(t *testing.T) {
+	for _, tc := range []struct {
+		err  *SpecError
+		want string
+	}{
+		{&SpecError{File: "a.container", Line: 3, Msg: "bad"}, "a.container:3: bad"},
+		{&SpecError{File: "a.container", Msg: "bad"}, "a.container: bad"},
+		{&SpecError{Line: 3, Msg: "bad"}, "line 3: bad"},
+		{&SpecError{Msg: "bad"}, "bad"},
+	} {
+		if got := tc.err.Error(); got != tc.want {
+			t.Errorf("Error() = %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestGithubAnnotation(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{&SpecError{File: "web/app.container", Line: 4, Msg: "missing Image= in [Container]"},
+			"::error file=web/app.container,line=4::missing Image= in [Container]"},
+		{fileError("a,b.container", "%s", "100% wrong"), "::error file=a%2Cb.container::100%25 wrong"},
+		{errors.New("app: depends on itself\nreally"), "::error::app: depends on itself%0Areally"},
+	} {
+		if got := githubAnnotation(tc.err); got != tc.want {
+			t.Errorf("githubAnnotation(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
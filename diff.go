@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each hunk.
+const diffContext = 3
+
+// diffOp is one line of an edit script: ' ' (kept), '-' (removed) or '+' (added).
+type diffOp struct {
+	kind byte
+	text string
+}
+
+// unifiedDiff returns a unified diff turning a into b, labelled with the given
+// file names. Returns "" if the contents are identical. Quadlet files are
+// small, so a plain LCS table is plenty.
+func unifiedDiff(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for i := 0; i < len(changes); {
+		// Grow the hunk while the next change is within two contexts' reach.
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*diffContext {
+			j++
+		}
+		start := max(0, changes[i]-diffContext)
+		end := min(len(ops), changes[j]+1+diffContext)
+		writeHunk(&out, ops, start, end)
+		i = j + 1
+	}
+	return out.String()
+}
+
+// writeHunk writes ops[start:end] as a single @@ hunk.
+func writeHunk(out *strings.Builder, ops []diffOp, start, end int) {
+	aStart, bStart := 1, 1
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			aStart++
+		}
+		if op.kind != '-' {
+			bStart++
+		}
+	}
+	aCount, bCount := 0, 0
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			aCount++
+		}
+		if op.kind != '-' {
+			bCount++
+		}
+	}
+	// An empty side is addressed by the line before it, as diff(1) does.
+	if aCount == 0 {
+		aStart--
+	}
+	if bCount == 0 {
+		bStart--
+	}
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+	for _, op := range ops[start:end] {
+		out.WriteByte(op.kind)
+		out.WriteString(op.text)
+		out.WriteByte('\n')
+	}
+}
+
+// diffLines computes a line-level edit script from a to b via longest common
+// subsequence, preferring removals before additions within a change.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// splitLines splits s into lines, ignoring a single trailing newline.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
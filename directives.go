@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// sectionQuadsync holds quadsync's own directives in a spec. It is stripped
+// before deployment, so systemd and quadlet never see it.
+const sectionQuadsync = "X-Quadsync"
+
+// specDirectives are the settings of one spec's [X-Quadsync] section.
+type specDirectives struct {
+	Labels      map[string]string // Label=key=value; a label without "=" has an empty value
+	After       []string          // After=name...: managed containers to bring up first
+	RenamedFrom string            // RenamedFrom=name: managed user this container takes over
+}
+
+// parseDirectives extracts the [X-Quadsync] settings of a parsed spec.
+func parseDirectives(f *INIFile) specDirectives {
+	d := specDirectives{Labels: map[string]string{}}
+	sec := f.GetSection(sectionQuadsync)
+	if sec == nil {
+		return d
+	}
+	for _, e := range sec.Entries {
+		switch e.Key {
+		case "Label":
+			k, v, _ := strings.Cut(e.Value, "=")
+			d.Labels[k] = v
+		case "After":
+			d.After = append(d.After, strings.Fields(e.Value)...)
+		case "RenamedFrom":
+			d.RenamedFrom = e.Value
+		}
+	}
+	return d
+}
+
+// readDirectives reads and parses the [X-Quadsync] section of a spec file.
+func readDirectives(file string) (specDirectives, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return specDirectives{}, err
+	}
+	f, err := ParseINI(strings.NewReader(string(data)))
+	if err != nil {
+		return specDirectives{}, err
+	}
+	return parseDirectives(f), nil
+}
+
+// validateQuadsyncSection checks the directives of a spec's [X-Quadsync]
+// section, if present.
+func validateQuadsyncSection(f *INIFile) []error {
+	sec := f.GetSection(sectionQuadsync)
+	if sec == nil {
+		return nil
+	}
+	var errs []error
+	for _, e := range sec.Entries {
+		switch e.Key {
+		case "":
+		case "Label":
+			k, _, _ := strings.Cut(e.Value, "=")
+			if !validLabelKeyRe.MatchString(k) {
+				errs = append(errs, entryError(e, "[%s] invalid label %q (key must match [a-z0-9][a-z0-9._-]*)", sectionQuadsync, e.Value))
+			}
+		case "After":
+			for _, name := range strings.Fields(e.Value) {
+				if _, err := NewUsername(name); err != nil {
+					errs = append(errs, entryError(e, "[%s] After=: %v", sectionQuadsync, err))
+				}
+			}
+		case "RenamedFrom":
+			if _, err := NewUsername(e.Value); err != nil {
+				errs = append(errs, entryError(e, "[%s] RenamedFrom=: %v", sectionQuadsync, err))
+			}
+		default:
+			errs = append(errs, entryError(e, "[%s] unknown key %s", sectionQuadsync, e.Key))
+		}
+	}
+	return errs
+}
+
+// stripQuadsyncSection removes [X-Quadsync] from a parsed spec.
+func stripQuadsyncSection(ini *INIFile) {
+	stripSection(ini, sectionQuadsync)
+}
+
+// stripSection removes every section called name from a parsed file.
+func stripSection(ini *INIFile, name string) {
+	filtered := ini.Sections[:0]
+	for _, sec := range ini.Sections {
+		if sec.Name == name {
+			continue
+		}
+		filtered = append(filtered, sec)
+	}
+	ini.Sections = filtered
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DriftReport lists managed users whose deployed files no longer match what
+// quadsync deployed.
+type DriftReport struct {
+	Checked int         `json:"checked"`           // users compared
+	Pending []string    `json:"pending,omitempty"` // not compared: the next sync redeploys them anyway
+	Drifted []UserDrift `json:"drifted,omitempty"`
+}
+
+// UserDrift is the file-level drift of one user. Paths are home-relative.
+type UserDrift struct {
+	Name       string   `json:"name"`
+	Modified   []string `json:"modified,omitempty"`   // content differs from the desired state
+	Missing    []string `json:"missing,omitempty"`    // desired but not on disk
+	Unexpected []string `json:"unexpected,omitempty"` // on disk but not desired
+}
+
+// readDeployedFiles reads a user's quadlet and user-unit directories;
+// replaced in tests.
+var readDeployedFiles = readUserFiles
+
+// DetectDrift compares every up-to-date managed user's files against the
+// desired state built from the current checkouts. It does not fetch: drift
+// is measured against what the last sync deployed, and users whose spec has
+// changed since are listed as pending rather than compared.
+func DetectDrift(config Config) (*DriftReport, error) {
+	lockFile, err := lockSync(config)
+	if err != nil {
+		return nil, err
+	}
+	defer lockFile.Close()
+
+	desired, current, err := loadDesired(config, newSyncReport())
+	if err != nil {
+		return nil, err
+	}
+	hashDir := filepath.Join(config.StateDir, "hashes")
+
+	sort.Slice(current, func(i, j int) bool { return current[i] < current[j] })
+	report := &DriftReport{}
+	for _, name := range current {
+		state, ok := desired[name]
+		if !ok {
+			continue // removed by the next sync
+		}
+		if specChanged(hashDir, name, state) {
+			report.Pending = append(report.Pending, string(name))
+			continue
+		}
+		d, err := detectUserDrift(name, state)
+		if err != nil {
+			return nil, err
+		}
+		report.Checked++
+		if d.Drifted() {
+			report.Drifted = append(report.Drifted, d)
+		}
+	}
+	return report, nil
+}
+
+// detectUserDrift reads back one user's managed directories and compares
+// them file by file with state.
+func detectUserDrift(name Username, state DesiredState) (UserDrift, error) {
+	deployed, err := readDeployedFiles(name)
+	if err != nil {
+		return UserDrift{}, err
+	}
+	if state, err = resolveStateUserVars(name, state); err != nil {
+		return UserDrift{}, err
+	}
+	return compareDeployed(name, state, deployed), nil
+}
+
+// compareDeployed classifies the differences between the desired files and
+// the deployed ones (keyed by home-relative path).
+func compareDeployed(name Username, state DesiredState, deployed map[string]string) UserDrift {
+	d := UserDrift{Name: string(name)}
+	want := map[string]bool{}
+	for _, filename := range sortedKeys(state.Files) {
+		path := filepath.Join(userFileDir(filename), filename)
+		want[path] = true
+		content, ok := deployed[path]
+		switch {
+		case !ok:
+			d.Missing = append(d.Missing, path)
+		case content != state.Files[filename]:
+			d.Modified = append(d.Modified, path)
+		}
+	}
+	for _, path := range sortedKeys(deployed) {
+		if !want[path] {
+			d.Unexpected = append(d.Unexpected, path)
+		}
+	}
+	return d
+}
+
+// Drifted reports whether any file differs.
+func (d UserDrift) Drifted() bool {
+	return len(d.Modified) > 0 || len(d.Missing) > 0 || len(d.Unexpected) > 0
+}
+
+// Summary renders the drift on one line, for logs and sync report warnings.
+func (d UserDrift) Summary() string {
+	var parts []string
+	if len(d.Modified) > 0 {
+		parts = append(parts, "modified "+strings.Join(d.Modified, ", "))
+	}
+	if len(d.Missing) > 0 {
+		parts = append(parts, "missing "+strings.Join(d.Missing, ", "))
+	}
+	if len(d.Unexpected) > 0 {
+		parts = append(parts, "unexpected "+strings.Join(d.Unexpected, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// String renders the report for humans (CLI output and the web UI).
+func (r *DriftReport) String() string {
+	var b strings.Builder
+	if len(r.Drifted) == 0 {
+		fmt.Fprintf(&b, "No drift (%d users checked).\n", r.Checked)
+	} else {
+		fmt.Fprintf(&b, "%d of %d users drifted:\n", len(r.Drifted), r.Checked)
+		for _, d := range r.Drifted {
+			fmt.Fprintf(&b, "  %s\n", d.Name)
+			for _, p := range d.Modified {
+				fmt.Fprintf(&b, "    ~ %s\n", p)
+			}
+			for _, p := range d.Missing {
+				fmt.Fprintf(&b, "    - %s\n", p)
+			}
+			for _, p := range d.Unexpected {
+				fmt.Fprintf(&b, "    + %s\n", p)
+			}
+		}
+	}
+	if len(r.Pending) > 0 {
+		fmt.Fprintf(&b, "Not checked (redeployed by the next sync): %s\n", strings.Join(r.Pending, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareDeployed(t *testing.T) {
+	state := DesiredState{Files: map[string]string{
+		"web.container":     "[Container]\nImage=web:2\n",
+		"web-data.volume":   "[Volume]\n",
+		"web-refresh.timer": "[Timer]\nOnCalendar=daily\n",
+	}}
+	deployed := map[string]string{
+		".config/containers/systemd/web.container":   "[Container]\nImage=web:1\n", // hand-edited
+		".config/containers/systemd/web-data.volume": "[Volume]\n",
+		".config/containers/systemd/extra.container": "[Container]\nImage=x\n",
+		".config/systemd/user/old.service":           "[Service]\n",
+		// web-refresh.timer deleted
+	}
+	got := compareDeployed("web", state, deployed)
+	want := UserDrift{
+		Name:       "web",
+		Modified:   []string{".config/containers/systemd/web.container"},
+		Missing:    []string{".config/systemd/user/web-refresh.timer"},
+		Unexpected: []string{".config/containers/systemd/extra.container", ".config/systemd/user/old.service"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if !got.Drifted() {
+		t.Error("expected drift")
+	}
+
+	clean := compareDeployed("web", DesiredState{Files: map[string]string{"web.container": "x"}},
+		map[string]string{".config/containers/systemd/web.container": "x"})
+	if clean.Drifted() {
+		t.Errorf("expected no drift, got %+v", clean)
+	}
+}
+
+func TestDetectUserDrift(t *testing.T) {
+	orig := readDeployedFiles
+	t.Cleanup(func() { readDeployedFiles = orig })
+	readDeployedFiles = func(name Username) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+
+	d, err := detectUserDrift("web", DesiredState{Files: map[string]string{"web.container": "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Summary() != "missing .config/containers/systemd/web.container" {
+		t.Errorf("summary = %q", d.Summary())
+	}
+}
+
+func TestDriftReportString(t *testing.T) {
+	r := &DriftReport{Checked: 3}
+	if got := r.String(); got != "No drift (3 users checked).\n" {
+		t.Errorf("got %q", got)
+	}
+	r.Drifted = []UserDrift{{Name: "web", Modified: []string{"a"}, Unexpected: []string{"b"}}}
+	r.Pending = []string{"db"}
+	got := r.String()
+	for _, want := range []string{"1 of 3 users drifted", "  web\n    ~ a\n    + b\n", "Not checked (redeployed by the next sync): db"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -65,11 +65,13 @@ func editContainerFile(filePath, ageKeyFile string) error {
 	if err := validateSecretsSection(ini); err != nil {
 		return fmt.Errorf("validating %s: %w", absPath, err)
 	}
+	encrypted := secretsEntries(ini)
 	if err := decryptSecretsInPlace(ini, ageKeyFile); err != nil {
 		return fmt.Errorf("decrypting %s: %w", absPath, err)
 	}
+	decrypted := secretsEntries(ini)
 
-	plaintext := []byte(ini.String())
+	plaintext := []byte(ini.Lossless())
 	if ini.GetSection(sectionSecrets) == nil {
 		plaintext = appendSecretsScaffold(plaintext)
 	}
@@ -114,10 +116,11 @@ func editContainerFile(filePath, ageKeyFile string) error {
 		if err != nil {
 			return err
 		}
+		keepUnchangedCiphertext(editedINI, decrypted, encrypted)
 		if err := encryptSecretsInPlace(editedINI, key); err != nil {
 			return fmt.Errorf("encrypting %s: %w", absPath, err)
 		}
-		output = []byte(editedINI.String())
+		output = []byte(editedINI.Lossless())
 		mode = 0600
 	}
 
@@ -193,8 +196,39 @@ func stripEmptySecretsSection(data []byte) []byte {
 			filtered = append(filtered, sec)
 		}
 	}
+	if len(filtered) == len(ini.Sections) {
+		return data
+	}
 	ini.Sections = filtered
-	return []byte(strings.TrimRight(ini.String(), "\n") + "\n")
+	return []byte(strings.TrimRight(ini.Lossless(), "\n") + "\n")
+}
+
+// secretsEntries returns a copy of the entries of ini's [Secrets] section.
+func secretsEntries(ini *INIFile) []Entry {
+	sec := ini.GetSection(sectionSecrets)
+	if sec == nil {
+		return nil
+	}
+	return append([]Entry(nil), sec.Entries...)
+}
+
+// keepUnchangedCiphertext puts back the original entry of every secret the
+// edit left alone, so that re-encrypting does not rewrite its ciphertext.
+// decrypted and encrypted are the [Secrets] entries before editing, with
+// and without decryption.
+func keepUnchangedCiphertext(edited *INIFile, decrypted, encrypted []Entry) {
+	sec := edited.GetSection(sectionSecrets)
+	if sec == nil {
+		return
+	}
+	for i, e := range sec.Entries {
+		for j, d := range decrypted {
+			if e.Key != "" && e.Key == d.Key && e.Value == d.Value && d.Value != encrypted[j].Value {
+				sec.Entries[i] = encrypted[j]
+				break
+			}
+		}
+	}
 }
 
 func writeEditScratch(originalPath string, plaintext []byte) (string, func(), error) {
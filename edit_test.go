@@ -141,3 +141,52 @@ func TestEditContainerFileRejectsCollidingFileSecrets(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestEditContainerFileKeepsUnchangedLines(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "app.container")
+	keyFile := writeTestAgeKey(t)
+	key, err := loadAgeKeyMaterial(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := encryptSecretValue("abc123", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := "# app\n[Container]\nImage = nginx:latest\nExec=serve \\\n  --port 80\n[Secrets]\nEnvironment = TOKEN=" + token + "\n"
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	oldRunEditor := runEditor
+	defer func() { runEditor = oldRunEditor }()
+	runEditor = func(editor []string, gotPath string) error {
+		scratch, err := os.ReadFile(gotPath)
+		if err != nil {
+			return err
+		}
+		// The decrypted secret is the only line rendered afresh.
+		want := strings.Replace(original, "Environment = TOKEN="+token, "Environment=TOKEN=abc123", 1)
+		if string(scratch) != want {
+			t.Errorf("scratch = %q, want %q", scratch, want)
+		}
+		edited := strings.Replace(string(scratch), "Image = nginx:latest", "Image = nginx:1.27", 1)
+		edited += "Environment=OTHER=xyz\n"
+		return os.WriteFile(gotPath, []byte(edited), 0600)
+	}
+
+	t.Setenv("EDITOR", "true")
+	if err := editContainerFile(path, keyFile); err != nil {
+		t.Fatalf("expected edit to succeed, got %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPrefix := strings.Replace(original, "Image = nginx:latest", "Image = nginx:1.27", 1) + "Environment=OTHER=age:"
+	if !strings.HasPrefix(string(got), wantPrefix) {
+		t.Fatalf("edited file = %q, want prefix %q", got, wantPrefix)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// explainColumn is the width key=value lines are padded to before their
+// origin comment in augment --explain output.
+const explainColumn = 40
+
+// explainINI renders a merged file for augment --explain: every key=value
+// line is followed by a comment naming the file and line it came from, and
+// each section ends with the values the merge left out, from notes, and
+// what won over them. The output is for reading, not for deploying.
+func explainINI(f *INIFile, notes []mergeNote) string {
+	bySection := map[string][]mergeNote{}
+	for _, n := range notes {
+		bySection[n.Section] = append(bySection[n.Section], n)
+	}
+
+	var b strings.Builder
+	wroteSection := false
+	for _, sec := range f.Sections {
+		if sec.Name == "" && len(sec.Entries) == 0 {
+			continue
+		}
+		if sec.Name != "" {
+			if wroteSection && !strings.HasSuffix(b.String(), "\n\n") {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "[%s]\n", sec.Name)
+		}
+		for _, e := range sec.Entries {
+			if e.Key == "" {
+				b.WriteString(e.Raw + "\n")
+				continue
+			}
+			fmt.Fprintf(&b, "%-*s # %s\n", explainColumn, entryText(e), entryOrigin(e))
+		}
+		for _, n := range bySection[sec.Name] {
+			fmt.Fprintf(&b, "# %s: %s (%s), by %s (%s)\n", n.Kind,
+				entryText(n.Dropped), entryOrigin(n.Dropped), entryText(n.By), entryOrigin(n.By))
+		}
+		wroteSection = true
+	}
+	return b.String()
+}
+
+// entryText is an entry as a line: key=value, or the raw text of a line
+// without a key (such as a transform's "-Key").
+func entryText(e Entry) string {
+	if e.Key == "" {
+		return strings.TrimSpace(e.Raw)
+	}
+	return e.Key + "=" + e.Value
+}
+
+// entryOrigin names where an entry came from: "file:line", or "quadsync"
+// for an entry quadsync generated rather than read.
+func entryOrigin(e Entry) string {
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d", e.File, e.Line)
+	case e.File != "":
+		return e.File
+	default:
+		return "quadsync"
+	}
+}
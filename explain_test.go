@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplainINI(t *testing.T) {
+	spec, _ := ParseINIFrom(strings.NewReader(`[Container]
+Image=postgres:16
+Network=host
+PublishPort=5432:5432
+Volume=/data:/var/lib/postgresql
+`), "db.container")
+	base, _ := ParseINIFrom(strings.NewReader(`[Container]
+Volume=/srv:/srv
++Volume=/etc/ssl:/etc/ssl:ro
+
+[Service]
+Restart=always
+`), "_base.container")
+	dir, _ := ParseINIFrom(strings.NewReader(`[Container]
+Network!=tailnet.network
+-PublishPort
+`), "infra.container")
+
+	var notes []mergeNote
+	merged := applyTransformsNoted(spec, []*INIFile{base, dir}, &notes)
+	got := explainINI(merged, notes)
+
+	want := `[Container]
+Volume=/etc/ssl:/etc/ssl:ro              # _base.container:3
+Image=postgres:16                        # db.container:2
+Network=tailnet.network                  # infra.container:2
+Volume=/data:/var/lib/postgresql         # db.container:5
+# not applied: Volume=/srv:/srv (_base.container:2), by Volume=/etc/ssl:/etc/ssl:ro (_base.container:3)
+# replaced: Network=host (db.container:3), by Network!=tailnet.network (infra.container:2)
+# removed: PublishPort=5432:5432 (db.container:4), by -PublishPort (infra.container:3)
+
+[Service]
+Restart=always                           # _base.container:6
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMergeTransformKeepsOrigins(t *testing.T) {
+	spec, _ := ParseINIFrom(strings.NewReader("[Container]\nImage=nginx\n"), "web.container")
+	transform, _ := ParseINIFrom(strings.NewReader("[Container]\nVolume+=/a:/a\n\n[Unit]\n+After=b.service\n"), "web-t.container")
+
+	merged := MergeTransform(spec, transform)
+	for _, sec := range merged.Sections {
+		for _, e := range sec.Entries {
+			if e.Key != "" && (e.File == "" || e.Line == 0) {
+				t.Errorf("[%s] %s=%s lost its origin", sec.Name, e.Key, e.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Where host facts are read from, variables so tests can point them at
+// fixtures.
+var (
+	machineIDFile = "/etc/machine-id"
+	osReleaseFile = "/etc/os-release"
+	meminfoFile   = "/proc/meminfo"
+)
+
+// primaryIP is the address the host would use to reach the internet: the
+// source address of the default route. Dialing UDP sends nothing; it only
+// picks the route. A variable so tests can pin it.
+var primaryIP = func() (string, error) {
+	conn, err := net.Dial("udp", "192.0.2.1:9")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// hostFactNames are the facts hostFacts gathers: MachineID, IP, CPUs,
+// MemoryMB, Arch (as in image tags: amd64, arm64, ...), OS and OSVersion
+// (ID and VERSION_ID of os-release). The host's name is {{.Hostname}}.
+var hostFactNames = []string{"MachineID", "IP", "CPUs", "MemoryMB", "Arch", "OS", "OSVersion"}
+
+func isHostFact(name string) bool {
+	for _, f := range hostFactNames {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hostFacts gathers the facts about the host that transforms and companion
+// templates can use as {{.Host.<fact>}}, once per sync. A fact that cannot
+// be read is left out rather than guessed; the containers using it are not
+// deployed (see missingFactsError) and the others are unaffected.
+func hostFacts() map[string]string {
+	facts := map[string]string{
+		"CPUs": strconv.Itoa(runtime.NumCPU()),
+		"Arch": runtime.GOARCH,
+	}
+	if data, err := os.ReadFile(machineIDFile); err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			facts["MachineID"] = id
+		}
+	}
+	if ip, err := primaryIP(); err == nil {
+		facts["IP"] = ip
+	}
+	if mb, ok := memTotalMB(); ok {
+		facts["MemoryMB"] = strconv.Itoa(mb)
+	}
+	if data, err := os.ReadFile(osReleaseFile); err == nil {
+		release := parseEnvFile(string(data))
+		if id := release["ID"]; id != "" {
+			facts["OS"] = id
+		}
+		if v := release["VERSION_ID"]; v != "" {
+			facts["OSVersion"] = v
+		}
+	}
+	return facts
+}
+
+// memTotalMB returns the host's total memory in MiB, from MemTotal in
+// /proc/meminfo.
+func memTotalMB() (int, bool) {
+	f, err := os.Open(meminfoFile)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return 0, false
+			}
+			return kb / 1024, true
+		}
+	}
+	return 0, false
+}
+
+// missingFactsError reports the host facts the files of state use that this
+// host could not determine, if any.
+func missingFactsError(state DesiredState) error {
+	if len(state.MissingFacts) == 0 {
+		return nil
+	}
+	var vars []string
+	for _, m := range state.MissingFacts {
+		vars = appendNew(vars, m.Var)
+	}
+	return fmt.Errorf("host facts not available on this host: %s (used in %s)", strings.Join(vars, ", "), state.MissingFacts[0].File)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pinHostFacts points the host fact sources at fixtures in a temp dir.
+func pinHostFacts(t *testing.T, ip string) string {
+	t.Helper()
+	dir := t.TempDir()
+	origMachineID, origOSRelease, origMeminfo := machineIDFile, osReleaseFile, meminfoFile
+	origIP, origHost := primaryIP, hostName
+	t.Cleanup(func() {
+		machineIDFile, osReleaseFile, meminfoFile = origMachineID, origOSRelease, origMeminfo
+		primaryIP, hostName = origIP, origHost
+	})
+	machineIDFile = filepath.Join(dir, "machine-id")
+	osReleaseFile = filepath.Join(dir, "os-release")
+	meminfoFile = filepath.Join(dir, "meminfo")
+	os.WriteFile(machineIDFile, []byte("0123456789abcdef\n"), 0644)
+	os.WriteFile(osReleaseFile, []byte("NAME=\"Fedora Linux\"\nID=fedora\nVERSION_ID=40\n"), 0644)
+	os.WriteFile(meminfoFile, []byte("MemTotal:       16384000 kB\nMemFree:         1024000 kB\n"), 0644)
+	primaryIP = func() (string, error) { return ip, nil }
+	hostName = func() (string, error) { return "node1", nil }
+	return dir
+}
+
+func TestHostFacts(t *testing.T) {
+	pinHostFacts(t, "10.0.0.5")
+	facts := hostFacts()
+	want := map[string]string{
+		"MachineID": "0123456789abcdef",
+		"IP":        "10.0.0.5",
+		"CPUs":      strconv.Itoa(runtime.NumCPU()),
+		"MemoryMB":  "16000",
+		"Arch":      runtime.GOARCH,
+		"OS":        "fedora",
+		"OSVersion": "40",
+	}
+	for k, v := range want {
+		if facts[k] != v {
+			t.Errorf("%s = %q, want %q", k, facts[k], v)
+		}
+	}
+
+	// Facts that cannot be read are left out rather than guessed.
+	os.Remove(machineIDFile)
+	primaryIP = func() (string, error) { return "", errors.New("network is unreachable") }
+	facts = hostFacts()
+	for _, k := range []string{"MachineID", "IP"} {
+		if _, ok := facts[k]; ok {
+			t.Errorf("%s = %q, want it left out", k, facts[k])
+		}
+	}
+}
+
+func TestHostFactsRedeploy(t *testing.T) {
+	pinHostFacts(t, "10.0.0.5")
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "web.container"), []byte("[Container]\nImage=nginx\n"), 0644)
+	os.WriteFile(filepath.Join(repo, "db.container"), []byte("[Container]\nImage=postgres:16-{{.Host.Arch}}\n"), 0644)
+	tdir := t.TempDir()
+	os.WriteFile(filepath.Join(tdir, "_base-metrics.container"),
+		[]byte("[Container]\nImage=exporter\nPublishPort={{.Host.IP}}:9100:9100\n"), 0644)
+	tr, err := loadAllTransforms(tdir)
+	if err != nil {
+		t.Fatalf("loadAllTransforms: %v", err)
+	}
+	tr.Facts = hostFacts()
+
+	d1, err := buildDesiredFull(repo, tr)
+	if err != nil {
+		t.Fatalf("buildDesiredFull: %v", err)
+	}
+	if got := d1["web"].Files["web-metrics.container"]; !strings.Contains(got, "PublishPort=10.0.0.5:9100:9100\n") {
+		t.Errorf("web-metrics.container:\n%s", got)
+	}
+	if got := d1["db"].Files["db.container"]; !strings.Contains(got, "Image=postgres:16-"+runtime.GOARCH+"\n") {
+		t.Errorf("db.container:\n%s", got)
+	}
+
+	primaryIP = func() (string, error) { return "10.0.0.6", nil }
+	tr.Facts = hostFacts()
+	d2, err := buildDesiredFull(repo, tr)
+	if err != nil {
+		t.Fatalf("buildDesiredFull: %v", err)
+	}
+	for _, name := range []Username{"web", "db"} {
+		if compositeHash(d1[name]) == compositeHash(d2[name]) {
+			t.Errorf("%s: hash unchanged after the host's IP changed", name)
+		}
+	}
+}
+
+func TestMissingHostFact(t *testing.T) {
+	pinHostFacts(t, "10.0.0.5")
+	primaryIP = func() (string, error) { return "", errors.New("network is unreachable") }
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "web.container"), []byte("[Container]\nImage=nginx\nPublishPort={{.Host.IP}}:80:80\n"), 0644)
+	os.WriteFile(filepath.Join(repo, "db.container"), []byte("[Container]\nImage=postgres:16-{{.Host.Arch}}\n"), 0644)
+	os.WriteFile(filepath.Join(repo, "bad.container"), []byte("[Container]\nImage=x\nLabel={{.Host.Bogus}}\n"), 0644)
+	tr := Transforms{Facts: hostFacts()}
+
+	desired, err := buildDesiredFull(repo, tr)
+	if err != nil {
+		t.Fatalf("buildDesiredFull: %v", err)
+	}
+	// Only the container using the missing fact is held back.
+	err = missingFactsError(desired["web"])
+	if err == nil || !strings.Contains(err.Error(), "{{.Host.IP}} (used in web.container)") {
+		t.Errorf("web: error = %v", err)
+	}
+	if err := missingFactsError(desired["db"]); err != nil {
+		t.Errorf("db: unexpected error %v", err)
+	}
+	plan, err := buildPlan(t.TempDir(), Config{}, map[Username]DesiredState{"web": desired["web"]}, nil)
+	if err != nil {
+		t.Fatalf("buildPlan: %v", err)
+	}
+	if len(plan.Redeploy) != 1 || !strings.Contains(plan.Redeploy[0].Error, "{{.Host.IP}}") {
+		t.Errorf("plan.Redeploy = %+v", plan.Redeploy)
+	}
+
+	// A missing fact is not a check error; a fact that does not exist is.
+	var msgs []string
+	for _, e := range CheckDesired(desired) {
+		msgs = append(msgs, e.Error())
+	}
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "unknown template variable {{.Host.Bogus}}") {
+		t.Errorf("CheckDesired errors = %v", msgs)
+	}
+}
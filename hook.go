@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxHookBody bounds a webhook payload. Push payloads list commits and can be
+// large, but never this large.
+const maxHookBody = 5 << 20
+
+// The receiver faces the internet, so a client that trickles its request or
+// holds a connection open must not tie it up.
+const (
+	hookReadHeaderTimeout = 10 * time.Second
+	hookReadTimeout       = 30 * time.Second
+	hookWriteTimeout      = 30 * time.Second
+	hookIdleTimeout       = 60 * time.Second
+	hookMaxHeaderBytes    = 64 << 10
+)
+
+// cmdHook runs the unprivileged git webhook receiver. Like webui it holds no
+// host privilege: a verified push to a deployed branch is forwarded to
+// `quadsync serve` as an OpSync over the control socket.
+func cmdHook() {
+	fs := flag.NewFlagSet("hook", flag.ExitOnError)
+	addr := fs.String("addr", ":8766", "listen address")
+	socketPath := fs.String("socket", defaultControlSocket, "control socket path")
+	secretFile := fs.String("secret-file", "", "file holding the webhook secret (required)")
+	branch := fs.String("branch", "", "branch whose pushes trigger a sync (default: the branches in "+configPath+")")
+	_ = fs.Parse(os.Args[2:])
+
+	if *secretFile == "" {
+		log.Fatal("hook: -secret-file is required")
+	}
+	data, err := os.ReadFile(*secretFile)
+	if err != nil {
+		log.Fatalf("hook: reading secret: %v", err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		log.Fatalf("hook: %s is empty", *secretFile)
+	}
+
+	var branches []string
+	if *branch != "" {
+		branches = []string{*branch}
+	} else {
+		cfg, err := LoadConfig(configPath)
+		if err != nil {
+			log.Fatalf("hook: loading config (or pass -branch): %v", err)
+		}
+		for _, src := range cfg.Sources {
+			branches = append(branches, src.Branch)
+		}
+	}
+
+	h := newHookHandler(secret, branches, func() error {
+		resp, err := callSocket(*socketPath, Request{Op: OpSync})
+		if err != nil {
+			return err
+		}
+		if !resp.OK {
+			return errors.New(resp.Error)
+		}
+		return nil
+	})
+	go h.forwardLoop()
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /", h)
+	log.Printf("hook listening on %s (branches %s, socket %s)", *addr, strings.Join(branches, ","), *socketPath)
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: hookReadHeaderTimeout,
+		ReadTimeout:       hookReadTimeout,
+		WriteTimeout:      hookWriteTimeout,
+		IdleTimeout:       hookIdleTimeout,
+		MaxHeaderBytes:    hookMaxHeaderBytes,
+	}
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatalf("hook: %v", err)
+	}
+}
+
+// hookHandler verifies push webhooks and queues a sync for pushes to one of
+// branches. Syncs are forwarded by forwardLoop, outside the request, because
+// a sync can outlast the sender's delivery timeout; pushes arriving while a
+// sync is queued coalesce into it.
+type hookHandler struct {
+	secret   string
+	branches map[string]bool // full refs, e.g. refs/heads/main
+	forward  func() error
+	pending  chan struct{}
+}
+
+func newHookHandler(secret string, branches []string, forward func() error) *hookHandler {
+	h := &hookHandler{
+		secret:   secret,
+		branches: map[string]bool{},
+		forward:  forward,
+		pending:  make(chan struct{}, 1),
+	}
+	for _, b := range branches {
+		h.branches["refs/heads/"+b] = true
+	}
+	return h
+}
+
+// errHookAuth marks deliveries that fail authentication.
+var errHookAuth = errors.New("authentication failed")
+
+// hookEvent is what a provider's delivery boils down to.
+type hookEvent struct {
+	Provider string // github, gitlab, gitea
+	Push     bool   // a push event (others, such as ping, are acknowledged and ignored)
+	Ref      string // pushed ref, from the payload
+}
+
+func (h *hookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHookBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "reading body", http.StatusBadRequest)
+		return
+	}
+
+	ev, err := parseHook(r.Header, body, h.secret)
+	if err != nil {
+		log.Printf("hook: rejected delivery from %s: %v", r.RemoteAddr, err)
+		code := http.StatusBadRequest
+		if errors.Is(err, errHookAuth) {
+			code = http.StatusUnauthorized
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if !ev.Push {
+		fmt.Fprintln(w, "ignored: not a push event")
+		return
+	}
+	if !h.branches[ev.Ref] {
+		fmt.Fprintf(w, "ignored: %s is not a deployed branch\n", ev.Ref)
+		return
+	}
+
+	select {
+	case h.pending <- struct{}{}:
+	default: // a sync is already queued and will see this push
+	}
+	log.Printf("hook: %s push to %s, sync queued", ev.Provider, ev.Ref)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "sync queued")
+}
+
+// forwardLoop runs a forwarded sync for each queued push. It never returns.
+func (h *hookHandler) forwardLoop() {
+	for range h.pending {
+		if err := h.forward(); err != nil {
+			log.Printf("hook: sync failed: %v", err)
+		}
+	}
+}
+
+// parseHook identifies the provider from its headers, authenticates the
+// delivery and extracts the pushed ref. GitHub and Gitea sign the body with
+// HMAC-SHA256; GitLab sends the shared secret as a token.
+func parseHook(header http.Header, body []byte, secret string) (hookEvent, error) {
+	var ev hookEvent
+	switch {
+	case header.Get("X-Gitea-Event") != "" || header.Get("X-Forgejo-Event") != "":
+		// Checked before GitHub: Gitea also sends X-GitHub-Event for compatibility.
+		ev.Provider = "gitea"
+		sig := header.Get("X-Gitea-Signature")
+		if sig == "" {
+			sig = header.Get("X-Forgejo-Signature")
+		}
+		if !validHMAC(body, secret, sig) {
+			return ev, fmt.Errorf("%w: invalid signature", errHookAuth)
+		}
+		ev.Push = header.Get("X-Gitea-Event") == "push" || header.Get("X-Forgejo-Event") == "push"
+	case header.Get("X-GitHub-Event") != "":
+		ev.Provider = "github"
+		sig, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok || !validHMAC(body, secret, sig) {
+			return ev, fmt.Errorf("%w: invalid signature", errHookAuth)
+		}
+		ev.Push = header.Get("X-GitHub-Event") == "push"
+	case header.Get("X-Gitlab-Event") != "":
+		ev.Provider = "gitlab"
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return ev, fmt.Errorf("%w: invalid token", errHookAuth)
+		}
+		ev.Push = header.Get("X-Gitlab-Event") == "Push Hook"
+	default:
+		return ev, errors.New("unrecognised webhook (expected GitHub, GitLab or Gitea headers)")
+	}
+	if !ev.Push {
+		return ev, nil
+	}
+
+	var payload struct {
+		Ref string `json:"ref"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ev, fmt.Errorf("decoding payload: %w", err)
+	}
+	ev.Ref = payload.Ref
+	return ev, nil
+}
+
+// validHMAC reports whether sig is the hex HMAC-SHA256 of body under secret.
+func validHMAC(body []byte, secret, sig string) bool {
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseHook(t *testing.T) {
+	const secret = "s3cret"
+	const body = `{"ref":"refs/heads/main"}`
+	tests := []struct {
+		name     string
+		header   map[string]string
+		wantErr  bool
+		wantPush bool
+	}{
+		{"github push", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(secret, body)}, false, true},
+		{"github ping", map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": "sha256=" + sign(secret, body)}, false, false},
+		{"github bad signature", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign("wrong", body)}, true, false},
+		{"github unsigned", map[string]string{"X-GitHub-Event": "push"}, true, false},
+		{"gitea push", map[string]string{"X-Gitea-Event": "push", "X-GitHub-Event": "push", "X-Gitea-Signature": sign(secret, body)}, false, true},
+		{"gitea bad signature", map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": "zz"}, true, false},
+		{"forgejo push", map[string]string{"X-Forgejo-Event": "push", "X-Forgejo-Signature": sign(secret, body)}, false, true},
+		{"gitlab push", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": secret}, false, true},
+		{"gitlab tag push", map[string]string{"X-Gitlab-Event": "Tag Push Hook", "X-Gitlab-Token": secret}, false, false},
+		{"gitlab bad token", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "nope"}, true, false},
+		{"unknown provider", map[string]string{}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.header {
+				h.Set(k, v)
+			}
+			ev, err := parseHook(h, []byte(body), secret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ev.Push != tt.wantPush {
+				t.Errorf("push = %v, want %v", ev.Push, tt.wantPush)
+			}
+			if tt.wantPush && ev.Ref != "refs/heads/main" {
+				t.Errorf("ref = %q", ev.Ref)
+			}
+		})
+	}
+}
+
+func TestHookHandler(t *testing.T) {
+	forwarded := make(chan struct{}, 10)
+	h := newHookHandler("s3cret", []string{"main"}, func() error {
+		forwarded <- struct{}{}
+		return nil
+	})
+	go h.forwardLoop()
+
+	post := func(body string, header map[string]string) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	github := func(body string) map[string]string {
+		return map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign("s3cret", body)}
+	}
+
+	other := `{"ref":"refs/heads/feature"}`
+	if code := post(other, github(other)); code != http.StatusOK {
+		t.Errorf("push to other branch: code %d", code)
+	}
+	push := `{"ref":"refs/heads/main"}`
+	if code := post(push, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=00"}); code != http.StatusUnauthorized {
+		t.Errorf("bad signature: code %d", code)
+	}
+	if code := post("not json", github("not json")); code != http.StatusBadRequest {
+		t.Errorf("bad payload: code %d", code)
+	}
+	huge := strings.Repeat("x", maxHookBody+1)
+	if code := post(huge, github(huge)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized payload: code %d", code)
+	}
+	select {
+	case <-forwarded:
+		t.Fatal("sync forwarded for an ignored delivery")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if code := post(push, github(push)); code != http.StatusAccepted {
+		t.Errorf("push to main: code %d", code)
+	}
+	select {
+	case <-forwarded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync not forwarded")
+	}
+}
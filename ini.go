@@ -1,23 +1,50 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"strings"
 )
 
 // Entry is a single line within an INI section: a key=value pair or a comment/blank line.
+// A key=value pair continued over several lines (trailing "\\") is one Entry.
 type Entry struct {
 	Key   string // empty for comments and blank lines
 	Value string
 	Raw   string // original line text (used for comments/blanks)
+	File  string // file the entry was read from, "" if not read from a file
+	Line  int    // 1-based line the entry starts on, 0 if not parsed from text
+
+	src entrySource // what the entry was parsed from, for Lossless
+}
+
+// entrySource is the text an entry was parsed from, line endings and any
+// continuation lines included, and the key, value and raw text it was
+// parsed as.
+type entrySource struct {
+	text, key, value, raw string
+}
+
+// unmodified reports whether e was parsed from text and still holds what
+// it was parsed as.
+func (e Entry) unmodified() bool {
+	return e.src.text != "" && e.Key == e.src.key && e.Value == e.src.value && e.Raw == e.src.raw
 }
 
 // Section is a named group of entries in an INI file.
 type Section struct {
 	Name    string
 	Entries []Entry
+	Line    int // 1-based line of the [Section] header, 0 for the preamble
+
+	// header is the header line as read and after the line before it, line
+	// endings included, for Lossless.
+	header, after string
+}
+
+// headerUnmodified reports whether s was parsed from text under its current name.
+func (s Section) headerUnmodified() bool {
+	return s.header != "" && strings.TrimSpace(s.header) == "["+s.Name+"]"
 }
 
 // INIFile represents a parsed INI file as an ordered list of sections.
@@ -29,44 +56,97 @@ type INIFile struct {
 // ParseINI parses a Quadlet-style INI file from a reader.
 // It handles [Section] headers, Key=Value lines, # comments, and blank lines.
 func ParseINI(r io.Reader) (*INIFile, error) {
+	return ParseINIFrom(r, "")
+}
+
+// ParseINIFrom is ParseINI for text read from file, which is recorded in
+// every entry along with its line number for error messages.
+//
+// As in systemd, a key=value line ending in a backslash continues on the
+// next line: the backslash is replaced by a space and the lines form one
+// value. Comment lines inside a continuation are skipped.
+func ParseINIFrom(r io.Reader, file string) (*INIFile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("parsing INI: %w", err)
+	}
+
 	f := &INIFile{}
 	current := Section{Name: ""}
-	scanner := bufio.NewScanner(r)
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	var cont *Entry // key=value entry being continued, if any
+	prev := ""      // previous physical line
+	endCont := func() {
+		cont.Value = strings.TrimSpace(cont.Value)
+		cont.src.key, cont.src.value = cont.Key, cont.Value
+		current.Entries = append(current.Entries, *cont)
+		cont = nil
+	}
+	for i, text := range strings.SplitAfter(string(data), "\n") {
+		if text == "" {
+			break // SplitAfter yields an empty last element after a final newline
+		}
+		after := prev
+		prev = text
+		lineNo := i + 1
+		line := strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
 		trimmed := strings.TrimSpace(line)
 
+		if cont != nil {
+			cont.src.text += text
+			if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+				continue
+			}
+			part, more := cutContinuation(trimmed)
+			cont.Value += " " + part
+			if !more {
+				endCont()
+			}
+			continue
+		}
+
 		// Section header
 		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
 			f.Sections = append(f.Sections, current)
-			current = Section{Name: trimmed[1 : len(trimmed)-1]}
+			current = Section{Name: trimmed[1 : len(trimmed)-1], Line: lineNo, header: text, after: after}
 			continue
 		}
 
-		// Comment or blank line
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
-			current.Entries = append(current.Entries, Entry{Raw: line})
+		// Key=Value (split on first =); anything else (comments, blank and
+		// bare lines) is kept as-is
+		idx := strings.Index(line, "=")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") || idx < 0 {
+			current.Entries = append(current.Entries, Entry{Raw: line, File: file, Line: lineNo,
+				src: entrySource{text: text, raw: line}})
 			continue
 		}
-
-		// Key=Value (split on first =)
-		if idx := strings.Index(line, "="); idx >= 0 {
-			key := strings.TrimSpace(line[:idx])
-			value := strings.TrimSpace(line[idx+1:])
-			current.Entries = append(current.Entries, Entry{Key: key, Value: value})
-		} else {
-			// Bare line (no = sign), preserve as-is
-			current.Entries = append(current.Entries, Entry{Raw: line})
+		key := strings.TrimSpace(line[:idx])
+		value, more := cutContinuation(strings.TrimSpace(line[idx+1:]))
+		e := Entry{Key: key, Value: value, File: file, Line: lineNo,
+			src: entrySource{text: text, key: key, value: value}}
+		if more {
+			cont = &e
+			continue
 		}
+		current.Entries = append(current.Entries, e)
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("parsing INI: %w", err)
+	if cont != nil {
+		// A continuation at end of file ends the value, as in systemd.
+		endCont()
 	}
 	f.Sections = append(f.Sections, current)
 	return f, nil
 }
 
+// cutContinuation strips a trailing line-continuation backslash from a
+// trimmed line, reporting whether there was one.
+func cutContinuation(s string) (string, bool) {
+	if rest, ok := strings.CutSuffix(s, "\\"); ok {
+		return strings.TrimSpace(rest), true
+	}
+	return s, false
+}
+
 // String renders the INI file back to text.
 func (f *INIFile) String() string {
 	var b strings.Builder
@@ -95,6 +175,59 @@ func (f *INIFile) String() string {
 	return b.String()
 }
 
+// Lossless renders the INI file back to text like String, except that
+// entries and section headers that are unchanged since parsing are written
+// exactly as they were read: spacing, continuation lines, comments inside
+// them and line endings included. Use it where a file is rewritten in place
+// or shown to its author, so that only what changed shows up in a diff.
+func (f *INIFile) Lossless() string {
+	var b strings.Builder
+	put := func(s string) {
+		// A line read without a final newline gets one once more follows.
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString(s)
+	}
+	wroteSection := false
+	for _, sec := range f.Sections {
+		if sec.Name == "" && len(sec.Entries) == 0 {
+			continue
+		}
+		// Separate the section from what comes before it as String does,
+		// unless that is the line it followed when read.
+		inPlace := sec.headerUnmodified() && endsWithLine(b.String(), sec.after)
+		if sec.Name != "" && wroteSection && !inPlace && !strings.HasSuffix(b.String(), "\n\n") {
+			put("\n")
+		}
+		switch {
+		case sec.Name == "":
+		case sec.headerUnmodified():
+			put(sec.header)
+		default:
+			put(fmt.Sprintf("[%s]\n", sec.Name))
+		}
+		for _, e := range sec.Entries {
+			switch {
+			case e.unmodified():
+				put(e.src.text)
+			case e.Key != "":
+				put(fmt.Sprintf("%s=%s\n", e.Key, e.Value))
+			default:
+				put(e.Raw + "\n")
+			}
+		}
+		wroteSection = true
+	}
+	return b.String()
+}
+
+// endsWithLine reports whether s ends with the whole line line.
+func endsWithLine(s, line string) bool {
+	rest, ok := strings.CutSuffix(s, line)
+	return ok && (rest == "" || strings.HasSuffix(rest, "\n"))
+}
+
 // GetSection returns the section with the given name, or nil if not found.
 func (f *INIFile) GetSection(name string) *Section {
 	for i := range f.Sections {
@@ -105,3 +105,82 @@ Restart=on-failure
 		t.Error("comment not preserved in output")
 	}
 }
+
+func TestParseINIPositions(t *testing.T) {
+	input := "# preamble\n[Container]\nImage=nginx\n\n[Service]\nRestart=always\n"
+	f, err := ParseINIFrom(strings.NewReader(input), "web.container")
+	if err != nil {
+		t.Fatalf("ParseINIFrom error: %v", err)
+	}
+	if got := f.GetSection("Container").Line; got != 2 {
+		t.Errorf("[Container] line = %d, want 2", got)
+	}
+	e := f.GetSection("Service").Entries[0]
+	if e.Key != "Restart" || e.File != "web.container" || e.Line != 6 {
+		t.Errorf("entry = %+v, want Restart at web.container:6", e)
+	}
+}
+
+func TestParseINIContinuation(t *testing.T) {
+	input := `[Container]
+Image=nginx
+Exec=/bin/sh -c \
+  'echo hi' \
+# a comment inside the value
+  --flag
+Label=a=b
+Volume=/data \
+`
+	f, err := ParseINI(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseINI error: %v", err)
+	}
+	sec := f.GetSection("Container")
+	var got []Entry
+	for _, e := range sec.Entries {
+		got = append(got, Entry{Key: e.Key, Value: e.Value, Line: e.Line})
+	}
+	want := []Entry{
+		{Key: "Image", Value: "nginx", Line: 2},
+		{Key: "Exec", Value: "/bin/sh -c 'echo hi' --flag", Line: 3},
+		{Key: "Label", Value: "a=b", Line: 7},
+		{Key: "Volume", Value: "/data", Line: 8},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("entries = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestINILossless(t *testing.T) {
+	input := "# spec\r\n[Container]  \r\nImage = nginx\r\nExec=/bin/sh -c \\\r\n  # inline\r\n    'echo hi'\r\n\r\n\r\n[Service]\r\nRestart=always  "
+	f, err := ParseINI(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseINI error: %v", err)
+	}
+	if got := f.Lossless(); got != input {
+		t.Errorf("lossless round-trip mismatch:\n got %q\nwant %q", got, input)
+	}
+
+	f.GetSection("Container").Entries[0].Value = "caddy"
+	f.GetSection("Service").Entries = append(f.GetSection("Service").Entries, Entry{Key: "RestartSec", Value: "5"})
+	f.Sections = append(f.Sections, Section{Name: "Install", Entries: []Entry{{Key: "WantedBy", Value: "default.target"}}})
+	want := "# spec\r\n[Container]  \r\nImage=caddy\nExec=/bin/sh -c \\\r\n  # inline\r\n    'echo hi'\r\n\r\n\r\n[Service]\r\nRestart=always  \nRestartSec=5\n\n[Install]\nWantedBy=default.target\n"
+	if got := f.Lossless(); got != want {
+		t.Errorf("lossless after edits:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestINILosslessMerge(t *testing.T) {
+	spec, _ := ParseINI(strings.NewReader("[Container]\nImage =  nginx\n\n[Install]\nWantedBy = default.target\n"))
+	transform, _ := ParseINI(strings.NewReader("[Container]\n+Label=managed\nPull=newer\n"))
+	got := MergeTransform(spec, transform).Lossless()
+	want := "[Container]\nLabel=managed\nImage =  nginx\n\nPull=newer\n\n[Install]\nWantedBy = default.target\n"
+	if got != want {
+		t.Errorf("merged lossless:\n got %q\nwant %q", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -30,10 +31,16 @@ func main() {
 		cmdEdit()
 	case "redeploy":
 		cmdRedeploy()
+	case "purge":
+		cmdPurge()
+	case "drift":
+		cmdDrift()
 	case "serve":
 		cmdServe()
 	case "webui":
 		cmdWebUI()
+	case "hook":
+		cmdHook()
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", os.Args[1])
 		usage()
@@ -44,53 +51,149 @@ func main() {
 func usage() {
 	fmt.Fprintln(os.Stderr, "Usage:")
 	fmt.Fprintln(os.Stderr, "  quadsync sync              Full reconcile (git-sync, merge, deploy)")
+	fmt.Fprintln(os.Stderr, "  quadsync sync --plan       Show what a sync would change without deploying")
+	fmt.Fprintln(os.Stderr, "  quadsync sync --allow-mass-delete")
+	fmt.Fprintln(os.Stderr, "                             Sync, going ahead with removals the mass-deletion guard refuses")
 	fmt.Fprintln(os.Stderr, "  quadsync check <dir>       Validate .container files")
+	fmt.Fprintln(os.Stderr, "  quadsync check --host <config.env> <dir>")
+	fmt.Fprintln(os.Stderr, "                             Validate and list what a host selects")
+	fmt.Fprintln(os.Stderr, "  quadsync check --format=github <dir>")
+	fmt.Fprintln(os.Stderr, "                             Report errors as GitHub Actions annotations")
 	fmt.Fprintln(os.Stderr, "  quadsync augment <file>    Print merged result to stdout")
+	fmt.Fprintln(os.Stderr, "  quadsync augment --explain <file>")
+	fmt.Fprintln(os.Stderr, "                             Mark each merged line with the file and line it came from")
+	fmt.Fprintln(os.Stderr, "  quadsync augment --diff <file>")
+	fmt.Fprintln(os.Stderr, "                             Print the merged result as a diff against the spec")
 	fmt.Fprintln(os.Stderr, "  quadsync edit <file>       Edit a .container file, decrypting and re-encrypting secrets")
 	fmt.Fprintln(os.Stderr, "  quadsync redeploy <name>   Force redeployment on next sync")
+	fmt.Fprintln(os.Stderr, "  quadsync purge <name>      Delete a tombstoned user and its data now")
+	fmt.Fprintln(os.Stderr, "  quadsync drift             Report deployed files that differ from the desired state")
 	fmt.Fprintln(os.Stderr, "  quadsync serve             Run the control-socket daemon (root)")
 	fmt.Fprintln(os.Stderr, "  quadsync webui             Run the HTTP status/control frontend")
+	fmt.Fprintln(os.Stderr, "  quadsync hook              Run the git webhook receiver")
 }
 
 func cmdSync() {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	plan := fs.Bool("plan", false, "print the users, redeploys (with diffs), prunes and removals a sync would perform, without applying them")
+	allowMassDelete := fs.Bool("allow-mass-delete", false, "go ahead with removals the mass-deletion guard would refuse")
+	_ = fs.Parse(os.Args[2:])
+
 	cfg, err := LoadConfig(configPath)
 	if err != nil {
 		log.Fatalf("loading config: %v", err)
 	}
+	cfg.AllowMassDelete = *allowMassDelete
+	if *plan {
+		p, err := PlanSync(cfg)
+		if err != nil {
+			log.Fatalf("plan failed: %v", err)
+		}
+		fmt.Print(p.String())
+		return
+	}
 	if err := Sync(cfg); err != nil {
 		log.Fatalf("sync failed: %v", err)
 	}
 }
 
 func cmdCheck() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "Usage: quadsync check <dir>")
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	var hosts []string
+	fs.Func("host", "validate and list the specs selected by this host's config.env (repeatable)", func(v string) error {
+		hosts = append(hosts, v)
+		return nil
+	})
+	format := fs.String("format", "text", "error output format: text (file:line: message) or github (Actions annotations)")
+	_ = fs.Parse(os.Args[2:])
+	if fs.NArg() != 1 || (*format != "text" && *format != "github") {
+		fmt.Fprintln(os.Stderr, "Usage: quadsync check [--host <config.env>]... [--format=text|github] <dir>")
 		os.Exit(2)
 	}
-	dir := os.Args[2]
-	errs := CheckDir(dir)
-	if len(errs) > 0 {
-		for _, e := range errs {
-			fmt.Fprintln(os.Stderr, e)
+	dir := fs.Arg(0)
+
+	if len(hosts) == 0 {
+		errs := CheckDir(dir, Selector{})
+		if len(errs) > 0 {
+			printCheckErrors(errs, *format, "")
+			os.Exit(1)
+		}
+		fmt.Println("All checks passed.")
+		return
+	}
+
+	failed := false
+	for _, host := range hosts {
+		if !checkHost(dir, host, *format) {
+			failed = true
 		}
+	}
+	if failed {
 		os.Exit(1)
 	}
-	fmt.Println("All checks passed.")
+}
+
+// checkHost validates dir as the host configured by hostConfig would see it
+// and prints the specs it selects. Returns false if anything failed.
+func checkHost(dir, hostConfig, format string) bool {
+	label := strings.TrimSuffix(filepath.Base(hostConfig), ".env")
+	data, err := os.ReadFile(hostConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", label, err)
+		return false
+	}
+	sel, err := parseSelector(parseEnvFile(string(data)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", label, err)
+		return false
+	}
+	specs, err := SelectedSpecs(dir, sel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", label, err)
+		return false
+	}
+	fmt.Printf("%s (%s): %d selected\n", label, sel, len(specs))
+	for _, s := range specs {
+		fmt.Printf("  %s\n", s)
+	}
+	errs := CheckDir(dir, sel)
+	printCheckErrors(errs, format, label)
+	return len(errs) == 0
+}
+
+// printCheckErrors reports check errors in the given format: text goes to
+// stderr, prefixed with the host label if any; github annotations go to
+// stdout, where the Actions runner picks them up.
+func printCheckErrors(errs []error, format, label string) {
+	for _, e := range errs {
+		switch {
+		case format == "github":
+			fmt.Println(githubAnnotation(e))
+		case label != "":
+			fmt.Fprintf(os.Stderr, "%s: %v\n", label, e)
+		default:
+			fmt.Fprintln(os.Stderr, e)
+		}
+	}
 }
 
 func cmdAugment() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "Usage: quadsync augment <file>")
+	fs := flag.NewFlagSet("augment", flag.ExitOnError)
+	explain := fs.Bool("explain", false, "mark each line with the file and line it came from, and list the values the merge left out")
+	diff := fs.Bool("diff", false, "print the merged result as a unified diff against the spec")
+	_ = fs.Parse(os.Args[2:])
+	if fs.NArg() != 1 || (*explain && *diff) {
+		fmt.Fprintln(os.Stderr, "Usage: quadsync augment [--explain | --diff] <file>")
 		os.Exit(2)
 	}
 
-	filePath := os.Args[2]
+	filePath := fs.Arg(0)
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		log.Fatalf("reading %s: %v", filePath, err)
 	}
 
-	spec, err := ParseINI(strings.NewReader(string(data)))
+	spec, err := ParseINIFrom(strings.NewReader(string(data)), filePath)
 	if err != nil {
 		log.Fatalf("parsing %s: %v", filePath, err)
 	}
@@ -101,38 +204,32 @@ func cmdAugment() {
 		log.Fatalf("loading config: %v", err)
 	}
 
-	transforms, err := loadAllTransforms(cfg.TransformDir)
+	dir, transformDir := augmentTarget(cfg, filePath)
+	transforms, err := loadAllTransforms(transformDir)
 	if err != nil {
 		log.Fatalf("loading transforms: %v", err)
 	}
 
-	dir := parentDirName(filePath)
-	isPod := strings.HasSuffix(filePath, ".pod")
-
-	var tList []*INIFile
-	if isPod {
-		if transforms.BasePod != nil {
-			tList = append(tList, transforms.BasePod)
-		}
-		if dt, ok := transforms.DirPod[dir]; ok {
-			tList = append(tList, dt)
-		}
+	tList, applied := transformsFor(transforms, filepath.Ext(filePath), unitStem(filePath), dir, spec)
+	if len(applied) > 0 {
+		fmt.Fprintf(os.Stderr, "transforms: %s\n", strings.Join(applied, ", "))
 	} else {
-		if transforms.Base != nil {
-			tList = append(tList, transforms.Base)
-		}
-		if dt, ok := transforms.DirContainer[dir]; ok {
-			tList = append(tList, dt)
-		}
+		fmt.Fprintln(os.Stderr, "transforms: none")
 	}
 
-	if len(tList) == 0 {
-		fmt.Print(spec.String())
-		return
+	var notes []mergeNote
+	if len(tList) > 0 {
+		spec = applyTransformsNoted(spec, tList, &notes)
+	}
+	stripQuadsyncSection(spec)
+	switch {
+	case *explain:
+		fmt.Print(explainINI(spec, notes))
+	case *diff:
+		fmt.Print(unifiedDiff(filePath, filePath+" (merged)", string(data), spec.Lossless()))
+	default:
+		fmt.Print(spec.Lossless())
 	}
-
-	merged := applyTransforms(spec, tList)
-	fmt.Print(merged.String())
 }
 
 func cmdRedeploy() {
@@ -157,13 +254,90 @@ func cmdRedeploy() {
 		}
 		log.Fatalf("removing hash: %v", err)
 	}
+	// An explicit redeploy retries a revision that was rolled back.
+	os.Remove(badRevisionPath(filepath.Join(cfg.StateDir, "hashes"), name))
 	log.Printf("%s: marked for redeployment (run 'quadsync sync' to apply)", name)
 }
 
-func parentDirName(path string) string {
-	parts := strings.Split(path, string(os.PathSeparator))
-	if len(parts) >= 2 {
-		return parts[len(parts)-2]
+func cmdPurge() {
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "Usage: quadsync purge <name>")
+		os.Exit(2)
+	}
+	name, err := NewUsername(os.Args[2])
+	if err != nil {
+		log.Fatalf("invalid name: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("loading config: %v", err)
 	}
-	return ""
+	if err := Purge(cfg, name); err != nil {
+		log.Fatalf("purge failed: %v", err)
+	}
+	log.Printf("%s: purged", name)
+}
+
+func cmdDrift() {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("loading config: %v", err)
+	}
+	report, err := DetectDrift(cfg)
+	if err != nil {
+		log.Fatalf("drift check failed: %v", err)
+	}
+	fmt.Print(report.String())
+	if len(report.Drifted) > 0 {
+		os.Exit(1)
+	}
+}
+
+// augmentTarget works out how a sync would see the spec at path: the
+// directory it is in, relative to its repository's root (selectRootDir for
+// the root itself), and the transform directory of the git source it comes
+// from. The file may be in a source's checkout or in a working copy of the
+// source's repository, recognised by its origin URL; any other file is
+// merged with the global transforms. Outside a git repository, the file's
+// own directory counts as the root.
+func augmentTarget(cfg Config, path string) (dir, transformDir string) {
+	fileDir, err := filepath.Abs(filepath.Dir(path))
+	if err == nil {
+		if resolved, err := filepath.EvalSymlinks(fileDir); err == nil {
+			fileDir = resolved
+		}
+	}
+	root, transformDir := "", cfg.TransformDir
+	for _, src := range cfg.Sources {
+		if repo, err := filepath.EvalSymlinks(src.RepoPath); err == nil && withinDir(repo, fileDir) {
+			root, transformDir = repo, src.TransformDir
+			break
+		}
+	}
+	if root == "" {
+		root = gitToplevel(fileDir)
+		if root == "" {
+			root = fileDir
+		}
+		if url := gitRemoteURL(root); url != "" {
+			for _, src := range cfg.Sources {
+				if src.URL == url {
+					transformDir = src.TransformDir
+					break
+				}
+			}
+		}
+	}
+	rel, err := filepath.Rel(root, fileDir)
+	if err != nil || rel == "." {
+		return selectRootDir, transformDir
+	}
+	return strings.Split(rel, string(os.PathSeparator))[0], transformDir
+}
+
+// withinDir reports whether path is dir or below it.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestAugmentTarget(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	state := t.TempDir()
+	cfg := Config{
+		TransformDir: "/etc/quadsync/transforms",
+		Sources: []GitSource{
+			{Name: defaultSourceName, URL: "https://example.com/fleet.git", TransformDir: "/etc/quadsync/transforms", RepoPath: filepath.Join(state, "repo")},
+			{Name: "team", URL: "https://example.com/team.git", TransformDir: "/etc/quadsync/team", RepoPath: filepath.Join(state, "repos", "team")},
+		},
+	}
+	os.MkdirAll(filepath.Join(state, "repos", "team", "web"), 0755)
+
+	work := t.TempDir()
+	gitT(t, work, "init", "-q")
+	gitT(t, work, "remote", "add", "origin", "https://example.com/team.git")
+	os.MkdirAll(filepath.Join(work, "web"), 0755)
+	plain := t.TempDir()
+	os.MkdirAll(filepath.Join(plain, "web"), 0755)
+
+	cases := []struct {
+		path, dir, transformDir string
+	}{
+		// In a source's checkout.
+		{filepath.Join(state, "repos", "team", "db.container"), selectRootDir, "/etc/quadsync/team"},
+		{filepath.Join(state, "repos", "team", "web", "app.container"), "web", "/etc/quadsync/team"},
+		// In a working copy of a source's repository.
+		{filepath.Join(work, "db.container"), selectRootDir, "/etc/quadsync/team"},
+		{filepath.Join(work, "web", "app.container"), "web", "/etc/quadsync/team"},
+		// Not in a repository at all.
+		{filepath.Join(plain, "web", "app.container"), selectRootDir, "/etc/quadsync/transforms"},
+	}
+	for _, tc := range cases {
+		dir, transformDir := augmentTarget(cfg, tc.path)
+		if dir != tc.dir || transformDir != tc.transformDir {
+			t.Errorf("%s: got (%q, %q), want (%q, %q)", tc.path, dir, transformDir, tc.dir, tc.transformDir)
+		}
+	}
+}
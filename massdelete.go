@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// defaultRemovalLimitPercent is the share of a host's managed users one sync
+// may remove before the mass-deletion guard refuses.
+const defaultRemovalLimitPercent = 50
+
+// defaultRemovalLimitPercentMin is how many users one sync may remove
+// whatever their share, so that retiring a container on a host with only one
+// or two of them needs no acknowledgement.
+const defaultRemovalLimitPercentMin = 2
+
+// RemovalLimit guards against a broken checkout (a bad force-push, the wrong
+// branch, an empty repo) retiring every user on a host. A zero field
+// disables that check.
+type RemovalLimit struct {
+	Max        int // most users one sync may retire
+	Percent    int // most users one sync may retire, as a percentage of the live managed users
+	PercentMin int // Percent only applies when retiring more users than this
+}
+
+// check returns an error describing the breach if retiring removing users,
+// out of managed live ones, exceeds the limit. PercentMin does not cover
+// retiring every one of them, as an empty checkout would.
+func (l RemovalLimit) check(removing, managed int) error {
+	if removing == 0 {
+		return nil
+	}
+	if l.Max > 0 && removing > l.Max {
+		return fmt.Errorf("refusing to remove %d users: more than QUADSYNC_REMOVAL_LIMIT=%d", removing, l.Max)
+	}
+	if l.Percent > 0 && (removing > l.PercentMin || removing >= managed) && removing*100 > l.Percent*managed {
+		return fmt.Errorf("refusing to remove %d of %d managed users: more than QUADSYNC_REMOVAL_LIMIT_PERCENT=%d%%", removing, managed, l.Percent)
+	}
+	return nil
+}
+
+// guardRemovals applies the mass-deletion guard to the users a sync would
+// retire. current are the managed users and actions the removalAction of
+// each one that is no longer desired. Returns nil when the removals may go
+// ahead, including when config.AllowMassDelete acknowledges them.
+func guardRemovals(config Config, current []Username, actions map[Username]string) error {
+	removing, live := 0, 0
+	for _, name := range current {
+		action, undesired := actions[name]
+		switch {
+		case !undesired:
+			live++
+		case action == removalDelete || action == removalTombstone:
+			removing++
+			live++
+		}
+	}
+	err := config.RemovalLimit.check(removing, live)
+	if err == nil {
+		return nil
+	}
+	if config.AllowMassDelete {
+		log.Printf("warning: mass-deletion guard acknowledged, going ahead: %v", err)
+		return nil
+	}
+	return fmt.Errorf("%w (rerun with --allow-mass-delete or send the %s op to go ahead)", err, OpAllowRemovals)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRemovalLimitCheck(t *testing.T) {
+	cases := []struct {
+		limit             RemovalLimit
+		removing, managed int
+		errMsg            string
+	}{
+		{RemovalLimit{Percent: 50}, 0, 0, ""},
+		{RemovalLimit{Percent: 50}, 1, 2, ""},
+		{RemovalLimit{Percent: 50}, 2, 3, "refusing to remove 2 of 3 managed users"},
+		{RemovalLimit{Percent: 50}, 1, 1, "refusing to remove 1 of 1"},
+		// A small host may retire a container or two without acknowledgement.
+		{RemovalLimit{Percent: 50, PercentMin: 2}, 1, 2, ""},
+		{RemovalLimit{Percent: 50, PercentMin: 2}, 2, 3, ""},
+		// ... but not all of them.
+		{RemovalLimit{Percent: 50, PercentMin: 2}, 1, 1, "refusing to remove 1 of 1"},
+		{RemovalLimit{Percent: 50, PercentMin: 2}, 2, 2, "refusing to remove 2 of 2"},
+		{RemovalLimit{Percent: 50, PercentMin: 2}, 3, 4, "refusing to remove 3 of 4"},
+		{RemovalLimit{Percent: 50, PercentMin: 2}, 3, 10, ""},
+		{RemovalLimit{Max: 3}, 3, 3, ""},
+		{RemovalLimit{Max: 3}, 4, 40, "more than QUADSYNC_REMOVAL_LIMIT=3"},
+		{RemovalLimit{}, 10, 10, ""},
+	}
+	for _, tc := range cases {
+		err := tc.limit.check(tc.removing, tc.managed)
+		if tc.errMsg == "" {
+			if err != nil {
+				t.Errorf("%+v %d/%d: unexpected error %v", tc.limit, tc.removing, tc.managed, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+			t.Errorf("%+v %d/%d: error %v, want %q", tc.limit, tc.removing, tc.managed, err, tc.errMsg)
+		}
+	}
+}
+
+func TestGuardRemovals(t *testing.T) {
+	current := []Username{"a", "b", "c", "old"}
+	// Every spec vanished; "old" was already tombstoned and does not count.
+	actions := map[Username]string{
+		"a":   removalTombstone,
+		"b":   removalTombstone,
+		"c":   removalTombstone,
+		"old": removalPurge,
+	}
+	cfg := Config{RemovalLimit: RemovalLimit{Percent: 50}}
+	err := guardRemovals(cfg, current, actions)
+	if err == nil || !strings.Contains(err.Error(), "refusing to remove 3 of 3 managed users") || !strings.Contains(err.Error(), "--allow-mass-delete") {
+		t.Fatalf("error = %v", err)
+	}
+
+	cfg.AllowMassDelete = true
+	if err := guardRemovals(cfg, current, actions); err != nil {
+		t.Errorf("acknowledged removal refused: %v", err)
+	}
+
+	// Only "c" left the repo.
+	delete(actions, "a")
+	delete(actions, "b")
+	cfg.AllowMassDelete = false
+	if err := guardRemovals(cfg, current, actions); err != nil {
+		t.Errorf("single removal refused: %v", err)
+	}
+}
+
+func TestBuildPlanRefusesMassRemoval(t *testing.T) {
+	cfg := Config{Retention: Retention{Policy: RetainKeep}, RemovalLimit: RemovalLimit{Percent: 50}}
+	plan, err := buildPlan(t.TempDir(), cfg, nil, []Username{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(plan.String(), "would be refused: refusing to remove 2 of 2 managed users") {
+		t.Errorf("rendered plan:\n%s", plan.String())
+	}
+}
+
+func TestGuardRemovalsSmallHost(t *testing.T) {
+	cfg := Config{RemovalLimit: RemovalLimit{Percent: defaultRemovalLimitPercent, PercentMin: defaultRemovalLimitPercentMin}}
+	// One of the host's two containers left the repo.
+	if err := guardRemovals(cfg, []Username{"web", "db"}, map[Username]string{"web": removalTombstone}); err != nil {
+		t.Errorf("removing 1 of 2 containers refused: %v", err)
+	}
+	// An empty tree removes everything, however few containers there are.
+	for _, current := range [][]Username{{"web"}, {"web", "db"}} {
+		actions := map[Username]string{}
+		for _, u := range current {
+			actions[u] = removalTombstone
+		}
+		if err := guardRemovals(cfg, current, actions); err == nil {
+			t.Errorf("removing all %d containers was not refused", len(current))
+		}
+	}
+	current := []Username{"a", "b", "c"}
+	actions := map[Username]string{"a": removalDelete, "b": removalDelete, "c": removalDelete}
+	if err := guardRemovals(cfg, current, actions); err == nil {
+		t.Error("removing 3 of 3 users was not refused")
+	}
+}
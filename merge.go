@@ -9,9 +9,29 @@ import (
 // Rules:
 //   - Key=Value in transform: set only if the spec hasn't set this key (spec takes precedence)
 //   - +Key=Value in transform: prepend this value before the spec's values (for multi-value keys)
+//   - Key+=Value in transform: append this value after the spec's values
+//   - Key!=Value in transform: replace all of the spec's values for the key; several
+//     Key!= lines give the full list, and an empty Key!= resets the key to no values
+//   - -Key=Value in transform: remove the spec's Key=Value entries; -Key removes every value
 //
-// The result is a new INIFile. The originals are not modified.
+// The result is a new INIFile. The originals are not modified. Entries keep
+// the file and line they were read from, so the result can be explained.
 func MergeTransform(spec, transform *INIFile) *INIFile {
+	return mergeTransform(spec, transform, nil)
+}
+
+// mergeNote is a value a merge left out: a transform default the section
+// already had a value for, or a spec value a transform replaced or removed.
+type mergeNote struct {
+	Section string
+	Kind    string // "not applied", "replaced" or "removed"
+	Dropped Entry  // the value left out
+	By      Entry  // the entry that won over it
+}
+
+// mergeTransform is MergeTransform, recording what it leaves out in notes
+// when notes is not nil.
+func mergeTransform(spec, transform *INIFile, notes *[]mergeNote) *INIFile {
 	result := &INIFile{}
 
 	// Track which transform sections we've already handled
@@ -32,7 +52,7 @@ func MergeTransform(spec, transform *INIFile) *INIFile {
 		}
 		handledSections[specSec.Name] = true
 
-		merged := mergeSection(specSec, *transSec)
+		merged := mergeSection(specSec, *transSec, notes)
 		result.Sections = append(result.Sections, merged)
 	}
 
@@ -44,13 +64,21 @@ func MergeTransform(spec, transform *INIFile) *INIFile {
 		if handledSections[transSec.Name] {
 			continue
 		}
-		// Strip + prefixes from keys
+		// Strip operators from keys; there is nothing to delete or override
 		cleaned := Section{Name: transSec.Name}
 		for _, e := range transSec.Entries {
-			if e.Key != "" && strings.HasPrefix(e.Key, "+") {
-				cleaned.Entries = append(cleaned.Entries, Entry{Key: e.Key[1:], Value: e.Value})
-			} else {
+			op := parseMergeOp(e)
+			switch op.kind {
+			case opNone, opDefault:
 				cleaned.Entries = append(cleaned.Entries, e)
+			case opOverride:
+				if op.value == "" {
+					cleaned.Entries = withoutKey(cleaned.Entries, op.key)
+					continue
+				}
+				cleaned.Entries = append(cleaned.Entries, op.entry(e))
+			case opPrepend, opAppend:
+				cleaned.Entries = append(cleaned.Entries, op.entry(e))
 			}
 		}
 		result.Sections = append(result.Sections, cleaned)
@@ -59,30 +87,143 @@ func MergeTransform(spec, transform *INIFile) *INIFile {
 	return result
 }
 
-// mergeSection merges a single section from spec and transform.
-func mergeSection(spec, transform Section) Section {
-	merged := Section{Name: spec.Name}
+// mergeOpKind is what a transform entry does to the section it is merged into.
+type mergeOpKind int
+
+const (
+	opNone     mergeOpKind = iota // comment or blank line
+	opDefault                     // Key=Value
+	opPrepend                     // +Key=Value
+	opAppend                      // Key+=Value
+	opOverride                    // Key!=Value
+	opDelete                      // -Key=Value, or -Key for every value
+)
+
+// mergeOp is a transform entry split into its operator, key and value.
+type mergeOp struct {
+	kind       mergeOpKind
+	key, value string
+	all        bool // opDelete of every value (-Key)
+}
+
+// entry is the entry op adds, positioned at the transform line e it was read from.
+func (op mergeOp) entry(e Entry) Entry {
+	return Entry{Key: op.key, Value: op.value, File: e.File, Line: e.Line}
+}
+
+// parseMergeOp reads the operator of a transform entry. A bare "-Key" line,
+// which parses as a line without a key, is a delete of every value.
+func parseMergeOp(e Entry) mergeOp {
+	if e.Key == "" {
+		raw := strings.TrimSpace(e.Raw)
+		if key, ok := strings.CutPrefix(raw, "-"); ok && key != "" && !strings.ContainsAny(key, " \t") {
+			return mergeOp{kind: opDelete, key: key, all: true}
+		}
+		return mergeOp{kind: opNone}
+	}
+	switch {
+	case strings.HasPrefix(e.Key, "+"):
+		return mergeOp{kind: opPrepend, key: strings.TrimSpace(e.Key[1:]), value: e.Value}
+	case strings.HasPrefix(e.Key, "-"):
+		return mergeOp{kind: opDelete, key: strings.TrimSpace(e.Key[1:]), value: e.Value}
+	case strings.HasSuffix(e.Key, "+"):
+		return mergeOp{kind: opAppend, key: strings.TrimSpace(strings.TrimSuffix(e.Key, "+")), value: e.Value}
+	case strings.HasSuffix(e.Key, "!"):
+		return mergeOp{kind: opOverride, key: strings.TrimSpace(strings.TrimSuffix(e.Key, "!")), value: e.Value}
+	}
+	return mergeOp{kind: opDefault, key: e.Key, value: e.Value}
+}
+
+// mergeSection merges a single section from spec and transform. Deletes and
+// overrides act on the spec's entries only, so the order of lines within a
+// transform does not matter, except that an empty Key!= drops the Key!=
+// values before it. What the merge leaves out is added to notes, if not nil.
+func mergeSection(spec, transform Section, notes *[]mergeNote) Section {
+	merged := Section{Name: spec.Name, Line: spec.Line, header: spec.header}
+
+	note := func(kind string, dropped, by Entry) {
+		if notes != nil {
+			*notes = append(*notes, mergeNote{Section: spec.Name, Kind: kind, Dropped: dropped, By: by})
+		}
+	}
 
-	// Collect prepend entries (+Key=Value) from transform
-	var prepends []Entry
+	var prepends, appends []Entry
+	overrides := map[string][]Entry{}
+	overrideBy := map[string]Entry{} // key → last Key!= line
+	var overrideKeys []string
+	deleteAll := map[string]Entry{}      // key → -Key line
+	deleteValue := map[[2]string]Entry{} // key, value → -Key=Value line
 	for _, e := range transform.Entries {
-		if e.Key != "" && strings.HasPrefix(e.Key, "+") {
-			prepends = append(prepends, Entry{Key: e.Key[1:], Value: e.Value})
+		op := parseMergeOp(e)
+		switch op.kind {
+		case opPrepend:
+			prepends = append(prepends, op.entry(e))
+		case opAppend:
+			appends = append(appends, op.entry(e))
+		case opOverride:
+			if _, seen := overrides[op.key]; !seen {
+				overrideKeys = append(overrideKeys, op.key)
+			}
+			if op.value == "" {
+				overrides[op.key] = []Entry{}
+			} else {
+				overrides[op.key] = append(overrides[op.key], op.entry(e))
+			}
+			overrideBy[op.key] = e
+		case opDelete:
+			if op.all {
+				deleteAll[op.key] = e
+			} else {
+				deleteValue[[2]string{op.key, op.value}] = e
+			}
 		}
 	}
 
 	// Add prepend entries first
 	merged.Entries = append(merged.Entries, prepends...)
 
-	// Add all spec entries
-	merged.Entries = append(merged.Entries, spec.Entries...)
+	// Add the spec entries, with overridden keys replaced where their first
+	// value was and deleted values dropped
+	placed := map[string]bool{}
+	for _, e := range spec.Entries {
+		if e.Key != "" {
+			if values, ok := overrides[e.Key]; ok {
+				if !placed[e.Key] {
+					merged.Entries = append(merged.Entries, values...)
+					placed[e.Key] = true
+				}
+				note("replaced", e, overrideBy[e.Key])
+				continue
+			}
+			if by, ok := deleteAll[e.Key]; ok {
+				note("removed", e, by)
+				continue
+			}
+			if by, ok := deleteValue[[2]string{e.Key, e.Value}]; ok {
+				note("removed", e, by)
+				continue
+			}
+		}
+		merged.Entries = append(merged.Entries, e)
+	}
+	for _, key := range overrideKeys {
+		if !placed[key] {
+			merged.Entries = append(merged.Entries, overrides[key]...)
+		}
+	}
+	merged.Entries = append(merged.Entries, appends...)
 
-	// Add transform defaults (non-prepend entries where spec doesn't have the key)
+	// Add transform defaults (plain entries where the section doesn't have
+	// the key and the transform doesn't override it)
 	for _, e := range transform.Entries {
-		if e.Key == "" || strings.HasPrefix(e.Key, "+") {
-			continue // skip comments/blanks and prepend entries
+		if parseMergeOp(e).kind != opDefault {
+			continue // skip comments/blanks and other operators
 		}
-		if !sectionHasKey(merged, e.Key) {
+		if _, overridden := overrides[e.Key]; overridden {
+			note("not applied", e, overrideBy[e.Key])
+		} else if by, ok := firstWithKey(merged, e.Key); ok {
+			note("not applied", e, by)
+		} else {
 			merged.Entries = append(merged.Entries, e)
 		}
 	}
@@ -90,26 +231,45 @@ func mergeSection(spec, transform Section) Section {
 	return merged
 }
 
-func sectionHasKey(sec Section, key string) bool {
+// withoutKey returns entries less those with the given key.
+func withoutKey(entries []Entry, key string) []Entry {
+	var out []Entry
+	for _, e := range entries {
+		if e.Key != key {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// firstWithKey returns the first entry of sec with the given key.
+func firstWithKey(sec Section, key string) (Entry, bool) {
 	for _, e := range sec.Entries {
 		if e.Key == key {
-			return true
+			return e, true
 		}
 	}
-	return false
+	return Entry{}, false
 }
 
 // applyTransforms chains multiple transforms onto a spec.
 // Each transform is applied in order via MergeTransform.
 func applyTransforms(spec *INIFile, transforms []*INIFile) *INIFile {
+	return applyTransformsNoted(spec, transforms, nil)
+}
+
+// applyTransformsNoted is applyTransforms, recording what each merge leaves
+// out in notes when notes is not nil.
+func applyTransformsNoted(spec *INIFile, transforms []*INIFile, notes *[]mergeNote) *INIFile {
 	for _, t := range transforms {
-		spec = MergeTransform(spec, t)
+		spec = mergeTransform(spec, t, notes)
 	}
 	return spec
 }
 
 func cloneSection(s Section) Section {
-	c := Section{Name: s.Name, Entries: make([]Entry, len(s.Entries))}
+	c := s
+	c.Entries = make([]Entry, len(s.Entries))
 	copy(c.Entries, s.Entries)
 	return c
 }
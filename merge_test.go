@@ -137,6 +137,157 @@ Restart=on-failure
 	}
 }
 
+func TestMergeOperators(t *testing.T) {
+	spec := parseINI(t, `[Container]
+Image=nginx:latest
+Network=host
+PublishPort=8080:80
+PublishPort=8443:443
+Volume=/data:/data
+Volume=/cache:/cache
+Environment=A=1
+`)
+
+	cases := []struct {
+		name      string
+		transform string
+		want      string
+	}{
+		{
+			name: "override replaces every value in place",
+			transform: `[Container]
+Network!=tailnet.network
+Volume!=/srv:/srv
+Volume!=/tmp:/tmp
+`,
+			want: `[Container]
+Image=nginx:latest
+Network=tailnet.network
+PublishPort=8080:80
+PublishPort=8443:443
+Volume=/srv:/srv
+Volume=/tmp:/tmp
+Environment=A=1
+`,
+		},
+		{
+			name: "override of a key the spec lacks is added",
+			transform: `[Container]
+User!=1000
+`,
+			want: `[Container]
+Image=nginx:latest
+Network=host
+PublishPort=8080:80
+PublishPort=8443:443
+Volume=/data:/data
+Volume=/cache:/cache
+Environment=A=1
+User=1000
+`,
+		},
+		{
+			name: "delete one value and every value",
+			transform: `[Container]
+-PublishPort=8443:443
+-Volume
+-Environment=A=2
+`,
+			want: `[Container]
+Image=nginx:latest
+Network=host
+PublishPort=8080:80
+Environment=A=1
+`,
+		},
+		{
+			name: "append after and prepend before",
+			transform: `[Container]
+Volume+=/logs:/logs
++Volume=/etc:/etc
+`,
+			want: `[Container]
+Volume=/etc:/etc
+Image=nginx:latest
+Network=host
+PublishPort=8080:80
+PublishPort=8443:443
+Volume=/data:/data
+Volume=/cache:/cache
+Environment=A=1
+Volume=/logs:/logs
+`,
+		},
+		{
+			name: "empty override resets a key and beats a default",
+			transform: `[Container]
+PublishPort!=
+PublishPort=9090:90
+`,
+			want: `[Container]
+Image=nginx:latest
+Network=host
+Volume=/data:/data
+Volume=/cache:/cache
+Environment=A=1
+`,
+		},
+		{
+			name: "delete all then default replaces",
+			transform: `[Container]
+-Network
+Network=bridge
+`,
+			want: `[Container]
+Image=nginx:latest
+PublishPort=8080:80
+PublishPort=8443:443
+Volume=/data:/data
+Volume=/cache:/cache
+Environment=A=1
+Network=bridge
+`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := MergeTransform(spec, parseINI(t, c.transform)).String()
+			if got != c.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMergeOperatorsNewSection(t *testing.T) {
+	spec := parseINI(t, `[Container]
+Image=nginx:latest
+`)
+	transform := parseINI(t, `[Service]
+# restart policy
+Restart=on-failure
+ExecStartPre+=/bin/true
++ExecStartPre=/bin/false
+-Environment
+Environment!=A=1
+TimeoutStartSec!=10
+TimeoutStartSec!=
+`)
+	want := `[Container]
+Image=nginx:latest
+
+[Service]
+# restart policy
+Restart=on-failure
+ExecStartPre=/bin/true
+ExecStartPre=/bin/false
+Environment=A=1
+`
+	if got := MergeTransform(spec, transform).String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
 func TestMergeFullExample(t *testing.T) {
 	// This mirrors the exact example from the plan
 	spec := parseINI(t, `[Container]
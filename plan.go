@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SyncPlan is the host impact of a sync, computed without deploying anything.
+type SyncPlan struct {
+	Rename   []PlanRename `json:"rename,omitempty"`   // users that would be renamed (RenamedFrom=)
+	Create   []string     `json:"create,omitempty"`   // users that would be created
+	Redeploy []PlanDeploy `json:"redeploy,omitempty"` // containers whose spec changed
+	Prune    []PlanPrune  `json:"prune,omitempty"`    // stale sidecar units per user
+	Retire   []string     `json:"retire,omitempty"`   // users that would be stopped and tombstoned
+	Remove   []string     `json:"remove,omitempty"`   // users that would be deleted
+
+	// Refused explains why the mass-deletion guard would hold back the
+	// Retire and Remove entries of users that are not tombstoned yet.
+	Refused string `json:"refused,omitempty"`
+}
+
+// PlanDeploy is one container that would be redeployed, with a unified diff
+// per file whose content differs from what is currently in the user's home
+// and the transform files merged into it.
+type PlanDeploy struct {
+	Name       string     `json:"name"`
+	Transforms []string   `json:"transforms,omitempty"`
+	Diffs      []FileDiff `json:"diffs,omitempty"`
+	Error      string     `json:"error,omitempty"` // why the deploy would fail, e.g. a missing host fact
+}
+
+// FileDiff is a unified diff for a single managed file.
+type FileDiff struct {
+	File string `json:"file"`
+	Diff string `json:"diff"`
+}
+
+// PlanRename is a managed user that a renamed container would take over.
+type PlanRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// PlanPrune lists the sidecar units that would be removed from one user.
+type PlanPrune struct {
+	Name  string   `json:"name"`
+	Units []string `json:"units"`
+}
+
+// Indirections over the read-only system calls a plan makes, so tests can
+// stand in for real user homes.
+var (
+	readDeployedFile  = readUserFile
+	listDeployedUnits = listUserUnitFiles
+)
+
+// PlanSync runs the same pipeline as Sync (git sync, validation, transform
+// merge, managed-user lookup) but stops before creating users or writing
+// anything, and reports what a sync would do instead.
+func PlanSync(config Config) (*SyncPlan, error) {
+	lockFile, err := lockSync(config)
+	if err != nil {
+		return nil, err
+	}
+	defer lockFile.Close()
+
+	desired, current, err := prepareSync(config, newSyncReport())
+	if err != nil {
+		return nil, err
+	}
+	return buildPlan(filepath.Join(config.StateDir, "hashes"), config, desired, current)
+}
+
+// buildPlan compares the desired state against stored hashes and the files
+// deployed in each user's home. Mirrors the decisions of the Sync deploy and
+// cleanup loops.
+func buildPlan(hashDir string, config Config, desired map[Username]DesiredState, current []Username) (*SyncPlan, error) {
+	plan := &SyncPlan{}
+	currentSet := map[Username]bool{}
+	for _, u := range current {
+		currentSet[u] = true
+	}
+
+	// A renamed user keeps its data but none of its managed files, so its
+	// new files are diffed against nothing, like a new user's.
+	renames := pendingRenames(desired, currentSet)
+	renamed := map[Username]bool{}
+	for _, r := range renames {
+		plan.Rename = append(plan.Rename, PlanRename{From: string(r.From), To: string(r.To)})
+		renamed[r.To] = true
+	}
+	current = applyRenames(current, renames)
+
+	for _, name := range sortedNames(desired) {
+		state := desired[name]
+		exists := currentSet[name]
+		if !exists && !renamed[name] {
+			plan.Create = append(plan.Create, string(name))
+		}
+		if !specChanged(hashDir, name, state) || isBadRevision(hashDir, name, state) {
+			continue
+		}
+
+		deploy := PlanDeploy{Name: string(name), Transforms: state.Transforms}
+		if err := missingFactsError(state); err != nil {
+			deploy.Error = err.Error()
+			plan.Redeploy = append(plan.Redeploy, deploy)
+			continue
+		}
+		if exists {
+			resolved, err := resolveStateUserVars(name, state)
+			if err != nil {
+				return nil, err
+			}
+			state.Files = resolved.Files
+		}
+		for _, filename := range sortedKeys(state.Files) {
+			var old string
+			if exists {
+				content, ok, err := readDeployedFile(name, filename)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					old = content
+				}
+			}
+			path := filepath.Join(userFileDir(filename), filename)
+			if d := unifiedDiff("deployed/"+path, "desired/"+path, old, state.Files[filename]); d != "" {
+				deploy.Diffs = append(deploy.Diffs, FileDiff{File: filename, Diff: d})
+			}
+		}
+		plan.Redeploy = append(plan.Redeploy, deploy)
+
+		if !exists {
+			continue
+		}
+		units, err := listDeployedUnits(name)
+		if err != nil {
+			return nil, err
+		}
+		var stale []string
+		for _, u := range units {
+			if _, keep := state.Files[u]; !keep {
+				stale = append(stale, u)
+			}
+		}
+		if len(stale) > 0 {
+			sort.Strings(stale)
+			plan.Prune = append(plan.Prune, PlanPrune{Name: string(name), Units: stale})
+		}
+	}
+
+	now := time.Now()
+	actions := map[Username]string{}
+	for _, name := range current {
+		if _, exists := desired[name]; exists {
+			continue
+		}
+		actions[name] = removalAction(config.Retention, hashDir, name, now)
+		switch actions[name] {
+		case removalTombstone:
+			plan.Retire = append(plan.Retire, string(name))
+		case removalDelete, removalPurge:
+			plan.Remove = append(plan.Remove, string(name))
+		}
+	}
+	sort.Strings(plan.Retire)
+	sort.Strings(plan.Remove)
+	if err := guardRemovals(config, current, actions); err != nil {
+		plan.Refused = err.Error()
+	}
+	return plan, nil
+}
+
+// Empty reports whether the plan would change nothing.
+func (p *SyncPlan) Empty() bool {
+	return len(p.Rename) == 0 && len(p.Create) == 0 && len(p.Redeploy) == 0 && len(p.Prune) == 0 && len(p.Retire) == 0 && len(p.Remove) == 0
+}
+
+// String renders the plan for humans (CLI output and the web UI).
+func (p *SyncPlan) String() string {
+	if p.Empty() {
+		return "No changes.\n"
+	}
+	var b strings.Builder
+	if len(p.Rename) > 0 {
+		b.WriteString("Users to rename:\n")
+		for _, r := range p.Rename {
+			fmt.Fprintf(&b, "  > %s -> %s\n", r.From, r.To)
+		}
+	}
+	if len(p.Create) > 0 {
+		b.WriteString("Users to create:\n")
+		for _, n := range p.Create {
+			fmt.Fprintf(&b, "  + %s\n", n)
+		}
+	}
+	if len(p.Redeploy) > 0 {
+		b.WriteString("Containers to redeploy:\n")
+		for _, d := range p.Redeploy {
+			fmt.Fprintf(&b, "  ~ %s\n", d.Name)
+			if len(d.Transforms) > 0 {
+				fmt.Fprintf(&b, "    transforms: %s\n", strings.Join(d.Transforms, ", "))
+			}
+			if d.Error != "" {
+				fmt.Fprintf(&b, "    would fail: %s\n", d.Error)
+				continue
+			}
+			if len(d.Diffs) == 0 {
+				b.WriteString("    (no file changes; secrets changed or redeploy requested)\n")
+			}
+			for _, fd := range d.Diffs {
+				for _, line := range splitLines(fd.Diff) {
+					fmt.Fprintf(&b, "    %s\n", line)
+				}
+			}
+		}
+	}
+	if len(p.Prune) > 0 {
+		b.WriteString("Sidecar units to prune:\n")
+		for _, pr := range p.Prune {
+			fmt.Fprintf(&b, "  - %s: %s\n", pr.Name, strings.Join(pr.Units, ", "))
+		}
+	}
+	if len(p.Retire) > 0 {
+		b.WriteString("Users to stop and tombstone (data kept):\n")
+		for _, n := range p.Retire {
+			fmt.Fprintf(&b, "  - %s\n", n)
+		}
+	}
+	if len(p.Remove) > 0 {
+		b.WriteString("Users to delete:\n")
+		for _, n := range p.Remove {
+			fmt.Fprintf(&b, "  - %s\n", n)
+		}
+	}
+	if p.Refused != "" {
+		fmt.Fprintf(&b, "Removals of users not yet tombstoned would be refused: %s\n", p.Refused)
+	}
+	return b.String()
+}
+
+// sortedKeys returns the keys of a map in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
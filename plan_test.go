@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	t.Run("identical", func(t *testing.T) {
+		if d := unifiedDiff("a", "b", "x\n", "x\n"); d != "" {
+			t.Fatalf("expected empty diff, got:\n%s", d)
+		}
+	})
+
+	t.Run("changed line", func(t *testing.T) {
+		a := "[Container]\nImage=nginx:1\nNetwork=host\n"
+		b := "[Container]\nImage=nginx:2\nNetwork=host\n"
+		want := "--- a\n+++ b\n@@ -1,3 +1,3 @@\n [Container]\n-Image=nginx:1\n+Image=nginx:2\n Network=host\n"
+		if got := unifiedDiff("a", "b", a, b); got != want {
+			t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("new file", func(t *testing.T) {
+		want := "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+[Timer]\n+OnCalendar=03:00\n"
+		if got := unifiedDiff("a", "b", "", "[Timer]\nOnCalendar=03:00\n"); got != want {
+			t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("distant changes split into hunks", func(t *testing.T) {
+		var a, b []string
+		for i := 0; i < 20; i++ {
+			a = append(a, "line")
+			b = append(b, "line")
+		}
+		a[1], b[1] = "old1", "new1"
+		a[18], b[18] = "old18", "new18"
+		got := unifiedDiff("a", "b", strings.Join(a, "\n"), strings.Join(b, "\n"))
+		if n := strings.Count(got, "@@ -"); n != 2 {
+			t.Fatalf("expected 2 hunks, got %d:\n%s", n, got)
+		}
+		if !strings.Contains(got, "@@ -1,5 +1,5 @@") || !strings.Contains(got, "@@ -16,5 +16,5 @@") {
+			t.Fatalf("unexpected hunk headers:\n%s", got)
+		}
+	})
+}
+
+func TestBuildPlan(t *testing.T) {
+	hashDir := t.TempDir()
+
+	unchanged := DesiredState{Files: map[string]string{"same.container": "[Container]\nImage=same\n"}, ServiceName: "same"}
+	if err := saveHash(hashDir, "same", unchanged); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := map[Username]DesiredState{
+		"same": unchanged,
+		"web": {Files: map[string]string{
+			"web.container":     "[Container]\nImage=nginx:2\n",
+			"web-refresh.timer": "[Timer]\nOnCalendar=03:00\n",
+		}, ServiceName: "web"},
+		"fresh": {Files: map[string]string{"fresh.container": "[Container]\nImage=fresh\n"}, ServiceName: "fresh"},
+	}
+	current := []Username{"same", "web", "gone"}
+
+	deployed := map[Username]map[string]string{
+		"web": {
+			"web.container":   "[Container]\nImage=nginx:1\n",
+			"web-old.service": "[Service]\nExecStart=/bin/true\n",
+			"web-old.timer":   "[Timer]\nOnCalendar=daily\n",
+		},
+	}
+	origRead, origList := readDeployedFile, listDeployedUnits
+	t.Cleanup(func() { readDeployedFile, listDeployedUnits = origRead, origList })
+	readDeployedFile = func(name Username, filename string) (string, bool, error) {
+		if name == "fresh" {
+			t.Errorf("read attempted for user %s that does not exist yet", name)
+		}
+		c, ok := deployed[name][filename]
+		return c, ok, nil
+	}
+	listDeployedUnits = func(name Username) ([]string, error) {
+		var units []string
+		for f := range deployed[name] {
+			if userFileDir(f) == userUnitDir {
+				units = append(units, f)
+			}
+		}
+		return units, nil
+	}
+
+	plan, err := buildPlan(hashDir, Config{Retention: Retention{Policy: RetainDelete}}, desired, current)
+	if err != nil {
+		t.Fatalf("buildPlan: %v", err)
+	}
+
+	if !reflect.DeepEqual(plan.Create, []string{"fresh"}) {
+		t.Errorf("Create = %v, want [fresh]", plan.Create)
+	}
+	if !reflect.DeepEqual(plan.Remove, []string{"gone"}) {
+		t.Errorf("Remove = %v, want [gone]", plan.Remove)
+	}
+	if len(plan.Redeploy) != 2 || plan.Redeploy[0].Name != "fresh" || plan.Redeploy[1].Name != "web" {
+		t.Fatalf("Redeploy = %+v, want fresh and web", plan.Redeploy)
+	}
+	web := plan.Redeploy[1]
+	if len(web.Diffs) != 2 {
+		t.Fatalf("expected diffs for both web files, got %+v", web.Diffs)
+	}
+	if !strings.Contains(web.Diffs[1].Diff, "-Image=nginx:1\n+Image=nginx:2") {
+		t.Errorf("expected image change in diff, got:\n%s", web.Diffs[1].Diff)
+	}
+	if !strings.Contains(web.Diffs[0].Diff, "+++ desired/"+filepath.Join(userUnitDir, "web-refresh.timer")) {
+		t.Errorf("expected new timer diff, got:\n%s", web.Diffs[0].Diff)
+	}
+	wantPrune := []PlanPrune{{Name: "web", Units: []string{"web-old.service", "web-old.timer"}}}
+	if !reflect.DeepEqual(plan.Prune, wantPrune) {
+		t.Errorf("Prune = %+v, want %+v", plan.Prune, wantPrune)
+	}
+
+	// A plan must never write state.
+	entries, _ := os.ReadDir(hashDir)
+	if len(entries) != 1 {
+		t.Errorf("expected hash dir untouched, got %d entries", len(entries))
+	}
+
+	out := plan.String()
+	for _, want := range []string{"  + fresh", "  ~ web", "  - web: web-old.service, web-old.timer", "  - gone"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("rendered plan missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestBuildPlanEmpty(t *testing.T) {
+	plan, err := buildPlan(t.TempDir(), Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Empty() || plan.String() != "No changes.\n" {
+		t.Errorf("expected empty plan, got %q", plan.String())
+	}
+}
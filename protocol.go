@@ -25,6 +25,12 @@ const (
 	OpRedeploy = "redeploy" // clear deploy hash + re-sync
 	OpRepull   = "repull"   // force a fresh image pull + recreate
 	OpSync     = "sync"     // run a full quadsync sync
+	OpPlan     = "plan"     // compute what a sync would change, without applying it
+	OpReports  = "reports"  // most recent sync reports, newest first
+	OpStatus   = "status"   // watch-loop schedule and last sync result
+	OpDrift    = "drift"    // deployed files that differ from the desired state
+
+	OpAllowRemovals = "allow-removals" // sync now, acknowledging removals the mass-deletion guard refused
 )
 
 // Request is a single NDJSON control request.
@@ -32,6 +38,7 @@ type Request struct {
 	Op    string `json:"op"`
 	Name  string `json:"name,omitempty"`  // container/user name for name-scoped ops
 	Lines int    `json:"lines,omitempty"` // log line count for OpLogs
+	Count int    `json:"count,omitempty"` // report count for OpReports
 }
 
 // ContainerInfo is the status/build/health snapshot for one managed container.
@@ -45,6 +52,8 @@ type ContainerInfo struct {
 	ImageID     string `json:"image_id,omitempty"`     // resolved image digest/ID
 	Health      string `json:"health,omitempty"`       // healthy/unhealthy/starting/none
 	Hash        string `json:"hash,omitempty"`         // quadsync deploy hash ("build")
+	Source      string `json:"source,omitempty"`       // git source that defines the container
+	Tombstoned  string `json:"tombstoned,omitempty"`   // RFC 3339 time the container left the repo, if kept for purging
 }
 
 // Response is a single NDJSON control response.
@@ -54,7 +63,11 @@ type Response struct {
 	Containers []ContainerInfo `json:"containers,omitempty"` // OpList
 	Container  *ContainerInfo  `json:"container,omitempty"`  // OpGet
 	Logs       string          `json:"logs,omitempty"`       // OpLogs
-	Message    string          `json:"message,omitempty"`    // action ops
+	Message    string          `json:"message,omitempty"`    // action ops; rendered plan for OpPlan
+	Plan       *SyncPlan       `json:"plan,omitempty"`       // OpPlan
+	Reports    []SyncReport    `json:"reports,omitempty"`    // OpReports
+	Watch      *WatchStatus    `json:"watch,omitempty"`      // OpStatus
+	Drift      *DriftReport    `json:"drift,omitempty"`      // OpDrift
 }
 
 // socketCallTimeout bounds a single request/response round trip. Generous,
@@ -12,6 +12,7 @@ func TestRequestRoundTrip(t *testing.T) {
 		{Op: OpLogs, Name: "nginx-demo", Lines: 200},
 		{Op: OpRepull, Name: "web-app"},
 		{Op: OpSync},
+		{Op: OpReports, Count: 5},
 	}
 	for _, want := range cases {
 		b, err := json.Marshal(want)
@@ -7,18 +7,21 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // CompanionTemplate is an additional quadlet file template deployed alongside
 // each container. The SuffixAndExt (e.g. "-data.volume") is appended to the
-// container name to form the filename, and {{.Name}} in Content is replaced
-// with the container name.
+// container name to form the filename, and the template placeholders in
+// Content ({{.Name}} for the container name, ...) are expanded.
 type CompanionTemplate struct {
 	SuffixAndExt string // e.g. "-litestream.container", "-data.volume"
-	Content      string // raw content with {{.Name}} placeholders
+	Content      string // raw content with template placeholders
 	NoPod        bool   // if set, the companion does not inherit its member's pod
 }
 
@@ -37,9 +40,33 @@ type ContainerSecret struct {
 
 // DesiredState holds all quadlet files for a single container user.
 type DesiredState struct {
-	Files       map[string]string // filename → content (e.g. "myapp.container", "myapp-data.volume")
-	ServiceName string            // systemd service to restart (e.g. "nginx-demo" for standalone, "webapp-pod" for pods)
-	Secrets     []ContainerSecret
+	Files        map[string]string // filename → content (e.g. "myapp.container", "myapp-data.volume")
+	ServiceName  string            // systemd service to restart (e.g. "nginx-demo" for standalone, "webapp-pod" for pods)
+	Secrets      []ContainerSecret
+	Source       string          // name of the git source the spec came from (not part of the hash)
+	After        []Username      // containers to bring up before this one (not part of the hash)
+	RenamedFrom  Username        // managed user to take over instead of creating one (not part of the hash)
+	Transforms   []string        // transform files merged into Files, in first-use order (not part of the hash)
+	Unresolved   []unresolvedVar // template placeholders without a value, reported by CheckDesired (not part of the hash)
+	MissingFacts []unresolvedVar // host fact placeholders this host has no value for; the deploy fails (not part of the hash)
+	// UserTemplated lists the Files with {{.UID}}, {{.GID}} or {{.Home}}
+	// left in, filled in by resolveStateUserVars before they are written.
+	UserTemplated []string
+}
+
+// defaultSourceName labels the repository configured by QUADSYNC_GIT_URL.
+const defaultSourceName = "default"
+
+var validSourceNameRe = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// GitSource is one repository quadsync deploys from.
+type GitSource struct {
+	Name         string
+	URL          string
+	Branch       string
+	SSHKey       string // path to SSH deploy key for this repository
+	TransformDir string // transforms applied to this repository's specs
+	RepoPath     string // derived: checkout location under StateDir
 }
 
 // Config holds the deployer configuration.
@@ -52,6 +79,61 @@ type Config struct {
 	SSHKey       string // path to SSH deploy key for git
 	AgeKeyFile   string // path to age private key for inline secret decryption
 	RepoPath     string // derived: StateDir + "/repo"
+
+	// Sources lists every repository to deploy from: the QUADSYNC_GIT_URL
+	// repository (named "default") first, if set, followed by each
+	// QUADSYNC_SOURCES entry in order.
+	Sources []GitSource
+
+	// GitVerify, when set to "ssh" or "gpg", refuses to deploy a commit
+	// whose signature does not verify against GitSigners (an allowed_signers
+	// file for ssh, an exported public keyring for gpg).
+	GitVerify  string
+	GitSigners string
+
+	// Select restricts which specs of every source this host deploys;
+	// unselected containers are treated as removed.
+	Select Selector
+
+	// WatchInterval, when non-zero, makes `quadsync serve` run its own sync
+	// loop: every WatchInterval plus up to WatchJitter, backing off
+	// exponentially (up to WatchMaxBackoff) after failures.
+	WatchInterval   time.Duration
+	WatchJitter     time.Duration
+	WatchMaxBackoff time.Duration
+
+	// DeployParallelism is how many users a sync deploys at once (at least
+	// 1). FailureBudget stops a sync from starting further deployments once
+	// more than that many containers have failed to come up; -1 disables it.
+	DeployParallelism int
+	FailureBudget     int
+
+	// DriftRedeploy makes sync compare each unchanged user's deployed files
+	// with the desired state and redeploy users that drifted.
+	DriftRedeploy bool
+
+	// QuadletVerify runs podman's quadlet generator in dry-run mode (and
+	// systemd-analyze verify for sidecars) over the merged output of every
+	// sync, before anything is written to user homes.
+	QuadletVerify bool
+
+	// RollbackTimeout is how long a freshly deployed service has to become
+	// active (and healthy) before quadsync restores the previous revision.
+	// Zero disables verification and rollback.
+	RollbackTimeout time.Duration
+
+	// HookTimeout bounds each pre- and post-deploy hook unit.
+	HookTimeout time.Duration
+
+	// RemovalLimit refuses a sync's removals when it would retire too many
+	// users at once; AllowMassDelete (set per run, never from config.env)
+	// acknowledges such a removal.
+	RemovalLimit    RemovalLimit
+	AllowMassDelete bool
+
+	// Retention decides whether users removed from the repo are deleted or
+	// tombstoned, and how long tombstones are kept.
+	Retention Retention
 }
 
 // LoadConfig reads config from an env file.
@@ -70,11 +152,10 @@ func LoadConfig(path string) (Config, error) {
 		UserGroup:    env["QUADSYNC_USER_GROUP"],
 		SSHKey:       env["QUADSYNC_SSH_KEY"],
 		AgeKeyFile:   env["QUADSYNC_AGE_KEY"],
+		GitVerify:    env["QUADSYNC_GIT_VERIFY"],
+		GitSigners:   env["QUADSYNC_GIT_SIGNERS"],
 	}
 
-	if c.GitURL == "" {
-		return Config{}, fmt.Errorf("QUADSYNC_GIT_URL not set in config")
-	}
 	if c.GitBranch == "" {
 		c.GitBranch = "main"
 	}
@@ -84,13 +165,148 @@ func LoadConfig(path string) (Config, error) {
 	if c.StateDir == "" {
 		c.StateDir = "/var/lib/quadsync"
 	}
+	c.RepoPath = filepath.Join(c.StateDir, "repo")
+	if c.GitURL != "" {
+		c.Sources = append(c.Sources, GitSource{
+			Name:         defaultSourceName,
+			URL:          c.GitURL,
+			Branch:       c.GitBranch,
+			SSHKey:       c.SSHKey,
+			TransformDir: c.TransformDir,
+			RepoPath:     c.RepoPath,
+		})
+	}
+	for _, name := range strings.Fields(env["QUADSYNC_SOURCES"]) {
+		src, err := loadSource(env, name, c)
+		if err != nil {
+			return Config{}, err
+		}
+		for _, prev := range c.Sources {
+			if prev.Name == src.Name {
+				return Config{}, fmt.Errorf("duplicate git source %q", name)
+			}
+		}
+		c.Sources = append(c.Sources, src)
+	}
+	if len(c.Sources) == 0 {
+		return Config{}, fmt.Errorf("no git source configured: set QUADSYNC_GIT_URL or QUADSYNC_SOURCES")
+	}
 	if c.UserGroup == "" {
 		c.UserGroup = "cusers"
 	}
-	c.RepoPath = filepath.Join(c.StateDir, "repo")
+	switch c.GitVerify {
+	case "":
+	case gitVerifySSH, gitVerifyGPG:
+		if c.GitSigners == "" {
+			return Config{}, fmt.Errorf("QUADSYNC_GIT_VERIFY=%s requires QUADSYNC_GIT_SIGNERS", c.GitVerify)
+		}
+	default:
+		return Config{}, fmt.Errorf("invalid QUADSYNC_GIT_VERIFY %q (expected ssh or gpg)", c.GitVerify)
+	}
+	c.DeployParallelism = 1
+	if v := env["QUADSYNC_DEPLOY_PARALLELISM"]; v != "" {
+		if c.DeployParallelism, err = strconv.Atoi(v); err != nil || c.DeployParallelism < 1 {
+			return Config{}, fmt.Errorf("invalid QUADSYNC_DEPLOY_PARALLELISM %q", v)
+		}
+	}
+	c.FailureBudget = -1
+	if v := env["QUADSYNC_FAILURE_BUDGET"]; v != "" {
+		if c.FailureBudget, err = strconv.Atoi(v); err != nil || c.FailureBudget < 0 {
+			return Config{}, fmt.Errorf("invalid QUADSYNC_FAILURE_BUDGET %q", v)
+		}
+	}
+	if v := env["QUADSYNC_DRIFT_REDEPLOY"]; v != "" {
+		if c.DriftRedeploy, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid QUADSYNC_DRIFT_REDEPLOY %q", v)
+		}
+	}
+	if v := env["QUADSYNC_QUADLET_VERIFY"]; v != "" {
+		if c.QuadletVerify, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid QUADSYNC_QUADLET_VERIFY %q", v)
+		}
+	}
+	c.RemovalLimit.Percent = defaultRemovalLimitPercent
+	c.RemovalLimit.PercentMin = defaultRemovalLimitPercentMin
+	for key, dst := range map[string]*int{
+		"QUADSYNC_REMOVAL_LIMIT":             &c.RemovalLimit.Max,
+		"QUADSYNC_REMOVAL_LIMIT_PERCENT":     &c.RemovalLimit.Percent,
+		"QUADSYNC_REMOVAL_LIMIT_PERCENT_MIN": &c.RemovalLimit.PercentMin,
+	} {
+		if v := env[key]; v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil || *dst < 0 {
+				return Config{}, fmt.Errorf("invalid %s %q", key, v)
+			}
+		}
+	}
+	c.Retention = Retention{Policy: env["QUADSYNC_RETENTION"], Grace: defaultRetentionGrace}
+	switch c.Retention.Policy {
+	case "":
+		c.Retention.Policy = RetainDelete
+	case RetainDelete, RetainKeep, RetainArchive:
+	default:
+		return Config{}, fmt.Errorf("invalid QUADSYNC_RETENTION %q (expected delete, keep or archive)", c.Retention.Policy)
+	}
+	if c.Select, err = parseSelector(env); err != nil {
+		return Config{}, err
+	}
+	for key, dst := range map[string]*time.Duration{
+		"QUADSYNC_ROLLBACK_TIMEOUT":  &c.RollbackTimeout,
+		"QUADSYNC_HOOK_TIMEOUT":      &c.HookTimeout,
+		"QUADSYNC_RETENTION_GRACE":   &c.Retention.Grace,
+		"QUADSYNC_WATCH_INTERVAL":    &c.WatchInterval,
+		"QUADSYNC_WATCH_JITTER":      &c.WatchJitter,
+		"QUADSYNC_WATCH_MAX_BACKOFF": &c.WatchMaxBackoff,
+	} {
+		v := env[key]
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return Config{}, fmt.Errorf("invalid %s %q", key, v)
+		}
+		*dst = d
+	}
+	if c.HookTimeout == 0 {
+		c.HookTimeout = defaultHookTimeout
+	}
+	if _, ok := env["QUADSYNC_WATCH_JITTER"]; !ok {
+		c.WatchJitter = c.WatchInterval / 10
+	}
+	if c.WatchMaxBackoff > 0 && c.WatchMaxBackoff < c.WatchInterval {
+		return Config{}, fmt.Errorf("QUADSYNC_WATCH_MAX_BACKOFF (%s) is shorter than QUADSYNC_WATCH_INTERVAL (%s)", c.WatchMaxBackoff, c.WatchInterval)
+	}
 	return c, nil
 }
 
+// loadSource reads the QUADSYNC_SOURCE_<NAME>_* settings of one additional
+// git source. Branch defaults to main; the transform directory defaults to
+// the global one.
+func loadSource(env map[string]string, name string, c Config) (GitSource, error) {
+	if !validSourceNameRe.MatchString(name) || name == defaultSourceName {
+		return GitSource{}, fmt.Errorf("invalid git source name %q in QUADSYNC_SOURCES", name)
+	}
+	prefix := "QUADSYNC_SOURCE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+	src := GitSource{
+		Name:         name,
+		URL:          env[prefix+"GIT_URL"],
+		Branch:       env[prefix+"GIT_BRANCH"],
+		SSHKey:       env[prefix+"SSH_KEY"],
+		TransformDir: env[prefix+"TRANSFORM_DIR"],
+		RepoPath:     filepath.Join(c.StateDir, "repos", name),
+	}
+	if src.URL == "" {
+		return GitSource{}, fmt.Errorf("%sGIT_URL not set for git source %q", prefix, name)
+	}
+	if src.Branch == "" {
+		src.Branch = "main"
+	}
+	if src.TransformDir == "" {
+		src.TransformDir = c.TransformDir
+	}
+	return src, nil
+}
+
 func parseEnvFile(data string) map[string]string {
 	env := map[string]string{}
 	for _, line := range strings.Split(data, "\n") {
@@ -116,208 +332,487 @@ func unquote(s string) string {
 	return s
 }
 
-// Sync performs the full reconciliation: git sync, transform merge, deploy, cleanup.
+// Sync performs the full reconciliation: git sync, transform merge, deploy,
+// cleanup. Every run that gets past the lock leaves a SyncReport in the state
+// directory, whether it succeeds or not.
 func Sync(config Config) error {
-	// Set GIT_SSH_COMMAND from config so git operations use the deploy key.
-	if config.SSHKey != "" {
-		os.Setenv("GIT_SSH_COMMAND", "ssh -i "+config.SSHKey+" -o StrictHostKeyChecking=accept-new")
-	}
-
-	// Ensure state dir exists
-	if err := os.MkdirAll(config.StateDir, 0755); err != nil {
-		return fmt.Errorf("creating state dir: %w", err)
-	}
-
-	// Acquire exclusive lock to prevent overlapping sync runs.
-	lockFile, err := os.OpenFile(filepath.Join(config.StateDir, "sync.lock"), os.O_CREATE|os.O_RDWR, 0600)
+	lockFile, err := lockSync(config)
 	if err != nil {
-		return fmt.Errorf("opening lock file: %w", err)
+		return err
 	}
 	defer lockFile.Close()
-	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
-		return fmt.Errorf("another sync is already running")
-	}
 
-	// 1. Git sync
-	if _, err := os.Stat(config.RepoPath); os.IsNotExist(err) {
-		log.Printf("cloning %s", config.GitURL)
-		if err := gitClone(config.GitURL, config.RepoPath, config.GitBranch); err != nil {
-			return fmt.Errorf("git clone: %w", err)
-		}
-	} else {
-		changed, err := gitFetch(config.RepoPath, config.GitBranch)
-		if err != nil {
-			return fmt.Errorf("git fetch: %w", err)
-		}
-		if changed {
-			log.Printf("changes detected, updating")
-			if err := gitResetHard(config.RepoPath, config.GitBranch); err != nil {
-				return fmt.Errorf("git reset: %w", err)
-			}
-		}
-	}
-
-	// 2. Validate specs
-	if errs := CheckDir(config.RepoPath); len(errs) > 0 {
-		for _, e := range errs {
-			log.Printf("validation error: %v", e)
-		}
-		return fmt.Errorf("validation failed: %d error(s)", len(errs))
-	}
-
-	// 3. Load transforms
-	transforms, err := loadAllTransforms(config.TransformDir)
-	if err != nil {
-		return fmt.Errorf("loading transforms: %w", err)
-	}
-
-	// 4. Build desired state
-	transforms.AgeKeyFile = config.AgeKeyFile
-	desired, err := buildDesiredFull(config.RepoPath, transforms)
-	if err != nil {
-		return fmt.Errorf("building desired state: %w", err)
-	}
-
-	// 5. Validate merged output
-	if errs := CheckDesired(desired); len(errs) > 0 {
-		for _, e := range errs {
-			log.Printf("post-merge validation error: %v", e)
-		}
-		return fmt.Errorf("post-merge validation failed: %d error(s)", len(errs))
+	report := newSyncReport()
+	err = runSync(config, report)
+	report.finish(err)
+	if werr := saveReport(config.StateDir, report); werr != nil {
+		log.Printf("warning: saving sync report: %v", werr)
 	}
+	return err
+}
 
-	// 6. Get current managed users
-	current, err := managedUsers(config.UserGroup)
+// runSync is the body of Sync, run with the lock held. Outcomes and step
+// timings are recorded in report as they happen.
+func runSync(config Config, report *SyncReport) error {
+	desired, current, err := prepareSync(config, report)
 	if err != nil {
-		return fmt.Errorf("listing managed users: %w", err)
+		return err
 	}
 	currentSet := map[Username]bool{}
 	for _, u := range current {
 		currentSet[u] = true
 	}
 
-	// 7. Deploy
 	hashDir := filepath.Join(config.StateDir, "hashes")
 	if err := os.MkdirAll(hashDir, 0755); err != nil {
 		return fmt.Errorf("creating hash dir: %w", err)
 	}
 
+	var errs []error
+
+	// 7. Rename users taken over by renamed containers (RenamedFrom=). A
+	// failed rename leaves both names alone until a later sync: the new
+	// container is not deployed and the old user is not retired, so its
+	// data stays where it is.
+	held := map[Username]bool{}
+	renamed := map[string]ContainerReport{}
+	if renames := pendingRenames(desired, currentSet); len(renames) > 0 {
+		done := report.step("rename")
+		for _, r := range renames {
+			cr := ContainerReport{Name: string(r.To), Source: desired[r.To].Source, Outcome: OutcomeRenamed}
+			start := time.Now()
+			err := migrateUser(hashDir, r, &cr)
+			cr.DurationMS = time.Since(start).Milliseconds()
+			if err != nil {
+				log.Printf("error: %v", err)
+				cr.Outcome = OutcomeFailed
+				cr.Error = err.Error()
+				errs = append(errs, err)
+				delete(desired, r.To)
+				held[r.From] = true
+			} else {
+				delete(currentSet, r.From)
+				currentSet[r.To] = true
+				current = applyRenames(current, []userRename{r})
+				renamed[cr.Name] = cr
+				continue
+			}
+			report.Containers = append(report.Containers, cr)
+		}
+		done()
+	}
+
+	// 8. Deploy
+
 	// Deploy loop error policy: quadsync reports failure for its own
 	// mechanisms (user creation, quadlet writing, daemon-reload). If a
 	// container fails to start, that is the container's problem — we log
-	// it as a warning but do not count it as a quadsync failure.
-	var errs []error
+	// it as a warning but do not count it as a quadsync failure, unless so
+	// many fail that the failure budget is exhausted.
+
+	done := report.step("deploy")
+	crs, deployErrs, budgetErr := deployAll(config, hashDir, sortedNames(desired), desired, currentSet)
+	for i, cr := range crs {
+		if rcr, ok := renamed[cr.Name]; ok {
+			crs[i] = mergeRenameReport(rcr, cr)
+		}
+	}
+	report.Containers = append(report.Containers, crs...)
+	errs = append(errs, deployErrs...)
+	done()
+	if budgetErr != nil {
+		// Something is breaking containers across the board (a bad base
+		// transform, say); leave removals for a sync that deploys cleanly.
+		log.Printf("error: %v", budgetErr)
+		return errors.Join(append(errs, budgetErr)...)
+	}
 
-	names := make([]Username, 0, len(desired))
-	for name := range desired {
-		names = append(names, name)
+	// 9. Cleanup: retire containers not in desired, per the retention policy
+	done = report.step("cleanup")
+	actions := map[Username]string{}
+	for _, name := range current {
+		if _, exists := desired[name]; !exists && !held[name] {
+			actions[name] = removalAction(config.Retention, hashDir, name, time.Now())
+		}
 	}
-	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	guardErr := guardRemovals(config, current, actions)
+	if guardErr != nil {
+		log.Printf("error: %v", guardErr)
+		errs = append(errs, guardErr)
+	}
+	for _, name := range current {
+		action, undesired := actions[name]
+		if !undesired || action == removalWait {
+			continue
+		}
+		cr := ContainerReport{Name: string(name), Source: readSourceLabel(hashDir, name), Outcome: OutcomeRemoved}
+		if guardErr != nil && action != removalPurge {
+			cr.Outcome = OutcomeRefused
+			cr.warn("not removed: mass-deletion guard")
+			report.Containers = append(report.Containers, cr)
+			continue
+		}
+		start := time.Now()
+		var err error
+		switch action {
+		case removalTombstone:
+			cr.Outcome = OutcomeTombstoned
+			err = tombstoneContainer(config, hashDir, name, &cr)
+		case removalPurge:
+			log.Printf("%s: tombstone older than %s, purging", name, config.Retention.Grace)
+			err = removeContainer(hashDir, name, &cr)
+		default:
+			err = removeContainer(hashDir, name, &cr)
+		}
+		if err != nil {
+			log.Printf("error: %v", err)
+			errs = append(errs, err)
+			cr.Outcome = OutcomeFailed
+			cr.Error = err.Error()
+		}
+		cr.DurationMS = time.Since(start).Milliseconds()
+		report.Containers = append(report.Containers, cr)
+	}
+	done()
 
-	for _, name := range names {
-		state := desired[name]
-		if !currentSet[name] {
-			log.Printf("creating user %s", name)
-			if err := createUser(name, config.UserGroup); err != nil {
-				log.Printf("error creating user %s: %v", name, err)
-				errs = append(errs, fmt.Errorf("creating user %s: %w", name, err))
-				continue
-			}
+	return errors.Join(errs...)
+}
+
+// deployContainer creates the user if needed and, when the desired state's
+// hash differs from the stored one, writes its files, reloads systemd and
+// restarts the service. The outcome and any non-fatal warnings are recorded
+// in cr; the returned error covers only quadsync's own failures.
+func deployContainer(config Config, hashDir string, name Username, state DesiredState, exists bool, cr *ContainerReport) error {
+	if err := missingFactsError(state); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if clearTombstone(hashDir, name) {
+		log.Printf("%s: back in the repo, reusing its tombstoned user", name)
+	}
+	if !exists {
+		log.Printf("creating user %s", name)
+		userAdminMu.Lock()
+		err := createUser(name, config.UserGroup)
+		userAdminMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("creating user %s: %w", name, err)
 		}
+	}
 
-		if !specChanged(hashDir, name, state) {
+	var stray []string
+	if !specChanged(hashDir, name, state) {
+		if !exists || !config.DriftRedeploy {
 			log.Printf("%s: unchanged, skipping", name)
-			continue
+			cr.Outcome = OutcomeUnchanged
+			return nil
 		}
-
-		log.Printf("%s: deploying", name)
-		failed := false
-		for filename, content := range state.Files {
-			if err := writeQuadletFile(name, filename, content); err != nil {
-				log.Printf("error writing %s for %s: %v", filename, name, err)
-				errs = append(errs, fmt.Errorf("writing %s for %s: %w", filename, name, err))
-				failed = true
-				break
+		drift, err := detectUserDrift(name, state)
+		if err != nil {
+			log.Printf("warning: checking drift for %s: %v", name, err)
+			cr.warn("checking drift: %v", err)
+			cr.Outcome = OutcomeUnchanged
+			return nil
+		}
+		if !drift.Drifted() {
+			log.Printf("%s: unchanged, skipping", name)
+			cr.Outcome = OutcomeUnchanged
+			return nil
+		}
+		log.Printf("%s: drifted (%s), redeploying", name, drift.Summary())
+		cr.warn("drifted: %s", drift.Summary())
+		// Stray units in the user-unit dir are disabled and removed by
+		// pruneUserUnits below; stray quadlets need removing here.
+		for _, path := range drift.Unexpected {
+			if filepath.Dir(path) == quadletDir {
+				stray = append(stray, path)
 			}
 		}
-		if failed {
-			continue
+	}
+	if isBadRevision(hashDir, name, state) {
+		log.Printf("%s: revision previously failed and was rolled back, skipping until the spec changes", name)
+		cr.Outcome = OutcomeSkipped
+		cr.warn("revision previously failed to come up; not retried until the spec changes")
+		return nil
+	}
+
+	log.Printf("%s: deploying", name)
+	resolved, err := resolveStateUserVars(name, state)
+	if err != nil {
+		return err
+	}
+	if err := runPreDeployHooks(config, name, resolved); err != nil {
+		return err
+	}
+	if len(stray) > 0 {
+		if err := removeUserFiles(name, stray); err != nil {
+			return err
 		}
-		if err := waitForUserManager(name); err != nil {
-			log.Printf("error waiting for user manager %s: %v", name, err)
-			errs = append(errs, fmt.Errorf("waiting for user manager %s: %w", name, err))
-			continue
+	}
+	for filename, content := range resolved.Files {
+		if err := writeQuadletFile(name, filename, content); err != nil {
+			return fmt.Errorf("writing %s for %s: %w", filename, name, err)
 		}
-		// Prune any .service/.timer in the user-unit dir that are no longer
-		// in DesiredState. Best-effort — failures are logged inside.
-		pruneUserUnits(name, state.Files)
-		if len(state.Secrets) > 0 {
-			if err := createPodmanSecrets(name, state.Secrets); err != nil {
-				log.Printf("error creating secrets for %s: %v", name, err)
-				errs = append(errs, fmt.Errorf("creating secrets for %s: %w", name, err))
-				continue
+	}
+	if err := waitForUserManager(name); err != nil {
+		return fmt.Errorf("waiting for user manager %s: %w", name, err)
+	}
+	// Prune any .service/.timer in the user-unit dir that are no longer
+	// in DesiredState. Best-effort — failures are logged inside.
+	pruneUserUnits(name, state.Files)
+	if len(state.Secrets) > 0 {
+		if err := createPodmanSecrets(name, state.Secrets); err != nil {
+			return fmt.Errorf("creating secrets for %s: %w", name, err)
+		}
+	}
+	if err := daemonReload(name); err != nil {
+		return fmt.Errorf("daemon-reload for %s: %w", name, err)
+	}
+
+	// Enable+start any timers in DesiredState. Idempotent.
+	for filename := range state.Files {
+		if strings.HasSuffix(filename, ".timer") {
+			if err := enableTimer(name, filename); err != nil {
+				log.Printf("warning: enabling %s for %s: %v", filename, name, err)
+				cr.warn("enabling %s: %v", filename, err)
 			}
 		}
-		if err := daemonReload(name); err != nil {
-			log.Printf("error daemon-reload for %s: %v", name, err)
-			errs = append(errs, fmt.Errorf("daemon-reload for %s: %w", name, err))
-			continue
+	}
+
+	// Quadlet is written and systemd knows about it — quadsync's job
+	// is done. Persist the hash so we don't re-deploy next cycle.
+	if exists {
+		cr.Outcome = OutcomeDeployed
+	} else {
+		cr.Outcome = OutcomeCreated
+	}
+	if err := saveHash(hashDir, name, state); err != nil {
+		return fmt.Errorf("saving hash for %s: %w", name, err)
+	}
+
+	// Best-effort service restart. If the container fails to come up
+	// that is the container's concern, not ours — but it goes in the
+	// report so it is visible downstream.
+	if err := restartService(name, state.ServiceName); err != nil {
+		log.Printf("warning: restarting %s: %v (container may need attention)", name, err)
+		cr.warn("restarting %s: %v", state.ServiceName, err)
+		cr.Down = true
+	}
+
+	if config.RollbackTimeout > 0 {
+		if err := verifyDeploy(config, hashDir, name, state, cr); err != nil {
+			return err
 		}
+	}
+	if !cr.Down {
+		runPostDeployHooks(config, name, state, cr)
+	}
+	return nil
+}
 
-		// Enable+start any timers in DesiredState. Idempotent.
-		for filename := range state.Files {
-			if strings.HasSuffix(filename, ".timer") {
-				if err := enableTimer(name, filename); err != nil {
-					log.Printf("warning: enabling %s for %s: %v", filename, name, err)
+// removeContainer tears down a user that is no longer in the desired state:
+// timers, service, managed files, the user itself and its stored hash.
+func removeContainer(hashDir string, name Username, cr *ContainerReport) error {
+	log.Printf("%s: removing", name)
+	stopContainer(name, cr)
+	if err := removeAllQuadlets(name); err != nil {
+		log.Printf("warning: removing quadlets for %s: %v", name, err)
+	}
+	if err := deleteUser(name); err != nil {
+		return fmt.Errorf("deleting user %s: %w", name, err)
+	}
+	os.Remove(filepath.Join(hashDir, string(name)))
+	os.Remove(sourceLabelPath(hashDir, name))
+	os.Remove(tombstonePath(hashDir, name))
+	clearRevisionState(hashDir, name)
+	return nil
+}
+
+// stopContainer disables a user's timers and stops its service. Best-effort:
+// failures are warnings, since the user manager may already be gone.
+func stopContainer(name Username, cr *ContainerReport) {
+	// Disable any timers before the user manager goes away.
+	if units, err := listUserUnitFiles(name); err == nil {
+		for _, u := range units {
+			if strings.HasSuffix(u, ".timer") {
+				if err := disableTimer(name, u); err != nil {
+					log.Printf("warning: disabling %s for %s: %v", u, name, err)
+					cr.warn("disabling %s: %v", u, err)
 				}
 			}
 		}
+	} else {
+		log.Printf("warning: listing user units for %s: %v", name, err)
+	}
+	if err := stopService(name, string(name)); err != nil {
+		log.Printf("warning: stopping %s: %v", name, err)
+		cr.warn("stopping %s: %v", name, err)
+	}
+}
+
+// errSyncLocked is returned when another process holds the sync lock.
+var errSyncLocked = errors.New("another sync is already running")
+
+// lockSync ensures the state dir exists and takes the exclusive sync lock so
+// that overlapping runs (timer, CLI, daemon) never interleave. The lock is
+// held until the returned file is closed.
+func lockSync(config Config) (*os.File, error) {
+	if err := os.MkdirAll(config.StateDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating state dir: %w", err)
+	}
+	lockFile, err := os.OpenFile(filepath.Join(config.StateDir, "sync.lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lockFile.Close()
+		return nil, errSyncLocked
+	}
+	return lockFile, nil
+}
 
-		// Quadlet is written and systemd knows about it — quadsync's job
-		// is done. Persist the hash so we don't re-deploy next cycle.
-		if err := saveHash(hashDir, name, state); err != nil {
-			log.Printf("error saving hash for %s: %v", name, err)
-			errs = append(errs, fmt.Errorf("saving hash for %s: %w", name, err))
+// prepareSync runs the part of a sync that does not touch user homes: git
+// sync, validation, transform merge and post-merge validation. It returns the
+// desired state and the currently managed users, recording step timings and
+// commits in report. Shared by Sync and PlanSync so a plan always reflects
+// exactly what a sync would deploy.
+func prepareSync(config Config, report *SyncReport) (map[Username]DesiredState, []Username, error) {
+	// 1. Git sync
+	done := report.step("git")
+	for _, src := range config.Sources {
+		sr := SourceReport{Name: src.Name, URL: src.URL, CommitBefore: gitHead(src.RepoPath)}
+		err := syncRepo(config, src)
+		sr.CommitAfter = gitHead(src.RepoPath)
+		report.Sources = append(report.Sources, sr)
+		if err != nil {
+			done()
+			return nil, nil, fmt.Errorf("source %s: %w", src.Name, err)
 		}
+	}
+	done()
+	return loadDesired(config, report)
+}
 
-		// Best-effort service restart. If the container fails to come up
-		// that is the container's concern, not ours.
-		if err := restartService(name, state.ServiceName); err != nil {
-			log.Printf("warning: restarting %s: %v (container may need attention)", name, err)
+// loadDesired builds and validates the desired state from the sources'
+// current checkouts, without fetching. It returns the desired state and the
+// currently managed users.
+func loadDesired(config Config, report *SyncReport) (map[Username]DesiredState, []Username, error) {
+	// 2. Validate specs
+	done := report.step("check")
+	var errs []error
+	for _, src := range config.Sources {
+		errs = append(errs, CheckDir(src.RepoPath, config.Select)...)
+	}
+	done()
+	if len(errs) > 0 {
+		for _, e := range errs {
+			log.Printf("validation error: %v", e)
 		}
+		return nil, nil, fmt.Errorf("validation failed: %d error(s)", len(errs))
 	}
 
-	// 8. Cleanup: remove containers not in desired
-	for _, name := range current {
-		if _, exists := desired[name]; !exists {
-			log.Printf("%s: removing", name)
-			// Disable any timers before the user manager goes away.
-			if units, err := listUserUnitFiles(name); err == nil {
-				for _, u := range units {
-					if strings.HasSuffix(u, ".timer") {
-						if err := disableTimer(name, u); err != nil {
-							log.Printf("warning: disabling %s for %s: %v", u, name, err)
-						}
-					}
-				}
-			} else {
-				log.Printf("warning: listing user units for %s: %v", name, err)
-			}
-			if err := stopService(name, string(name)); err != nil {
-				log.Printf("warning: stopping %s: %v", name, err)
-			}
-			if err := removeAllQuadlets(name); err != nil {
-				log.Printf("warning: removing quadlets for %s: %v", name, err)
-			}
-			if err := deleteUser(name); err != nil {
-				log.Printf("error deleting user %s: %v", name, err)
-				errs = append(errs, fmt.Errorf("deleting user %s: %w", name, err))
+	// 3. Load transforms (once per directory; sources may share one)
+	done = report.step("transforms")
+	facts := hostFacts()
+	byDir := map[string]Transforms{}
+	var trees []sourceTree
+	for _, src := range config.Sources {
+		transforms, ok := byDir[src.TransformDir]
+		if !ok {
+			var err error
+			transforms, err = loadAllTransforms(src.TransformDir)
+			if err != nil {
+				done()
+				return nil, nil, fmt.Errorf("loading transforms for source %s: %w", src.Name, err)
 			}
-			os.Remove(filepath.Join(hashDir, string(name)))
+			transforms.AgeKeyFile = config.AgeKeyFile
+			transforms.Facts = facts
+			byDir[src.TransformDir] = transforms
 		}
+		trees = append(trees, sourceTree{Name: src.Name, RepoPath: src.RepoPath, Transforms: transforms, Select: config.Select})
 	}
+	done()
 
-	return errors.Join(errs...)
+	// 4. Build desired state
+	done = report.step("build")
+	desired, err := buildDesiredSources(trees)
+	done()
+	if err != nil {
+		return nil, nil, fmt.Errorf("building desired state: %w", err)
+	}
+
+	// 5. Validate merged output
+	done = report.step("check-merged")
+	errs = CheckDesired(desired)
+	if len(errs) == 0 && config.QuadletVerify {
+		errs = verifyDesired(desired)
+	}
+	done()
+	if len(errs) > 0 {
+		for _, e := range errs {
+			log.Printf("post-merge validation error: %v", e)
+		}
+		return nil, nil, fmt.Errorf("post-merge validation failed: %d error(s)", len(errs))
+	}
+
+	// 6. Get current managed users
+	current, err := managedUsers(config.UserGroup)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing managed users: %w", err)
+	}
+	return desired, current, nil
+}
+
+// syncRepo clones a source's repository, or fetches and hard-resets an
+// existing checkout to the remote branch. With signature verification
+// enabled, a commit is only checked out after it verifies; a rejected fetch
+// leaves the previous checkout in place.
+func syncRepo(config Config, src GitSource) error {
+	if _, err := os.Stat(src.RepoPath); os.IsNotExist(err) {
+		log.Printf("cloning %s", src.URL)
+		if err := os.MkdirAll(filepath.Dir(src.RepoPath), 0755); err != nil {
+			return fmt.Errorf("creating checkout dir: %w", err)
+		}
+		if err := gitClone(src.URL, src.RepoPath, src.Branch, src.SSHKey); err != nil {
+			return fmt.Errorf("git clone: %w", err)
+		}
+		if err := verifyRevision(config, src, "HEAD"); err != nil {
+			// Never leave an unverified tree where the next run would use it.
+			os.RemoveAll(src.RepoPath)
+			return err
+		}
+		return nil
+	}
+	changed, err := gitFetch(src.RepoPath, src.Branch, src.SSHKey)
+	if err != nil {
+		return fmt.Errorf("git fetch: %w", err)
+	}
+	if !changed {
+		// Re-verify what is checked out, so enabling verification also
+		// covers a checkout made before it was turned on.
+		return verifyRevision(config, src, "HEAD")
+	}
+	if err := verifyRevision(config, src, "FETCH_HEAD"); err != nil {
+		return err
+	}
+	log.Printf("%s: changes detected, updating", src.Name)
+	if err := gitResetHard(src.RepoPath, src.Branch); err != nil {
+		return fmt.Errorf("git reset: %w", err)
+	}
+	return nil
+}
+
+// verifyRevision enforces QUADSYNC_GIT_VERIFY for rev in a source's
+// checkout. A no-op when verification is disabled.
+func verifyRevision(config Config, src GitSource, rev string) error {
+	if config.GitVerify == "" {
+		return nil
+	}
+	commit := gitRevParse(src.RepoPath, rev)
+	if err := gitVerifyCommit(src.RepoPath, rev, config.GitVerify, config.GitSigners); err != nil {
+		log.Printf("%s: rejected commit %s: signature does not verify: %v", src.Name, commit, err)
+		return fmt.Errorf("refusing to deploy commit %s: signature does not verify against %s", commit, config.GitSigners)
+	}
+	return nil
 }
 
 // Transforms holds all loaded transform data from the transform directory.
@@ -326,8 +821,12 @@ type Transforms struct {
 	BasePod      *INIFile            // from _base.pod, applied to all .pod files
 	DirContainer map[string]*INIFile // directory-specific .container transforms
 	DirPod       map[string]*INIFile // directory-specific .pod transforms
+	DirQuadlet   map[string]*INIFile // directory-specific .volume, .network, ... transforms, keyed "<dir><ext>"
+	Matched      []MatchedTransform  // transforms with an [X-Quadsync-Match] section, by file name
+	Vars         map[string]string   // from vars.env, as {{.Vars.KEY}}
 	Companions   []CompanionTemplate
 	AgeKeyFile   string
+	Facts        map[string]string // host facts, gathered once per sync, as {{.Host.FACT}}
 }
 
 // loadTransforms reads all files from the transform directory.
@@ -347,6 +846,7 @@ func loadAllTransforms(dir string) (Transforms, error) {
 	t := Transforms{
 		DirContainer: map[string]*INIFile{},
 		DirPod:       map[string]*INIFile{},
+		DirQuadlet:   map[string]*INIFile{},
 	}
 
 	entries, err := os.ReadDir(dir)
@@ -362,27 +862,26 @@ func loadAllTransforms(dir string) (Transforms, error) {
 			continue
 		}
 		name := entry.Name()
+		ext := filepath.Ext(name)
+		_, quadlet := ownedQuadletSection(ext)
 
-		if name == "_base.container" {
-			data, err := os.ReadFile(filepath.Join(dir, name))
-			if err != nil {
-				return Transforms{}, fmt.Errorf("reading transform %s: %w", name, err)
+		if name == varsFile {
+			if t.Vars, err = loadVars(filepath.Join(dir, name)); err != nil {
+				return Transforms{}, err
 			}
-			f, err := ParseINI(strings.NewReader(string(data)))
+		} else if name == "_base.container" || name == "_base.pod" {
+			f, err := readTransform(dir, name)
 			if err != nil {
-				return Transforms{}, fmt.Errorf("parsing transform %s: %w", name, err)
+				return Transforms{}, err
 			}
-			t.Base = f
-		} else if name == "_base.pod" {
-			data, err := os.ReadFile(filepath.Join(dir, name))
-			if err != nil {
-				return Transforms{}, fmt.Errorf("reading transform %s: %w", name, err)
+			if sec := f.GetSection(sectionTransformMatch); sec != nil {
+				return Transforms{}, sectionError(filepath.Join(dir, name), sec, "[%s] is not allowed in a base transform", sectionTransformMatch)
 			}
-			f, err := ParseINI(strings.NewReader(string(data)))
-			if err != nil {
-				return Transforms{}, fmt.Errorf("parsing transform %s: %w", name, err)
+			if ext == ".pod" {
+				t.BasePod = f
+			} else {
+				t.Base = f
 			}
-			t.BasePod = f
 		} else if strings.HasPrefix(name, "_base-") {
 			suffixAndExt := strings.TrimPrefix(name, "_base")
 			data, err := os.ReadFile(filepath.Join(dir, name))
@@ -394,28 +893,30 @@ func loadAllTransforms(dir string) (Transforms, error) {
 				Content:      string(data),
 				NoPod:        strings.Contains(string(data), noPodDirective),
 			})
-		} else if strings.HasSuffix(name, ".pod") {
-			dirName := strings.TrimSuffix(name, ".pod")
-			data, err := os.ReadFile(filepath.Join(dir, name))
-			if err != nil {
-				return Transforms{}, fmt.Errorf("reading transform %s: %w", name, err)
-			}
-			f, err := ParseINI(strings.NewReader(string(data)))
+		} else if ext == ".container" || ext == ".pod" || quadlet {
+			f, err := readTransform(dir, name)
 			if err != nil {
-				return Transforms{}, fmt.Errorf("parsing transform %s: %w", name, err)
+				return Transforms{}, err
 			}
-			t.DirPod[dirName] = f
-		} else if strings.HasSuffix(name, ".container") {
-			dirName := strings.TrimSuffix(name, ".container")
-			data, err := os.ReadFile(filepath.Join(dir, name))
-			if err != nil {
-				return Transforms{}, fmt.Errorf("reading transform %s: %w", name, err)
+			// With match conditions the transform is not a directory transform,
+			// whatever its name.
+			if sec := f.GetSection(sectionTransformMatch); sec != nil {
+				m, err := parseTransformMatch(sec, filepath.Join(dir, name))
+				if err != nil {
+					return Transforms{}, err
+				}
+				stripSection(f, sectionTransformMatch)
+				t.Matched = append(t.Matched, MatchedTransform{File: name, Ext: ext, Match: m, INI: f})
+				continue
 			}
-			f, err := ParseINI(strings.NewReader(string(data)))
-			if err != nil {
-				return Transforms{}, fmt.Errorf("parsing transform %s: %w", name, err)
+			switch ext {
+			case ".container":
+				t.DirContainer[strings.TrimSuffix(name, ext)] = f
+			case ".pod":
+				t.DirPod[strings.TrimSuffix(name, ext)] = f
+			default:
+				t.DirQuadlet[name] = f
 			}
-			t.DirContainer[dirName] = f
 		} else {
 			return Transforms{}, fmt.Errorf("unexpected file in transform directory: %s", name)
 		}
@@ -424,6 +925,20 @@ func loadAllTransforms(dir string) (Transforms, error) {
 	return t, nil
 }
 
+// readTransform reads and parses the transform file name in dir.
+func readTransform(dir, name string) (*INIFile, error) {
+	path := filepath.Join(dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading transform %s: %w", name, err)
+	}
+	f, err := ParseINIFrom(strings.NewReader(string(data)), path)
+	if err != nil {
+		return nil, fmt.Errorf("parsing transform %s: %w", name, err)
+	}
+	return f, nil
+}
+
 // buildDesired scans the repo and builds the desired state map.
 // base is the optional base transform applied to all containers.
 func buildDesired(repoPath string, base *INIFile, transforms map[string]*INIFile, companions []CompanionTemplate) (map[Username]DesiredState, error) {
@@ -436,19 +951,59 @@ func buildDesired(repoPath string, base *INIFile, transforms map[string]*INIFile
 	return buildDesiredFull(repoPath, t)
 }
 
+// sourceTree is one source's checkout with the transforms that apply to it.
+type sourceTree struct {
+	Name       string
+	RepoPath   string
+	Transforms Transforms
+	Select     Selector
+}
+
+// buildDesiredSources builds the desired state of every source and merges
+// them into one, labelling each entry with its source. A name defined by two
+// sources is an error naming both files and the repository each came from.
+func buildDesiredSources(trees []sourceTree) (map[Username]DesiredState, error) {
+	desired := map[Username]DesiredState{}
+	origins := map[Username]string{} // name → "path (source x)"
+	for _, tree := range trees {
+		d, paths, err := buildDesiredScoped(tree.RepoPath, tree.Transforms, tree.Select)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", tree.Name, err)
+		}
+		for name, state := range d {
+			origin := fmt.Sprintf("%s (source %s)", paths[name], tree.Name)
+			if prev, exists := origins[name]; exists {
+				return nil, fmt.Errorf("duplicate name %q: %s and %s", name, prev, origin)
+			}
+			state.Source = tree.Name
+			desired[name] = state
+			origins[name] = origin
+		}
+	}
+	return desired, nil
+}
+
 // buildDesiredFull scans the repo and builds the desired state map using full transforms.
 func buildDesiredFull(repoPath string, t Transforms) (map[Username]DesiredState, error) {
+	desired, _, err := buildDesiredScoped(repoPath, t, Selector{})
+	return desired, err
+}
+
+// buildDesiredScoped is buildDesiredFull restricted to the specs sel picks,
+// additionally returning the spec file each desired entry was built from.
+func buildDesiredScoped(repoPath string, t Transforms, sel Selector) (map[Username]DesiredState, map[Username]string, error) {
 	desired := map[Username]DesiredState{}
 	sources := map[Username]string{} // name → source path (for collision detection)
 
-	rootScope, subdirSpecs, err := discoverContainers(repoPath)
+	rootScope, subdirSpecs, err := discoverContainers(repoPath, sel)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	ctx := newTemplateContext(t.Vars, gitHead(repoPath), t.Facts)
 
 	rootSidecars, err := groupSidecarsByOwner(rootScope, dirNameRoot)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Build set of root pod stems to identify pod members
@@ -481,15 +1036,26 @@ func buildDesiredFull(repoPath string, t Transforms) (map[Username]DesiredState,
 	for _, f := range rootStandalone {
 		name, err := NewUsername(strings.TrimSuffix(filepath.Base(f), ".container"))
 		if err != nil {
-			return nil, fmt.Errorf("%s: %w", f, err)
+			return nil, nil, fmt.Errorf("%s: %w", f, err)
 		}
-		content, secrets, err := transformContainerFile(f, t.Base, nil, t.AgeKeyFile)
+		content, secrets, applied, err := transformContainerFile(f, t, selectRootDir)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		uctx := ctx.with("Dir", selectRootDir, "Name", string(name))
+		state := buildDesiredState(name, content, t.Companions, secrets, uctx)
+		use := state.templateUse()
+		sidecarApplied, err := addSidecarFiles(state.Files, rootSidecars[string(name)], t, nil, uctx, &use)
+		if err != nil {
+			return nil, nil, err
+		}
+		state.setTemplateUse(use)
+		state.Transforms = appendNew(applied, sidecarApplied...)
+		if state.After, err = specAfter(f); err != nil {
+			return nil, nil, err
 		}
-		state := buildDesiredState(name, content, t.Companions, secrets)
-		if err := addSidecarFiles(state.Files, rootSidecars[string(name)]); err != nil {
-			return nil, err
+		if state.RenamedFrom, err = specRenamedFrom(f); err != nil {
+			return nil, nil, err
 		}
 		desired[name] = state
 		sources[name] = f
@@ -499,15 +1065,15 @@ func buildDesiredFull(repoPath string, t Transforms) (map[Username]DesiredState,
 	for stem, podFile := range rootPodStems {
 		name, err := NewPodUsername(stem)
 		if err != nil {
-			return nil, fmt.Errorf("%s: %w", podFile, err)
+			return nil, nil, fmt.Errorf("%s: %w", podFile, err)
 		}
 		if prev, exists := sources[name]; exists {
-			return nil, fmt.Errorf("duplicate name %q: %s and %s", name, prev, podFile)
+			return nil, nil, fmt.Errorf("duplicate name %q: %s and %s", name, prev, podFile)
 		}
 		members := rootPodMembers[stem]
-		state, err := buildPodDesired(stem, podFile, members, t, nil, rootSidecars)
+		state, err := buildPodDesired(stem, podFile, members, t, nil, rootSidecars, ctx)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		desired[name] = state
 		sources[name] = podFile
@@ -517,31 +1083,41 @@ func buildDesiredFull(repoPath string, t Transforms) (map[Username]DesiredState,
 	for dirName, specs := range subdirSpecs {
 		sidecarsByOwner, err := groupSidecarsByOwner(specs, dirName)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if len(specs.Pods) == 0 {
 			// No pods — all containers are standalone
-			dirTransform := t.DirContainer[dirName]
-			if dirTransform == nil {
-				return nil, fmt.Errorf("no transform for directory %s", dirName)
+			if t.DirContainer[dirName] == nil {
+				return nil, nil, fmt.Errorf("no transform for directory %s", dirName)
 			}
 
 			for _, f := range specs.Containers {
 				name, err := NewUsername(strings.TrimSuffix(filepath.Base(f), ".container"))
 				if err != nil {
-					return nil, fmt.Errorf("%s: %w", f, err)
+					return nil, nil, fmt.Errorf("%s: %w", f, err)
 				}
 				if prev, exists := sources[name]; exists {
-					return nil, fmt.Errorf("duplicate container name %q: %s and %s", name, prev, f)
+					return nil, nil, fmt.Errorf("duplicate container name %q: %s and %s", name, prev, f)
 				}
-				content, secrets, err := transformContainerFile(f, t.Base, dirTransform, t.AgeKeyFile)
+				content, secrets, applied, err := transformContainerFile(f, t, dirName)
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
-				state := buildDesiredState(name, content, t.Companions, secrets)
-				if err := addSidecarFiles(state.Files, sidecarsByOwner[string(name)]); err != nil {
-					return nil, err
+				uctx := ctx.with("Dir", dirName, "Name", string(name))
+				state := buildDesiredState(name, content, t.Companions, secrets, uctx)
+				use := state.templateUse()
+				sidecarApplied, err := addSidecarFiles(state.Files, sidecarsByOwner[string(name)], t, &dirName, uctx, &use)
+				if err != nil {
+					return nil, nil, err
+				}
+				state.setTemplateUse(use)
+				state.Transforms = appendNew(applied, sidecarApplied...)
+				if state.After, err = specAfter(f); err != nil {
+					return nil, nil, err
+				}
+				if state.RenamedFrom, err = specRenamedFrom(f); err != nil {
+					return nil, nil, err
 				}
 				desired[name] = state
 				sources[name] = f
@@ -567,7 +1143,7 @@ func buildDesiredFull(repoPath string, t Transforms) (map[Username]DesiredState,
 				}
 			}
 			if memberOf == "" {
-				return nil, fmt.Errorf("%s: container does not belong to any pod in directory %s", f, dirName)
+				return nil, nil, fmt.Errorf("%s: container does not belong to any pod in directory %s", f, dirName)
 			}
 			podMembers[memberOf] = append(podMembers[memberOf], f)
 		}
@@ -575,22 +1151,22 @@ func buildDesiredFull(repoPath string, t Transforms) (map[Username]DesiredState,
 		for stem, podFile := range podStems {
 			name, err := NewPodUsername(stem)
 			if err != nil {
-				return nil, fmt.Errorf("%s: %w", podFile, err)
+				return nil, nil, fmt.Errorf("%s: %w", podFile, err)
 			}
 			if prev, exists := sources[name]; exists {
-				return nil, fmt.Errorf("duplicate name %q: %s and %s", name, prev, podFile)
+				return nil, nil, fmt.Errorf("duplicate name %q: %s and %s", name, prev, podFile)
 			}
 			members := podMembers[stem]
-			state, err := buildPodDesired(stem, podFile, members, t, &dirName, sidecarsByOwner)
+			state, err := buildPodDesired(stem, podFile, members, t, &dirName, sidecarsByOwner, ctx)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			desired[name] = state
 			sources[name] = podFile
 		}
 	}
 
-	return desired, nil
+	return desired, sources, nil
 }
 
 const dirNameRoot = "root"
@@ -601,7 +1177,11 @@ const dirNameRoot = "root"
 func groupSidecarsByOwner(specs SubdirSpecs, dirName string) (map[string][]string, error) {
 	stems := containerStemsOf(specs.Containers)
 	out := map[string][]string{}
-	for _, f := range append(append([]string{}, specs.Services...), specs.Timers...) {
+	var sidecars []string
+	sidecars = append(sidecars, specs.Services...)
+	sidecars = append(sidecars, specs.Timers...)
+	sidecars = append(sidecars, specs.Quadlets...)
+	for _, f := range sidecars {
 		owner, ok := findSidecarOwner(f, stems)
 		if !ok {
 			return nil, fmt.Errorf("%s: sidecar in %s has no matching <stem>.container", f, dirName)
@@ -611,34 +1191,71 @@ func groupSidecarsByOwner(specs SubdirSpecs, dirName string) (map[string][]strin
 	return out, nil
 }
 
-// addSidecarFiles reads each sidecar from disk and adds its content to files,
-// keyed by basename. Sidecars are deployed verbatim — no transforms, no
-// template substitution.
-func addSidecarFiles(files map[string]string, sidecars []string) error {
+// addSidecarFiles reads each sidecar of a container from disk and adds its
+// content to files, keyed by basename. .service and .timer sidecars are
+// deployed verbatim — no transforms, no template substitution. Quadlet
+// sidecars (.volume, .network, ...) get the transforms for their type, if
+// any, and their placeholders expanded with the owning container's ctx,
+// recording what they used in use; the transforms applied are
+// returned. dirName is nil for the repo root, which has no directory
+// transforms.
+func addSidecarFiles(files map[string]string, sidecars []string, t Transforms, dirName *string, ctx templateContext, use *templateUse) ([]string, error) {
+	var applied []string
 	for _, f := range sidecars {
 		data, err := os.ReadFile(f)
 		if err != nil {
-			return fmt.Errorf("reading sidecar %s: %w", f, err)
+			return nil, fmt.Errorf("reading sidecar %s: %w", f, err)
+		}
+		content := string(data)
+		if _, ok := ownedQuadletSection(filepath.Ext(f)); ok {
+			var names []string
+			if content, names, err = transformQuadletFile(f, content, t, scopeDir(dirName)); err != nil {
+				return nil, err
+			}
+			content = ctx.expand(filepath.Base(f), content, use)
+			applied = appendNew(applied, names...)
 		}
-		files[filepath.Base(f)] = string(data)
+		files[filepath.Base(f)] = content
 	}
-	return nil
+	return applied, nil
+}
+
+// transformQuadletFile applies the transforms for its type to the content
+// of a repo Quadlet sidecar read from path in directory dir, returning the
+// result and the transforms applied. Without any the content is kept as
+// written, less any [X-Quadsync] section.
+func transformQuadletFile(path, content string, t Transforms, dir string) (string, []string, error) {
+	spec, err := ParseINI(strings.NewReader(content))
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	tList, applied := transformsFor(t, filepath.Ext(path), unitStem(path), dir, spec)
+	if len(tList) > 0 {
+		spec = applyTransforms(spec, tList)
+	} else if spec.GetSection(sectionQuadsync) == nil {
+		return content, nil, nil
+	}
+	stripQuadsyncSection(spec)
+	return spec.String(), applied, nil
 }
 
-// transformContainerFile reads and applies transforms to a container file.
-func transformContainerFile(path string, base, dirTransform *INIFile, ageKeyFile string) (string, []SecretEntry, error) {
+// transformContainerFile reads and applies transforms to a container file
+// in directory dir ("." for the repo root), returning the merged content,
+// its secrets and the transforms applied.
+func transformContainerFile(path string, t Transforms, dir string) (string, []SecretEntry, []string, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return "", nil, fmt.Errorf("reading %s: %w", path, err)
+		return "", nil, nil, fmt.Errorf("reading %s: %w", path, err)
 	}
 	spec, err := ParseINI(strings.NewReader(string(data)))
 	if err != nil {
-		return "", nil, fmt.Errorf("parsing %s: %w", path, err)
+		return "", nil, nil, fmt.Errorf("parsing %s: %w", path, err)
 	}
+	tList, applied := transformsFor(t, ".container", unitStem(path), dir, spec)
 
-	secrets, err := parseSecrets(spec, ageKeyFile)
+	secrets, err := parseSecrets(spec, t.AgeKeyFile)
 	if err != nil {
-		return "", nil, fmt.Errorf("parsing secrets in %s: %w", path, err)
+		return "", nil, nil, fmt.Errorf("parsing secrets in %s: %w", path, err)
 	}
 
 	stripSecretsSections(spec)
@@ -647,52 +1264,45 @@ func transformContainerFile(path string, base, dirTransform *INIFile, ageKeyFile
 		injectSecretDirectives(spec, containerName, secrets)
 	}
 
-	var tList []*INIFile
-	if base != nil {
-		tList = append(tList, base)
-	}
-	if dirTransform != nil {
-		tList = append(tList, dirTransform)
-	}
 	if len(tList) > 0 {
 		spec = applyTransforms(spec, tList)
 	}
-	return spec.String(), secrets, nil
+	stripQuadsyncSection(spec)
+	return spec.String(), secrets, applied, nil
 }
 
 // buildPodDesired builds a DesiredState for a pod and its members.
 // dirName is nil for root-level pods. sidecarsByOwner maps a container stem
 // (pod member) to its sidecar file paths; matching sidecars are added to the
-// pod's DesiredState files map.
-func buildPodDesired(podStem, podFile string, memberFiles []string, t Transforms, dirName *string, sidecarsByOwner map[string][]string) (DesiredState, error) {
+// pod's DesiredState files map. ctx is the checkout's template context.
+func buildPodDesired(podStem, podFile string, memberFiles []string, t Transforms, dirName *string, sidecarsByOwner map[string][]string, ctx templateContext) (DesiredState, error) {
 	files := map[string]string{}
 	var allSecrets []ContainerSecret
+	var use templateUse
+	ctx = ctx.with("Dir", scopeDir(dirName), "Pod", podStem)
 
 	// Process pod file
 	podData, err := os.ReadFile(podFile)
 	if err != nil {
 		return DesiredState{}, fmt.Errorf("reading %s: %w", podFile, err)
 	}
-	var podTList []*INIFile
-	if t.BasePod != nil {
-		podTList = append(podTList, t.BasePod)
-	}
-	if dirName != nil {
-		if dt, ok := t.DirPod[*dirName]; ok {
-			podTList = append(podTList, dt)
-		}
-	}
 	var podContent string
+	spec, err := ParseINI(strings.NewReader(string(podData)))
+	if err != nil {
+		return DesiredState{}, fmt.Errorf("parsing %s: %w", podFile, err)
+	}
+	podTList, applied := transformsFor(t, ".pod", podStem, scopeDir(dirName), spec)
 	if len(podTList) > 0 {
-		spec, err := ParseINI(strings.NewReader(string(podData)))
-		if err != nil {
-			return DesiredState{}, fmt.Errorf("parsing %s: %w", podFile, err)
-		}
-		podContent = applyTransforms(spec, podTList).String()
+		spec = applyTransforms(spec, podTList)
+		stripQuadsyncSection(spec)
+		podContent = spec.String()
+	} else if spec.GetSection(sectionQuadsync) != nil {
+		stripQuadsyncSection(spec)
+		podContent = spec.String()
 	} else {
 		podContent = string(podData)
 	}
-	podContent = strings.ReplaceAll(podContent, "{{.Name}}", podStem)
+	podContent = ctx.with("Name", podStem).expand(podStem+".pod", podContent, &use)
 	files[podStem+".pod"] = podContent
 
 	podFilename := podStem + ".pod"
@@ -700,15 +1310,13 @@ func buildPodDesired(podStem, podFile string, memberFiles []string, t Transforms
 	// Process each member
 	for _, f := range memberFiles {
 		memberFullName := strings.TrimSuffix(filepath.Base(f), ".container")
+		mctx := ctx.with("Name", memberFullName)
 
-		var dirTransform *INIFile
-		if dirName != nil {
-			dirTransform = t.DirContainer[*dirName]
-		}
-		content, memberSecrets, err := transformContainerFile(f, t.Base, dirTransform, t.AgeKeyFile)
+		content, memberSecrets, memberApplied, err := transformContainerFile(f, t, scopeDir(dirName))
 		if err != nil {
 			return DesiredState{}, err
 		}
+		applied = appendNew(applied, memberApplied...)
 		for _, s := range memberSecrets {
 			allSecrets = append(allSecrets, ContainerSecret{ContainerName: memberFullName, Entry: s})
 		}
@@ -720,13 +1328,13 @@ func buildPodDesired(podStem, podFile string, memberFiles []string, t Transforms
 		}
 		injectPod(ini, podFilename)
 		content = ini.String()
-		content = strings.ReplaceAll(content, "{{.Name}}", memberFullName)
+		content = mctx.expand(memberFullName+".container", content, &use)
 		files[memberFullName+".container"] = content
 
 		// Generate companions for this member
 		for _, c := range t.Companions {
 			companionFilename := memberFullName + c.SuffixAndExt
-			companionContent := strings.ReplaceAll(c.Content, "{{.Name}}", memberFullName)
+			companionContent := mctx.expand(companionFilename, c.Content, &use)
 			// Inject Pod= into companion .container files, unless the
 			// companion opted out via the no-pod directive (e.g. litestream,
 			// which needs default networking + host DNS to reach S3).
@@ -741,39 +1349,69 @@ func buildPodDesired(podStem, podFile string, memberFiles []string, t Transforms
 			files[companionFilename] = companionContent
 		}
 
-		// Attach .service/.timer sidecars owned by this member.
-		if err := addSidecarFiles(files, sidecarsByOwner[memberFullName]); err != nil {
+		// Attach .service/.timer and Quadlet sidecars owned by this member.
+		sidecarApplied, err := addSidecarFiles(files, sidecarsByOwner[memberFullName], t, dirName, mctx, &use)
+		if err != nil {
 			return DesiredState{}, err
 		}
+		applied = appendNew(applied, sidecarApplied...)
 	}
 
 	if len(memberFiles) == 0 {
 		log.Printf("warning: pod %s has no member containers", podStem)
 	}
 
-	return DesiredState{
+	after, err := specAfter(append([]string{podFile}, memberFiles...)...)
+	if err != nil {
+		return DesiredState{}, err
+	}
+	renamedFrom, err := specRenamedFrom(podFile)
+	if err != nil {
+		return DesiredState{}, err
+	}
+	state := DesiredState{
 		Files:       files,
 		ServiceName: podStem + "-pod",
 		Secrets:     allSecrets,
-	}, nil
+		After:       after,
+		RenamedFrom: renamedFrom,
+		Transforms:  applied,
+	}
+	state.setTemplateUse(use)
+	return state, nil
 }
 
 // buildDesiredState creates a DesiredState for a container, including its
-// main .container file and any companion files from templates.
-func buildDesiredState(name Username, containerContent string, companions []CompanionTemplate, secrets []SecretEntry) DesiredState {
+// main .container file and any companion files from templates, with their
+// placeholders expanded from ctx.
+func buildDesiredState(name Username, containerContent string, companions []CompanionTemplate, secrets []SecretEntry, ctx templateContext) DesiredState {
 	nameStr := string(name)
-	containerContent = strings.ReplaceAll(containerContent, "{{.Name}}", nameStr)
+	ctx = ctx.with("Name", nameStr)
+	var use templateUse
+	containerContent = ctx.expand(nameStr+".container", containerContent, &use)
 	files := map[string]string{nameStr + ".container": containerContent}
 	for _, c := range companions {
 		filename := nameStr + c.SuffixAndExt
-		content := strings.ReplaceAll(c.Content, "{{.Name}}", nameStr)
-		files[filename] = content
+		files[filename] = ctx.expand(filename, c.Content, &use)
 	}
 	var containerSecrets []ContainerSecret
 	for _, s := range secrets {
 		containerSecrets = append(containerSecrets, ContainerSecret{ContainerName: nameStr, Entry: s})
 	}
-	return DesiredState{Files: files, ServiceName: nameStr, Secrets: containerSecrets}
+	state := DesiredState{Files: files, ServiceName: nameStr, Secrets: containerSecrets}
+	state.setTemplateUse(use)
+	return state
+}
+
+// templateUse returns what the files of state so far depended on, for
+// expanding more of them.
+func (s DesiredState) templateUse() templateUse {
+	return templateUse{Unresolved: s.Unresolved, MissingFacts: s.MissingFacts, UserTemplated: s.UserTemplated}
+}
+
+// setTemplateUse records in s what its files depended on.
+func (s *DesiredState) setTemplateUse(use templateUse) {
+	s.Unresolved, s.MissingFacts, s.UserTemplated = use.Unresolved, use.MissingFacts, use.UserTemplated
 }
 
 func specChanged(hashDir string, name Username, state DesiredState) bool {
@@ -790,8 +1428,29 @@ func saveHash(hashDir string, name Username, state DesiredState) error {
 	return os.WriteFile(hashFile, []byte(compositeHash(state)), 0644)
 }
 
+// saveSourceLabel records which git source defines name, for `list` and the
+// web UI. Rewritten every sync since a container can move between sources.
+func saveSourceLabel(hashDir string, name Username, source string) error {
+	return os.WriteFile(sourceLabelPath(hashDir, name), []byte(source), 0644)
+}
+
+// readSourceLabel returns the recorded source of name, or "" if unknown.
+func readSourceLabel(hashDir string, name Username) string {
+	b, err := os.ReadFile(sourceLabelPath(hashDir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func sourceLabelPath(hashDir string, name Username) string {
+	return filepath.Join(hashDir, string(name)+".source")
+}
+
 // compositeHash computes a single hash over all files and secrets in a
-// DesiredState, sorted for determinism.
+// DesiredState, sorted for determinism. Template values, host facts
+// included, are part of the files, so a changed fact redeploys the
+// containers that use it.
 func compositeHash(state DesiredState) string {
 	h := sha256.New()
 	names := make([]string, 0, len(state.Files))
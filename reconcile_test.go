@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -70,6 +71,87 @@ func TestParseEnvFile(t *testing.T) {
 	}
 }
 
+func TestLoadConfigSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.env")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`QUADSYNC_GIT_URL=https://example.com/main.git
+QUADSYNC_STATE_DIR=/state
+QUADSYNC_TRANSFORM_DIR=/transforms
+QUADSYNC_SOURCES="team-a team-b"
+QUADSYNC_SOURCE_TEAM_A_GIT_URL=https://example.com/a.git
+QUADSYNC_SOURCE_TEAM_A_GIT_BRANCH=prod
+QUADSYNC_SOURCE_TEAM_B_GIT_URL=https://example.com/b.git
+QUADSYNC_SOURCE_TEAM_B_TRANSFORM_DIR=/transforms-b
+`)
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GitSource{
+		{Name: "default", URL: "https://example.com/main.git", Branch: "main", TransformDir: "/transforms", RepoPath: "/state/repo"},
+		{Name: "team-a", URL: "https://example.com/a.git", Branch: "prod", TransformDir: "/transforms", RepoPath: "/state/repos/team-a"},
+		{Name: "team-b", URL: "https://example.com/b.git", Branch: "main", TransformDir: "/transforms-b", RepoPath: "/state/repos/team-b"},
+	}
+	if !reflect.DeepEqual(c.Sources, want) {
+		t.Errorf("sources:\n got %+v\nwant %+v", c.Sources, want)
+	}
+
+	// Additional sources alone are enough.
+	write("QUADSYNC_SOURCES=only\nQUADSYNC_SOURCE_ONLY_GIT_URL=https://example.com/o.git\n")
+	c, err = LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Sources) != 1 || c.Sources[0].Name != "only" {
+		t.Errorf("got sources %+v", c.Sources)
+	}
+
+	for _, bad := range []string{
+		"",
+		"QUADSYNC_SOURCES=x\n",
+		"QUADSYNC_SOURCES=Bad\nQUADSYNC_SOURCE_BAD_GIT_URL=u\n",
+		"QUADSYNC_SOURCES=x x\nQUADSYNC_SOURCE_X_GIT_URL=u\n",
+		"QUADSYNC_GIT_URL=u\nQUADSYNC_SOURCES=default\nQUADSYNC_SOURCE_DEFAULT_GIT_URL=u\n",
+	} {
+		write(bad)
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("expected error for config %q", bad)
+		}
+	}
+}
+
+func TestBuildDesiredSources(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(a, "web.container"), []byte("[Container]\nImage=web\n"), 0644)
+	os.WriteFile(filepath.Join(b, "db.container"), []byte("[Container]\nImage=db\n"), 0644)
+
+	trees := []sourceTree{{Name: "default", RepoPath: a}, {Name: "team", RepoPath: b}}
+	desired, err := buildDesiredSources(trees)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desired["web"].Source != "default" || desired["db"].Source != "team" {
+		t.Errorf("sources: web=%q db=%q", desired["web"].Source, desired["db"].Source)
+	}
+
+	// The same name in two sources is rejected, naming both.
+	os.WriteFile(filepath.Join(b, "web.container"), []byte("[Container]\nImage=other\n"), 0644)
+	_, err = buildDesiredSources(trees)
+	if err == nil {
+		t.Fatal("expected cross-source duplicate error")
+	}
+	for _, want := range []string{`duplicate name "web"`, "(source default)", "(source team)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q missing %q", err, want)
+		}
+	}
+}
+
 func TestBuildDesiredDuplicateStem(t *testing.T) {
 	dir := t.TempDir()
 
@@ -610,6 +692,71 @@ func TestBuildDesiredWithSidecars(t *testing.T) {
 			t.Fatal("expected hash change after sidecar edit")
 		}
 	})
+
+	t.Run("quadlet sidecars get the directory transform", func(t *testing.T) {
+		dir := t.TempDir()
+		sub := filepath.Join(dir, "infra")
+		os.Mkdir(sub, 0755)
+		os.WriteFile(filepath.Join(sub, "db.container"),
+			[]byte("[Container]\nImage=postgres\nVolume=db-data.volume:/var/lib/postgresql\n"), 0644)
+		os.WriteFile(filepath.Join(sub, "db-data.volume"),
+			[]byte("[Volume]\nLabel=app={{.Name}}\n\n[X-Quadsync]\nLabel=x\n"), 0644)
+		os.WriteFile(filepath.Join(sub, "db-net.network"),
+			[]byte("[Network]\nInternal=true\n"), 0644)
+
+		volTransform, _ := ParseINI(strings.NewReader("[Volume]\nDriver=local\n"))
+		tr := Transforms{
+			DirContainer: map[string]*INIFile{"infra": {}},
+			DirQuadlet:   map[string]*INIFile{"infra.volume": volTransform},
+		}
+		desired, err := buildDesiredFull(dir, tr)
+		if err != nil {
+			t.Fatalf("buildDesiredFull: %v", err)
+		}
+		state := desired["db"]
+		vol := state.Files["db-data.volume"]
+		for _, want := range []string{"Label=app=db\n", "Driver=local\n"} {
+			if !strings.Contains(vol, want) {
+				t.Errorf("db-data.volume missing %q:\n%s", want, vol)
+			}
+		}
+		if strings.Contains(vol, "X-Quadsync") {
+			t.Errorf("[X-Quadsync] not stripped:\n%s", vol)
+		}
+		if got := state.Files["db-net.network"]; got != "[Network]\nInternal=true\n" {
+			t.Errorf("db-net.network = %q, want it verbatim", got)
+		}
+	})
+
+	t.Run("orphan quadlet sidecar fails build", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "other.container"),
+			[]byte("[Container]\nImage=x\n"), 0644)
+		os.WriteFile(filepath.Join(dir, "library-data.volume"),
+			[]byte("[Volume]\n"), 0644)
+
+		_, err := buildDesired(dir, nil, nil, nil)
+		if err == nil || !strings.Contains(err.Error(), "has no matching") {
+			t.Fatalf("expected orphan error, got: %v", err)
+		}
+	})
+}
+
+func TestLoadAllTransformsQuadlets(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "infra.container"), []byte("[Container]\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "infra.volume"), []byte("[Volume]\nDriver=local\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "infra.kube"), []byte("[Kube]\n"), 0644)
+
+	tr, err := loadAllTransforms(dir)
+	if err != nil {
+		t.Fatalf("loadAllTransforms: %v", err)
+	}
+	for _, name := range []string{"infra.volume", "infra.kube"} {
+		if tr.DirQuadlet[name] == nil {
+			t.Errorf("missing quadlet transform %s; have %v", name, tr.DirQuadlet)
+		}
+	}
 }
 
 func keysOf(m map[string]string) []string {
@@ -666,3 +813,92 @@ func TestBuildDesiredSubdirNoPodNoTransformAllowed(t *testing.T) {
 		t.Errorf("base transform not applied:\n%s", webContent)
 	}
 }
+
+// gitT runs git in dir for a test, failing it on error.
+func gitT(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := run(shortTimeout, "git", append([]string{"-C", dir}, args...)...)
+	if err != nil {
+		t.Fatalf("git %v: %v", args, err)
+	}
+	return strings.TrimSpace(out)
+}
+
+func TestSyncRepoVerifiesSignatures(t *testing.T) {
+	for _, tool := range []string{"git", "ssh-keygen"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	tmp := t.TempDir()
+	key := filepath.Join(tmp, "signing")
+	if _, err := run(shortTimeout, "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := filepath.Join(tmp, "allowed_signers")
+	os.WriteFile(signers, []byte("test@example.com "+string(pub)), 0644)
+
+	origin := filepath.Join(tmp, "origin")
+	os.Mkdir(origin, 0755)
+	gitT(t, origin, "init", "-q", "-b", "main")
+	gitT(t, origin, "config", "gpg.format", "ssh")
+	gitT(t, origin, "config", "user.signingkey", key)
+	os.WriteFile(filepath.Join(origin, "web.container"), []byte("[Container]\nImage=web:1\n"), 0644)
+	gitT(t, origin, "add", ".")
+	gitT(t, origin, "commit", "-q", "-S", "-m", "signed")
+	signed := gitT(t, origin, "rev-parse", "HEAD")
+
+	cfg := Config{GitVerify: gitVerifySSH, GitSigners: signers}
+	src := GitSource{
+		Name:     defaultSourceName,
+		URL:      "file://" + origin,
+		Branch:   "main",
+		RepoPath: filepath.Join(tmp, "state", "repo"),
+	}
+	if err := syncRepo(cfg, src); err != nil {
+		t.Fatalf("signed clone rejected: %v", err)
+	}
+	if got := gitHead(src.RepoPath); got != signed {
+		t.Fatalf("HEAD = %s, want %s", got, signed)
+	}
+
+	// An unsigned commit on the branch is refused and the signed one stays.
+	os.WriteFile(filepath.Join(origin, "web.container"), []byte("[Container]\nImage=evil\n"), 0644)
+	gitT(t, origin, "commit", "-q", "-a", "-m", "unsigned")
+	unsigned := gitT(t, origin, "rev-parse", "HEAD")
+	err = syncRepo(cfg, src)
+	if err == nil || !strings.Contains(err.Error(), "refusing to deploy commit "+unsigned) {
+		t.Fatalf("expected unsigned commit rejected, got %v", err)
+	}
+	if got := gitHead(src.RepoPath); got != signed {
+		t.Fatalf("HEAD moved to %s after rejection, want %s", got, signed)
+	}
+
+	// A signed commit from an untrusted key is refused on a fresh clone too,
+	// and no checkout is left behind.
+	other := filepath.Join(tmp, "other")
+	if _, err := run(shortTimeout, "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", other); err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _ := os.ReadFile(other + ".pub")
+	os.WriteFile(other+"-signers", []byte("test@example.com "+string(otherPub)), 0644)
+	src.RepoPath = filepath.Join(tmp, "state2", "repo")
+	cfg.GitSigners = other + "-signers"
+	gitT(t, origin, "reset", "-q", "--hard", signed)
+	if err := syncRepo(cfg, src); err == nil {
+		t.Fatal("expected clone signed by an untrusted key to be rejected")
+	}
+	if _, err := os.Stat(src.RepoPath); !os.IsNotExist(err) {
+		t.Errorf("expected rejected clone removed, stat err = %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// userRename is a managed user to be taken over by a renamed container.
+type userRename struct {
+	From, To Username
+}
+
+// specRenamedFrom reads the RenamedFrom= directive of a container or pod
+// spec, if any.
+func specRenamedFrom(file string) (Username, error) {
+	d, err := readDirectives(file)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", file, err)
+	}
+	if d.RenamedFrom == "" {
+		return "", nil
+	}
+	u, err := NewUsername(d.RenamedFrom)
+	if err != nil {
+		return "", fmt.Errorf("%s: RenamedFrom=: %w", file, err)
+	}
+	return u, nil
+}
+
+// checkRenames verifies that RenamedFrom= never names the container itself or
+// another container in the desired state, and that no two containers claim
+// the same old name.
+func checkRenames(desired map[Username]DesiredState) []error {
+	var errs []error
+	claimed := map[Username]Username{}
+	for _, name := range sortedNames(desired) {
+		from := desired[name].RenamedFrom
+		switch {
+		case from == "":
+		case from == name:
+			errs = append(errs, fmt.Errorf("%s: RenamedFrom=%s refers to itself", name, from))
+		case hasName(desired, from):
+			errs = append(errs, fmt.Errorf("%s: RenamedFrom=%s is still defined; remove it from the repo in the same change", name, from))
+		case claimed[from] != "":
+			errs = append(errs, fmt.Errorf("%s: RenamedFrom=%s is already claimed by %s", name, from, claimed[from]))
+		default:
+			claimed[from] = name
+		}
+	}
+	return errs
+}
+
+// pendingRenames lists the renames a sync performs: containers whose
+// RenamedFrom= names a managed user while they have no user of their own yet.
+// Once the rename has happened the directive is inert and can be dropped
+// from the spec at leisure.
+func pendingRenames(desired map[Username]DesiredState, currentSet map[Username]bool) []userRename {
+	var renames []userRename
+	for _, name := range sortedNames(desired) {
+		from := desired[name].RenamedFrom
+		if from != "" && currentSet[from] && !currentSet[name] {
+			renames = append(renames, userRename{From: from, To: name})
+		}
+	}
+	return renames
+}
+
+// applyRenames returns current with every renamed user replaced by its new
+// name, sorted.
+func applyRenames(current []Username, renames []userRename) []Username {
+	to := map[Username]Username{}
+	for _, r := range renames {
+		to[r.From] = r.To
+	}
+	out := make([]Username, 0, len(current))
+	for _, name := range current {
+		if n, ok := to[name]; ok {
+			name = n
+		}
+		out = append(out, name)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// migrateUser hands the user r.From over to the container r.To: its service
+// is stopped and its managed files and podman secrets removed (the deploy
+// that follows writes them under the new name), then the Linux user, its
+// group, its subuid/subgid ranges and its home directory are renamed and
+// quadsync's state files follow.
+//
+// Podman records the absolute storage paths of the old home in its
+// database, so the database is dropped once the home has moved and podman
+// starts a fresh one under the new path. Images and volume data are plain
+// files in the storage directory and are picked up again; containers are
+// recreated by their services anyway.
+func migrateUser(hashDir string, r userRename, cr *ContainerReport) error {
+	log.Printf("%s: renaming user %s (RenamedFrom=%s)", r.To, r.From, r.From)
+	stopContainer(r.From, cr)
+	if err := removeAllQuadlets(r.From); err != nil {
+		log.Printf("warning: removing quadlets for %s: %v", r.From, err)
+		cr.warn("removing quadlets of %s: %v", r.From, err)
+	}
+	if err := removePodmanSecrets(r.From); err != nil {
+		log.Printf("warning: removing secrets of %s: %v", r.From, err)
+		cr.warn("removing secrets of %s: %v", r.From, err)
+	}
+	home, err := renameUser(r.From, r.To)
+	if err != nil {
+		return fmt.Errorf("renaming user %s to %s: %w", r.From, r.To, err)
+	}
+	if err := resetPodmanDB(home); err != nil {
+		return fmt.Errorf("resetting podman database of %s: %w", r.To, err)
+	}
+	return renameState(hashDir, r.From, r.To)
+}
+
+// mergeRenameReport folds the report of renaming a user into the report of
+// deploying its container afterwards, so the sync report has one entry per
+// container. A deploy that went through is reported as the rename; one that
+// failed or was skipped keeps its outcome.
+func mergeRenameReport(rename, deploy ContainerReport) ContainerReport {
+	merged := deploy
+	switch deploy.Outcome {
+	case OutcomeCreated, OutcomeDeployed, OutcomeUnchanged:
+		merged.Outcome = OutcomeRenamed
+	}
+	merged.Warnings = append(append([]string(nil), rename.Warnings...), deploy.Warnings...)
+	merged.DurationMS = rename.DurationMS + deploy.DurationMS
+	return merged
+}
+
+// renameState moves the hash, last-known-good revision and source label of
+// from to to. A bad-revision mark and a tombstone are dropped: the renamed
+// container is a live spec again, with different files.
+func renameState(hashDir string, from, to Username) error {
+	for _, path := range []func(string, Username) string{
+		func(dir string, n Username) string { return filepath.Join(dir, string(n)) },
+		goodStatePath,
+		sourceLabelPath,
+	} {
+		if err := os.Rename(path(hashDir, from), path(hashDir, to)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("moving state of %s to %s: %w", from, to, err)
+		}
+	}
+	os.Remove(badRevisionPath(hashDir, from))
+	os.Remove(tombstonePath(hashDir, from))
+	return nil
+}
+
+// renameSubIDs renames the entries of from in a subordinate ID file
+// (/etc/subuid or /etc/subgid) to to. Entries already renamed (usermod may
+// have done it) and files without an entry are left alone.
+func renameSubIDs(path string, from, to Username) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	changed := false
+	for i, line := range lines {
+		if rest, ok := strings.CutPrefix(line, string(from)+":"); ok {
+			lines[i] = string(to) + ":" + rest
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".quadsync"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "")), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
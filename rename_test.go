@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckRenames(t *testing.T) {
+	desired := map[Username]DesiredState{
+		"bar":  {RenamedFrom: "foo"},
+		"baz":  {RenamedFrom: "foo"},
+		"self": {RenamedFrom: "self"},
+		"web":  {RenamedFrom: "bar"},
+	}
+	var got []string
+	for _, err := range checkRenames(desired) {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		"baz: RenamedFrom=foo is already claimed by bar",
+		"self: RenamedFrom=self refers to itself",
+		"web: RenamedFrom=bar is still defined; remove it from the repo in the same change",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestPendingRenames(t *testing.T) {
+	desired := map[Username]DesiredState{
+		"bar":   {RenamedFrom: "foo"},
+		"done":  {RenamedFrom: "gone"}, // already renamed on an earlier sync
+		"again": {RenamedFrom: "old"},  // both users exist: nothing to take over
+		"old":   {},
+	}
+	current := []Username{"again", "foo", "old", "other"}
+	set := map[Username]bool{}
+	for _, u := range current {
+		set[u] = true
+	}
+	renames := pendingRenames(desired, set)
+	if want := []userRename{{From: "foo", To: "bar"}}; !reflect.DeepEqual(renames, want) {
+		t.Fatalf("renames = %v, want %v", renames, want)
+	}
+	if got, want := applyRenames(current, renames), []Username{"again", "bar", "old", "other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applyRenames = %v, want %v", got, want)
+	}
+}
+
+func TestRenameSubIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subuid")
+	os.WriteFile(path, []byte("alice:100000:65536\nfoo:165536:65536\nfoobar:231072:65536\n"), 0644)
+	if err := renameSubIDs(path, "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	if want := "alice:100000:65536\nbar:165536:65536\nfoobar:231072:65536\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := renameSubIDs(filepath.Join(t.TempDir(), "missing"), "foo", "bar"); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestRenameState(t *testing.T) {
+	hashDir := t.TempDir()
+	for _, f := range []string{"foo", "foo.good", "foo.source", "foo.bad", "foo.tombstone"} {
+		os.WriteFile(filepath.Join(hashDir, f), []byte(f), 0644)
+	}
+	if err := renameState(hashDir, "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(hashDir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"bar", "bar.good", "bar.source"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("state files = %v, want %v", names, want)
+	}
+}
+
+func TestBuildPlanRename(t *testing.T) {
+	desired := map[Username]DesiredState{
+		"bar": {Files: map[string]string{"bar.container": "[Container]\nImage=x\n"}, ServiceName: "bar", RenamedFrom: "foo"},
+	}
+	plan, err := buildPlan(t.TempDir(), Config{Retention: Retention{Policy: RetainKeep}}, desired, []Username{"foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []PlanRename{{From: "foo", To: "bar"}}; !reflect.DeepEqual(plan.Rename, want) {
+		t.Errorf("Rename = %v, want %v", plan.Rename, want)
+	}
+	if len(plan.Create) != 0 || len(plan.Retire) != 0 || len(plan.Remove) != 0 {
+		t.Errorf("rename planned as create/remove: %+v", plan)
+	}
+	if !strings.Contains(plan.String(), "  > foo -> bar\n") {
+		t.Errorf("rendered plan:\n%s", plan.String())
+	}
+}
+
+func TestBuildDesiredRenamedFrom(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "bar.container"), []byte("[Container]\nImage=x\n\n[X-Quadsync]\nRenamedFrom=foo\n"), 0644)
+	desired, err := buildDesiredFull(dir, Transforms{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := desired["bar"].RenamedFrom; got != "foo" {
+		t.Errorf("RenamedFrom = %q", got)
+	}
+	if strings.Contains(desired["bar"].Files["bar.container"], "RenamedFrom") {
+		t.Errorf("directive deployed:\n%s", desired["bar"].Files["bar.container"])
+	}
+}
+
+func TestCheckDirRenamedFrom(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "foo.container"), []byte("[Container]\nImage=x\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "bar.container"), []byte("[Container]\nImage=x\n\n[X-Quadsync]\nRenamedFrom=foo\n"), 0644)
+
+	errs := CheckDir(dir, Selector{})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "bar: RenamedFrom=foo is still defined") {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestMergeRenameReport(t *testing.T) {
+	rename := ContainerReport{Name: "bar", Outcome: OutcomeRenamed, Warnings: []string{"stopping foo: timeout"}, DurationMS: 100}
+	deploy := ContainerReport{Name: "bar", Source: "default", Outcome: OutcomeDeployed, Warnings: []string{"hook: slow"}, DurationMS: 50}
+	got := mergeRenameReport(rename, deploy)
+	want := ContainerReport{Name: "bar", Source: "default", Outcome: OutcomeRenamed,
+		Warnings: []string{"stopping foo: timeout", "hook: slow"}, DurationMS: 150}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	deploy = ContainerReport{Name: "bar", Outcome: OutcomeFailed, Error: "daemon-reload failed"}
+	if got := mergeRenameReport(rename, deploy); got.Outcome != OutcomeFailed || got.Error != deploy.Error {
+		t.Errorf("failed deploy: got %+v", got)
+	}
+}
+
+func TestResetPodmanDB(t *testing.T) {
+	home := t.TempDir()
+	keep := filepath.Join(home, ".local/share/containers/storage/volumes/data/_data/file")
+	os.MkdirAll(filepath.Dir(keep), 0755)
+	os.WriteFile(keep, []byte("data"), 0644)
+	for _, f := range podmanDBFiles {
+		os.MkdirAll(filepath.Join(home, filepath.Dir(f)), 0755)
+		os.WriteFile(filepath.Join(home, f), []byte("db"), 0644)
+	}
+	if err := resetPodmanDB(home); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range podmanDBFiles {
+		if _, err := os.Stat(filepath.Join(home, f)); !os.IsNotExist(err) {
+			t.Errorf("%s still there: %v", f, err)
+		}
+	}
+	if _, err := os.Stat(keep); err != nil {
+		t.Errorf("volume data removed: %v", err)
+	}
+	// A home podman never ran in.
+	if err := resetPodmanDB(t.TempDir()); err != nil {
+		t.Errorf("empty home: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Per-container outcomes recorded in a SyncReport.
+const (
+	OutcomeCreated    = "created"     // new user, files deployed
+	OutcomeUnchanged  = "unchanged"   // hash matched, nothing written
+	OutcomeDeployed   = "deployed"    // existing user, files redeployed
+	OutcomeFailed     = "failed"      // quadsync could not complete the step
+	OutcomeRemoved    = "removed"     // user no longer in the repo (or tombstone expired), deleted
+	OutcomeTombstoned = "tombstoned"  // user no longer in the repo, stopped and kept until purged
+	OutcomeRefused    = "refused"     // user no longer in the repo, removal held back by the mass-deletion guard
+	OutcomeRenamed    = "renamed"     // existing user renamed to this container's name (RenamedFrom=), files deployed
+	OutcomeRolledBack = "rolled-back" // new revision did not come up, previous one restored
+	OutcomeSkipped    = "skipped"     // revision previously rolled back, not retried
+)
+
+// reportHistory is how many sync reports are kept in the state directory.
+const reportHistory = 50
+
+// reportTimeLayout names report files so that lexical order is time order.
+const reportTimeLayout = "20060102T150405.000000000Z"
+
+// SyncReport is the structured record of one Sync run, persisted as JSON
+// under <StateDir>/reports/ and served over the control socket.
+type SyncReport struct {
+	Started    time.Time         `json:"started"`
+	Finished   time.Time         `json:"finished"`
+	OK         bool              `json:"ok"`
+	Error      string            `json:"error,omitempty"`
+	Sources    []SourceReport    `json:"sources,omitempty"`
+	Steps      []StepTiming      `json:"steps,omitempty"`
+	Containers []ContainerReport `json:"containers,omitempty"`
+}
+
+// SourceReport records the commit of one git source before and after the
+// fetch. CommitBefore is empty on the first clone.
+type SourceReport struct {
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	CommitBefore string `json:"commit_before,omitempty"`
+	CommitAfter  string `json:"commit_after,omitempty"`
+}
+
+// StepTiming is the wall-clock duration of one pipeline step.
+type StepTiming struct {
+	Name       string `json:"name"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// ContainerReport is the outcome of a sync for one managed user. Warnings
+// carry problems that are not quadsync failures (e.g. a service that did not
+// restart), so downstream tooling can still tell a degraded sync from a clean one.
+type ContainerReport struct {
+	Name       string   `json:"name"`
+	Source     string   `json:"source,omitempty"` // git source that defines the container
+	Outcome    string   `json:"outcome"`
+	Error      string   `json:"error,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+	Down       bool     `json:"down,omitempty"` // service failed to restart or to become healthy
+	DurationMS int64    `json:"duration_ms"`
+}
+
+func newSyncReport() *SyncReport {
+	return &SyncReport{Started: time.Now().UTC()}
+}
+
+// step starts timing a named step; call the returned func when it ends.
+func (r *SyncReport) step(name string) func() {
+	start := time.Now()
+	return func() {
+		r.Steps = append(r.Steps, StepTiming{Name: name, DurationMS: time.Since(start).Milliseconds()})
+	}
+}
+
+// finish stamps the end time and overall result.
+func (r *SyncReport) finish(err error) {
+	r.Finished = time.Now().UTC()
+	r.OK = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+func (c *ContainerReport) warn(format string, args ...any) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, args...))
+}
+
+func reportDir(stateDir string) string {
+	return filepath.Join(stateDir, "reports")
+}
+
+// saveReport writes r to the reports directory and drops the oldest reports
+// beyond reportHistory.
+func saveReport(stateDir string, r *SyncReport) error {
+	dir := reportDir(stateDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating report dir: %w", err)
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := r.Started.UTC().Format(reportTimeLayout) + ".json"
+	tmp := filepath.Join(dir, "."+name)
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+
+	files, err := reportFiles(dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > reportHistory {
+		os.Remove(filepath.Join(dir, files[0]))
+		files = files[1:]
+	}
+	return nil
+}
+
+// loadReports returns up to n of the most recent reports, newest first.
+func loadReports(stateDir string, n int) ([]SyncReport, error) {
+	dir := reportDir(stateDir)
+	files, err := reportFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var reports []SyncReport
+	for i := len(files) - 1; i >= 0 && len(reports) < n; i-- {
+		data, err := os.ReadFile(filepath.Join(dir, files[i]))
+		if err != nil {
+			return nil, fmt.Errorf("reading report %s: %w", files[i], err)
+		}
+		var r SyncReport
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("decoding report %s: %w", files[i], err)
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// reportFiles lists report filenames in dir, oldest first.
+func reportFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading report dir: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files, nil
+}
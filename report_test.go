@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadReports(t *testing.T) {
+	stateDir := t.TempDir()
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for i := 0; i < reportHistory+5; i++ {
+		r := &SyncReport{Started: base.Add(time.Duration(i) * time.Minute)}
+		r.Containers = []ContainerReport{{Name: "web", Outcome: OutcomeDeployed}}
+		var err error
+		if i%2 == 0 {
+			err = errors.New("boom")
+		}
+		r.finish(err)
+		if err := saveReport(stateDir, r); err != nil {
+			t.Fatalf("saveReport: %v", err)
+		}
+	}
+
+	files, err := reportFiles(reportDir(stateDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != reportHistory {
+		t.Fatalf("expected history bounded to %d, got %d", reportHistory, len(files))
+	}
+
+	reports, err := loadReports(stateDir, 3)
+	if err != nil {
+		t.Fatalf("loadReports: %v", err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("expected 3 reports, got %d", len(reports))
+	}
+	newest := base.Add(time.Duration(reportHistory+4) * time.Minute)
+	if !reports[0].Started.Equal(newest) {
+		t.Errorf("expected newest report first, got %v want %v", reports[0].Started, newest)
+	}
+	if reports[0].OK || reports[0].Error != "boom" {
+		t.Errorf("expected failed report with error, got %+v", reports[0])
+	}
+	if !reports[1].OK {
+		t.Errorf("expected second report ok, got %+v", reports[1])
+	}
+	if len(reports[0].Containers) != 1 || reports[0].Containers[0].Outcome != OutcomeDeployed {
+		t.Errorf("container outcome not persisted: %+v", reports[0].Containers)
+	}
+}
+
+func TestLoadReportsMissingDir(t *testing.T) {
+	reports, err := loadReports(t.TempDir(), 10)
+	if err != nil || len(reports) != 0 {
+		t.Fatalf("expected no reports and no error, got %v, %v", reports, err)
+	}
+}
+
+func TestReportStepAndWarn(t *testing.T) {
+	r := newSyncReport()
+	done := r.step("git")
+	done()
+	if len(r.Steps) != 1 || r.Steps[0].Name != "git" {
+		t.Fatalf("step not recorded: %+v", r.Steps)
+	}
+	var cr ContainerReport
+	cr.warn("restarting %s: %v", "web", os.ErrDeadlineExceeded)
+	if len(cr.Warnings) != 1 || cr.Warnings[0] != "restarting web: i/o timeout" {
+		t.Errorf("unexpected warnings: %v", cr.Warnings)
+	}
+}
+
+func TestOpReportsCount(t *testing.T) {
+	cfg := Config{StateDir: t.TempDir()}
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < reportHistory; i++ {
+		r := &SyncReport{Started: base.Add(time.Duration(i) * time.Minute)}
+		r.finish(nil)
+		if err := saveReport(cfg.StateDir, r); err != nil {
+			t.Fatalf("saveReport: %v", err)
+		}
+	}
+	for _, tc := range []struct{ count, want int }{
+		{0, 10},
+		{-1, 10},
+		{20, 20},
+		{reportHistory + 100, reportHistory},
+	} {
+		resp := opReports(cfg, tc.count)
+		if !resp.OK || len(resp.Reports) != tc.want {
+			t.Errorf("count %d: got %d reports (ok=%v), want %d", tc.count, len(resp.Reports), resp.OK, tc.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// After a deploy, quadsync can wait for the service to come up and, if it
+// does not, put the user's last-known-good revision back. The good revision
+// is kept next to the stored hash as hashes/<name>.good (a JSON DesiredState,
+// including decrypted secrets, hence 0600) and a revision that failed is
+// remembered as hashes/<name>.bad so it is not retried until the spec changes.
+
+// healthPollInterval is how often waitHealthy re-checks a service.
+var healthPollInterval = 2 * time.Second
+
+// serviceHealth reports a service's systemd ActiveState and container health.
+// Indirection so tests can simulate services coming up or failing.
+var serviceHealth = userServiceHealth
+
+// userServiceHealth reads the ActiveState of a user's service and, for
+// standalone containers, the podman healthcheck status.
+func userServiceHealth(name Username, service string) (active, health string) {
+	props, err := userSystemctlShow(name, service+".service")
+	if err != nil {
+		return "unknown", ""
+	}
+	active = props["ActiveState"]
+	if service == string(name) {
+		_, _, health = podmanInspect(name)
+	}
+	return active, health
+}
+
+// waitHealthy polls until the service is active with no failing or pending
+// healthcheck, or returns an error once it fails or the timeout expires.
+func waitHealthy(name Username, service string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		active, health := serviceHealth(name, service)
+		switch {
+		case active == "failed":
+			return fmt.Errorf("%s.service failed", service)
+		case health == "unhealthy":
+			return fmt.Errorf("%s healthcheck reports unhealthy", name)
+		case active == "active" && health != "starting":
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s.service not active within %s (state %s, health %s)", service, timeout, active, health)
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
+
+// verifyDeploy waits for a freshly deployed revision to come up. If it does,
+// the revision becomes the user's last-known-good state; if not, the previous
+// good revision is restored and the new one is marked bad.
+func verifyDeploy(config Config, hashDir string, name Username, state DesiredState, cr *ContainerReport) error {
+	waitErr := waitHealthy(name, state.ServiceName, config.RollbackTimeout)
+	if waitErr == nil {
+		if err := saveGoodState(hashDir, name, state); err != nil {
+			return fmt.Errorf("saving last-known-good state for %s: %w", name, err)
+		}
+		return nil
+	}
+	log.Printf("warning: %s did not come up: %v", name, waitErr)
+	cr.warn("did not come up: %v", waitErr)
+	cr.Down = true
+
+	prev, ok, err := loadGoodState(hashDir, name)
+	if err != nil {
+		return fmt.Errorf("loading last-known-good state for %s: %w", name, err)
+	}
+	if ok && compositeHash(prev) == compositeHash(state) {
+		// A forced redeploy of the known-good revision; nothing to go back to.
+		return nil
+	}
+	if err := markBadRevision(hashDir, name, state); err != nil {
+		return fmt.Errorf("marking bad revision for %s: %w", name, err)
+	}
+	if !ok {
+		cr.warn("no previous good revision to roll back to")
+		return nil
+	}
+
+	log.Printf("%s: rolling back to previous revision", name)
+	if err := rollbackContainer(name, state, prev); err != nil {
+		return fmt.Errorf("rolling back %s: %w", name, err)
+	}
+	if err := saveHash(hashDir, name, prev); err != nil {
+		return fmt.Errorf("saving hash for %s: %w", name, err)
+	}
+	cr.Outcome = OutcomeRolledBack
+	return nil
+}
+
+// rollbackContainer replaces the failed revision's files, secrets and timers
+// with prev's and restarts the service.
+func rollbackContainer(name Username, failed, prev DesiredState) error {
+	for filename := range failed.Files {
+		if _, keep := prev.Files[filename]; !keep && strings.HasSuffix(filename, ".timer") {
+			if err := disableTimer(name, filename); err != nil {
+				log.Printf("warning: disabling %s for %s: %v", filename, name, err)
+			}
+		}
+	}
+	if failed.ServiceName != prev.ServiceName {
+		if err := stopService(name, failed.ServiceName); err != nil {
+			log.Printf("warning: stopping %s: %v", failed.ServiceName, err)
+		}
+	}
+	if err := removeAllQuadlets(name); err != nil {
+		return err
+	}
+	resolved, err := resolveStateUserVars(name, prev)
+	if err != nil {
+		return err
+	}
+	for filename, content := range resolved.Files {
+		if err := writeQuadletFile(name, filename, content); err != nil {
+			return err
+		}
+	}
+	if len(prev.Secrets) > 0 {
+		if err := createPodmanSecrets(name, prev.Secrets); err != nil {
+			return err
+		}
+	}
+	if err := daemonReload(name); err != nil {
+		return err
+	}
+	for filename := range prev.Files {
+		if strings.HasSuffix(filename, ".timer") {
+			if err := enableTimer(name, filename); err != nil {
+				log.Printf("warning: enabling %s for %s: %v", filename, name, err)
+			}
+		}
+	}
+	return restartService(name, prev.ServiceName)
+}
+
+func goodStatePath(hashDir string, name Username) string {
+	return filepath.Join(hashDir, string(name)+".good")
+}
+
+func badRevisionPath(hashDir string, name Username) string {
+	return filepath.Join(hashDir, string(name)+".bad")
+}
+
+// saveGoodState records state as the user's last-known-good revision.
+func saveGoodState(hashDir string, name Username, state DesiredState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	path := goodStatePath(hashDir, name)
+	if err := os.WriteFile(path+".new", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".new", path)
+}
+
+// loadGoodState returns the user's last-known-good revision, if any.
+func loadGoodState(hashDir string, name Username) (DesiredState, bool, error) {
+	data, err := os.ReadFile(goodStatePath(hashDir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return DesiredState{}, false, nil
+		}
+		return DesiredState{}, false, err
+	}
+	var state DesiredState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return DesiredState{}, false, err
+	}
+	return state, true, nil
+}
+
+// markBadRevision remembers that state failed to come up.
+func markBadRevision(hashDir string, name Username, state DesiredState) error {
+	return os.WriteFile(badRevisionPath(hashDir, name), []byte(compositeHash(state)), 0644)
+}
+
+// isBadRevision reports whether state is the revision last marked bad.
+func isBadRevision(hashDir string, name Username, state DesiredState) bool {
+	data, err := os.ReadFile(badRevisionPath(hashDir, name))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(data)) == compositeHash(state)
+}
+
+// clearRevisionState forgets the user's good and bad revisions.
+func clearRevisionState(hashDir string, name Username) {
+	os.Remove(goodStatePath(hashDir, name))
+	os.Remove(badRevisionPath(hashDir, name))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubHealth replaces serviceHealth with a sequence of (active, health)
+// results; the last one repeats.
+func stubHealth(t *testing.T, seq ...[2]string) {
+	t.Helper()
+	orig, origInterval := serviceHealth, healthPollInterval
+	t.Cleanup(func() { serviceHealth, healthPollInterval = orig, origInterval })
+	healthPollInterval = time.Millisecond
+	i := 0
+	serviceHealth = func(Username, string) (string, string) {
+		r := seq[min(i, len(seq)-1)]
+		i++
+		return r[0], r[1]
+	}
+}
+
+func TestWaitHealthy(t *testing.T) {
+	cases := []struct {
+		name    string
+		seq     [][2]string
+		wantErr string
+	}{
+		{"active immediately", [][2]string{{"active", "none"}}, ""},
+		{"activating then active", [][2]string{{"activating", ""}, {"active", ""}}, ""},
+		{"healthcheck starting then healthy", [][2]string{{"active", "starting"}, {"active", "healthy"}}, ""},
+		{"failed", [][2]string{{"activating", ""}, {"failed", ""}}, "failed"},
+		{"unhealthy", [][2]string{{"active", "unhealthy"}}, "unhealthy"},
+		{"never comes up", [][2]string{{"activating", ""}}, "not active within"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stubHealth(t, c.seq...)
+			err := waitHealthy("web", "web", 20*time.Millisecond)
+			if c.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", c.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRevisionState(t *testing.T) {
+	hashDir := t.TempDir()
+	good := DesiredState{
+		Files:       map[string]string{"web.container": "[Container]\nImage=web:1\n"},
+		ServiceName: "web",
+		Secrets:     []ContainerSecret{{ContainerName: "web", Entry: SecretEntry{Name: "TOKEN", Type: secretTypeEnv, Value: "s3cret"}}},
+	}
+	bad := DesiredState{Files: map[string]string{"web.container": "[Container]\nImage=web:2\n"}, ServiceName: "web"}
+
+	if _, ok, err := loadGoodState(hashDir, "web"); ok || err != nil {
+		t.Fatalf("expected no good state yet, got ok=%v err=%v", ok, err)
+	}
+	if err := saveGoodState(hashDir, "web", good); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := loadGoodState(hashDir, "web")
+	if err != nil || !ok {
+		t.Fatalf("loadGoodState: ok=%v err=%v", ok, err)
+	}
+	if compositeHash(got) != compositeHash(good) {
+		t.Error("good state did not round-trip (files, service or secrets differ)")
+	}
+
+	if isBadRevision(hashDir, "web", bad) {
+		t.Fatal("revision should not be bad before marking")
+	}
+	if err := markBadRevision(hashDir, "web", bad); err != nil {
+		t.Fatal(err)
+	}
+	if !isBadRevision(hashDir, "web", bad) {
+		t.Error("expected marked revision to be bad")
+	}
+	if isBadRevision(hashDir, "web", good) {
+		t.Error("a different revision must not be treated as bad")
+	}
+
+	clearRevisionState(hashDir, "web")
+	if _, ok, _ := loadGoodState(hashDir, "web"); ok || isBadRevision(hashDir, "web", bad) {
+		t.Error("expected revision state cleared")
+	}
+}
+
+func TestVerifyDeploy(t *testing.T) {
+	cfg := Config{RollbackTimeout: 10 * time.Millisecond}
+	state := DesiredState{Files: map[string]string{"web.container": "[Container]\nImage=web:1\n"}, ServiceName: "web"}
+
+	t.Run("healthy revision becomes last-known-good", func(t *testing.T) {
+		hashDir := t.TempDir()
+		stubHealth(t, [2]string{"active", "healthy"})
+		cr := ContainerReport{Outcome: OutcomeDeployed}
+		if err := verifyDeploy(cfg, hashDir, "web", state, &cr); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, _ := loadGoodState(hashDir, "web"); !ok {
+			t.Error("expected good state saved")
+		}
+		if cr.Outcome != OutcomeDeployed || len(cr.Warnings) != 0 {
+			t.Errorf("unexpected report: %+v", cr)
+		}
+	})
+
+	t.Run("first revision failing is marked bad without rollback", func(t *testing.T) {
+		hashDir := t.TempDir()
+		stubHealth(t, [2]string{"failed", ""})
+		cr := ContainerReport{Outcome: OutcomeCreated}
+		if err := verifyDeploy(cfg, hashDir, "web", state, &cr); err != nil {
+			t.Fatal(err)
+		}
+		if !isBadRevision(hashDir, "web", state) {
+			t.Error("expected failing revision marked bad")
+		}
+		if _, ok, _ := loadGoodState(hashDir, "web"); ok {
+			t.Error("failing revision must not become last-known-good")
+		}
+		if len(cr.Warnings) != 2 || !strings.Contains(cr.Warnings[1], "no previous good revision") {
+			t.Errorf("expected warnings about failure and missing rollback target, got %v", cr.Warnings)
+		}
+	})
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The schema below lists the keys Quadlet (podman-systemd.unit(5), podman
+// 5.x) and systemd accept in each section of the unit files quadsync deploys,
+// so that `check` can catch a typo or a misplaced key before podman silently
+// ignores it. A key maps to true if it may be set more than once (list
+// settings such as Environment=), false if a later setting replaces an
+// earlier one.
+
+// sectionKeys is the set of keys of one section.
+type sectionKeys map[string]bool
+
+// unitSchema maps the sections a unit type may contain to their keys. A nil
+// sectionKeys marks a quadsync section that is validated elsewhere.
+type unitSchema map[string]sectionKeys
+
+// keys builds a sectionKeys from space-separated single- and multi-valued
+// key lists.
+func keys(single, multi string) sectionKeys {
+	s := sectionKeys{}
+	for _, k := range strings.Fields(single) {
+		s[k] = false
+	}
+	for _, k := range strings.Fields(multi) {
+		s[k] = true
+	}
+	return s
+}
+
+var (
+	containerKeys = keys(
+		`AutoUpdate CgroupsMode ContainerName Entrypoint EnvironmentHost Exec Group
+		HealthCmd HealthInterval HealthLogDestination HealthMaxLogCount HealthMaxLogSize
+		HealthOnFailure HealthRetries HealthStartPeriod HealthStartupCmd HealthStartupInterval
+		HealthStartupRetries HealthStartupSuccess HealthStartupTimeout HealthTimeout HostName
+		IP IP6 Image LogDriver Memory NoNewPrivileges Notify PidsLimit Pod Pull ReadOnly
+		ReadOnlyTmpfs ReloadCmd ReloadSignal Retry RetryDelay Rootfs RunInit SeccompProfile
+		SecurityLabelDisable SecurityLabelFileType SecurityLabelLevel SecurityLabelNested
+		SecurityLabelType ShmSize StartWithPod StopSignal StopTimeout SubGIDMap SubUIDMap
+		Timezone User UserNS WorkingDir`,
+		`AddCapability AddDevice AddHost Annotation ContainersConfModule DNS DNSOption
+		DNSSearch DropCapability Environment EnvironmentFile ExposeHostPort GIDMap GlobalArgs
+		GroupAdd Label LogOpt Mask Mount Network NetworkAlias PodmanArgs PublishPort Secret
+		Sysctl Tmpfs UIDMap Ulimit Unmask Volume`)
+
+	podKeys = keys(
+		`ExitPolicy HostName IP IP6 PodName ServiceName ShmSize StopTimeout SubGIDMap
+		SubUIDMap UserNS`,
+		`AddHost ContainersConfModule DNS DNSOption DNSSearch GIDMap GlobalArgs Label
+		Network NetworkAlias PodmanArgs PublishPort UIDMap Volume`)
+
+	volumeKeys = keys(
+		`Copy Device Driver Group Image Options Type User VolumeName`,
+		`ContainersConfModule GlobalArgs Label PodmanArgs`)
+
+	networkKeys = keys(
+		`DisableDNS Driver IPAMDriver IPv6 InterfaceName Internal NetworkDeleteOnStop
+		NetworkName Options`,
+		`ContainersConfModule DNS Gateway GlobalArgs IPRange Label PodmanArgs Subnet`)
+
+	kubeKeys = keys(
+		`ExitCodePropagation KubeDownForce LogDriver SetWorkingDirectory UserNS`,
+		`AutoUpdate ConfigMap ContainersConfModule GlobalArgs Network PodmanArgs
+		PublishPort Yaml`)
+
+	imageKeys = keys(
+		`AllTags Arch AuthFile CertDir Creds DecryptionKey Image ImageTag OS Policy Retry
+		RetryDelay TLSVerify Variant`,
+		`ContainersConfModule GlobalArgs PodmanArgs`)
+
+	buildKeys = keys(
+		`Arch AuthFile File ForceRM Pull Retry RetryDelay SetWorkingDirectory Target
+		TLSVerify Variant`,
+		`Annotation BuildArg ContainersConfModule DNS DNSOption DNSSearch Environment
+		GlobalArgs GroupAdd ImageTag Label Network PodmanArgs Secret Volume`)
+
+	quadletKeys = keys(`DefaultDependencies`, ``)
+
+	// Condition*= and Assert*= keys are accepted by prefix, see lookupKey.
+	unitKeys = keys(
+		`AllowIsolate CollectMode DefaultDependencies Description FailureAction
+		FailureActionExitStatus IgnoreOnIsolate JobRunningTimeoutSec JobTimeoutAction
+		JobTimeoutRebootArgument JobTimeoutSec OnFailureJobMode RebootArgument
+		RefuseManualStart RefuseManualStop SourcePath StartLimitAction StartLimitBurst
+		StartLimitIntervalSec StopWhenUnneeded SuccessAction SuccessActionExitStatus
+		SurviveFinalKillSignal`,
+		`After Before BindsTo Conflicts Documentation JoinsNamespaceOf OnFailure OnSuccess
+		PartOf PropagatesReloadTo PropagatesStopTo ReloadPropagatedFrom Requires
+		RequiresMountsFor Requisite StopPropagatedFrom Upholds Wants WantsMountsFor`)
+
+	installKeys = keys(
+		`DefaultInstance`,
+		`Alias Also RequiredBy UpheldBy WantedBy`)
+
+	// serviceKeys covers systemd.service(5) plus the execution, kill and
+	// resource-control settings every service accepts.
+	serviceKeys = keys(
+		`BusName ExitType FileDescriptorStoreMax FileDescriptorStorePreserve GuessMainPID
+		NonBlocking NotifyAccess OOMPolicy PIDFile ReloadSignal RemainAfterExit Restart
+		RestartMaxDelaySec RestartMode RestartSec RestartSteps RootDirectoryStartOnly
+		RuntimeMaxSec RuntimeRandomizedExtraSec TimeoutAbortSec TimeoutSec
+		TimeoutStartFailureMode TimeoutStartSec TimeoutStopFailureMode TimeoutStopSec Type
+		USBFunctionDescriptors USBFunctionStrings WatchdogSec
+
+		AppArmorProfile CPUSchedulingPolicy CPUSchedulingPriority CPUSchedulingResetOnFork
+		CacheDirectoryMode ConfigurationDirectoryMode CoredumpFilter DynamicUser Group
+		IOSchedulingClass IOSchedulingPriority IPCNamespacePath IgnoreSIGPIPE KeyringMode
+		LimitAS LimitCORE LimitCPU LimitDATA LimitFSIZE LimitLOCKS LimitMEMLOCK LimitMSGQUEUE
+		LimitNICE LimitNOFILE LimitNPROC LimitRSS LimitRTPRIO LimitRTTIME LimitSIGPENDING
+		LimitSTACK LockPersonality LogLevelMax LogNamespace LogRateLimitBurst
+		LogRateLimitIntervalSec LogsDirectoryMode MemoryDenyWriteExecute MemoryKSM MountAPIVFS
+		MountFlags NUMAMask NUMAPolicy NetworkNamespacePath Nice NoNewPrivileges OOMScoreAdjust
+		PAMName Personality PrivateDevices PrivateIPC PrivateMounts PrivateNetwork PrivatePIDs
+		PrivateTmp PrivateUsers ProcSubset ProtectClock ProtectControlGroups ProtectHome
+		ProtectHostname ProtectKernelLogs ProtectKernelModules ProtectKernelTunables
+		ProtectProc ProtectSystem RemoveIPC RestrictRealtime RestrictSUIDSGID RootDirectory
+		RootHash RootHashSignature RootImage RootImageOptions RootVerity
+		RuntimeDirectoryMode RuntimeDirectoryPreserve SELinuxContext SecureBits
+		SetLoginEnvironment SmackProcessLabel StandardError StandardInput StandardOutput
+		StateDirectoryMode SyslogFacility SyslogIdentifier SyslogLevel SyslogLevelPrefix
+		SystemCallErrorNumber SystemCallLog TTYColumns TTYPath TTYReset TTYRows
+		TTYVHangup TTYVTDisallocate TimeoutCleanSec TimerSlackNSec UMask User
+		UtmpIdentifier UtmpMode WorkingDirectory
+
+		FinalKillSignal KillMode KillSignal RestartKillSignal SendSIGHUP SendSIGKILL
+		WatchdogSignal
+
+		AllowedCPUs AllowedMemoryNodes BlockIOAccounting BlockIOWeight CPUAccounting
+		CPUQuota CPUQuotaPeriodSec CPUShares CPUWeight CoredumpReceive DefaultMemoryLow
+		DefaultMemoryMin DefaultStartupMemoryLow Delegate DelegateSubgroup DevicePolicy
+		IOAccounting IOWeight IPAccounting ManagedOOMMemoryPressure
+		ManagedOOMMemoryPressureDurationSec ManagedOOMMemoryPressureLimit
+		ManagedOOMPreference ManagedOOMSwap MemoryAccounting MemoryHigh MemoryLimit MemoryLow
+		MemoryMax MemoryMin MemoryPressureThresholdSec MemoryPressureWatch MemorySwapMax
+		MemoryZSwapMax MemoryZSwapWriteback Slice StartupAllowedCPUs
+		StartupAllowedMemoryNodes StartupCPUShares StartupCPUWeight StartupIOWeight
+		StartupMemoryHigh StartupMemoryLow StartupMemoryMax StartupMemorySwapMax
+		StartupMemoryZSwapMax TasksAccounting TasksMax`,
+		`ExecCondition ExecReload ExecStart ExecStartPost ExecStartPre ExecStop ExecStopPost
+		OpenFile RestartForceExitStatus RestartPreventExitStatus Sockets SuccessExitStatus
+
+		AmbientCapabilities BindPaths BindReadOnlyPaths CPUAffinity CacheDirectory
+		CapabilityBoundingSet ConfigurationDirectory Environment EnvironmentFile ExecPaths
+		ExtensionDirectories ExtensionImages ImportCredential InaccessiblePaths
+		LoadCredential LoadCredentialEncrypted LogExtraFields LogFilterPatterns LogsDirectory
+		MountImages NoExecPaths PassEnvironment ReadOnlyPaths ReadWritePaths
+		RestrictAddressFamilies RestrictFileSystems RestrictNamespaces RuntimeDirectory
+		SetCredential SetCredentialEncrypted StandardInputData StandardInputText
+		StateDirectory SupplementaryGroups SystemCallArchitectures SystemCallFilter
+		TemporaryFileSystem UnsetEnvironment
+
+		BPFProgram DeviceAllow DisableControllers IODeviceLatencyTargetSec IODeviceWeight
+		IOReadBandwidthMax IOReadIOPSMax IOWriteBandwidthMax IOWriteIOPSMax IPAddressAllow
+		IPAddressDeny IPEgressFilterPath IPIngressFilterPath NFTSet
+		RestrictNetworkInterfaces SocketBindAllow SocketBindDeny`)
+
+	timerKeys = keys(
+		`AccuracySec DeferReactivation FixedRandomDelay OnClockChange OnTimezoneChange
+		Persistent RandomizedDelaySec RandomizedOffsetSec RemainAfterElapse Unit WakeSystem`,
+		`OnActiveSec OnBootSec OnCalendar OnStartupSec OnUnitActiveSec OnUnitInactiveSec`)
+)
+
+// unitSchemas maps a unit file extension to its schema.
+var unitSchemas = map[string]unitSchema{
+	".container": {"Container": containerKeys, "Unit": unitKeys, "Service": serviceKeys, "Install": installKeys, "Quadlet": quadletKeys, sectionSecrets: nil},
+	".pod":       {"Pod": podKeys, "Unit": unitKeys, "Service": serviceKeys, "Install": installKeys, "Quadlet": quadletKeys},
+	".volume":    {"Volume": volumeKeys, "Unit": unitKeys, "Service": serviceKeys, "Install": installKeys, "Quadlet": quadletKeys},
+	".network":   {"Network": networkKeys, "Unit": unitKeys, "Service": serviceKeys, "Install": installKeys, "Quadlet": quadletKeys},
+	".kube":      {"Kube": kubeKeys, "Unit": unitKeys, "Service": serviceKeys, "Install": installKeys, "Quadlet": quadletKeys},
+	".image":     {"Image": imageKeys, "Unit": unitKeys, "Service": serviceKeys, "Install": installKeys, "Quadlet": quadletKeys},
+	".build":     {"Build": buildKeys, "Unit": unitKeys, "Service": serviceKeys, "Install": installKeys, "Quadlet": quadletKeys},
+	".service":   {"Unit": unitKeys, "Service": serviceKeys, "Install": installKeys},
+	".timer":     {"Unit": unitKeys, "Timer": timerKeys, "Install": installKeys},
+}
+
+// valueCheckers validate the values of keys whose syntax is easy to get
+// wrong, by section and key.
+var valueCheckers = map[string]map[string]func(string) error{
+	"Container": {"PublishPort": checkPublishPort},
+	"Pod":       {"PublishPort": checkPublishPort},
+	"Kube":      {"PublishPort": checkPublishPort},
+	"Timer":     {"OnCalendar": checkCalendar},
+}
+
+// lookupKey reports whether key is known in the section keys, and whether
+// it may be repeated.
+func lookupKey(section string, keys sectionKeys, key string) (known, multi bool) {
+	if section == "Unit" && (strings.HasPrefix(key, "Condition") || strings.HasPrefix(key, "Assert")) {
+		return true, true
+	}
+	multi, known = keys[key]
+	return known, multi
+}
+
+// checkSchema checks a parsed unit file against the schema of its type,
+// given by ext (".container", ".timer", ...): unknown sections and keys,
+// keys that belong in another section, single-valued keys set more than
+// once, and malformed values of keys that have a checker. Sections and keys
+// starting with "X-" are extensions systemd ignores, and are skipped.
+// source labels the file in errors that concern a whole section.
+func checkSchema(f *INIFile, ext, source string) []error {
+	schema, ok := unitSchemas[ext]
+	if !ok {
+		return nil
+	}
+	var errs []error
+	for i := range f.Sections {
+		sec := &f.Sections[i]
+		if sec.Name == "" {
+			for _, e := range sec.Entries {
+				if e.Key != "" {
+					errs = append(errs, entryError(e, "%s= is outside any section", e.Key))
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(sec.Name, "X-") {
+			continue
+		}
+		keys, ok := schema[sec.Name]
+		if !ok {
+			msg := fmt.Sprintf("unknown section [%s] in a %s file", sec.Name, ext)
+			if s := suggest(sec.Name, sortedKeys(schema)); s != "" {
+				msg += fmt.Sprintf(" (did you mean [%s]?)", s)
+			}
+			errs = append(errs, sectionError(source, sec, "%s", msg))
+			continue
+		}
+		if keys == nil {
+			continue
+		}
+		errs = append(errs, checkSectionKeys(schema, sec, keys)...)
+	}
+	return errs
+}
+
+// checkSectionKeys checks the entries of one section against its keys.
+func checkSectionKeys(schema unitSchema, sec *Section, keys sectionKeys) []error {
+	var errs []error
+	first := map[string]int{} // line each single-valued key was first set on
+	for _, e := range sec.Entries {
+		if e.Key == "" || strings.HasPrefix(e.Key, "X-") {
+			continue
+		}
+		known, multi := lookupKey(sec.Name, keys, e.Key)
+		if !known {
+			errs = append(errs, unknownKeyError(schema, sec.Name, e))
+			continue
+		}
+		if !multi && e.Value != "" {
+			if line, ok := first[e.Key]; ok {
+				errs = append(errs, entryError(e, "%s= is set more than once in [%s] (first on line %d) but takes a single value", e.Key, sec.Name, line))
+			} else {
+				first[e.Key] = e.Line
+			}
+		}
+		// A value with a template placeholder is checked once it is filled
+		// in, by the check of the merged output.
+		if check := valueCheckers[sec.Name][e.Key]; check != nil && e.Value != "" && !templateVarRe.MatchString(e.Value) {
+			if err := check(e.Value); err != nil {
+				errs = append(errs, entryError(e, "%s=%s: %v", e.Key, e.Value, err))
+			}
+		}
+	}
+	return errs
+}
+
+// unknownKeyError describes a key its section does not accept: where it
+// belongs if another section of the unit type takes it, else the closest
+// known key.
+func unknownKeyError(schema unitSchema, section string, e Entry) error {
+	for _, other := range sortedKeys(schema) {
+		if keys := schema[other]; keys != nil {
+			if known, _ := lookupKey(other, keys, e.Key); known {
+				return entryError(e, "%s= belongs in [%s], not [%s]", e.Key, other, section)
+			}
+		}
+	}
+	if s := suggest(e.Key, sortedKeys(schema[section])); s != "" {
+		return entryError(e, "unknown key %s= in [%s] (did you mean %s=?)", e.Key, section, s)
+	}
+	return entryError(e, "unknown key %s= in [%s]", e.Key, section)
+}
+
+// checkUnitFile parses content and checks it against the schema for the
+// unit type of filename. Files of other types are not checked.
+func checkUnitFile(filename, content, source string) []error {
+	ext := filepath.Ext(filename)
+	if _, ok := unitSchemas[ext]; !ok {
+		return nil
+	}
+	f, err := ParseINIFrom(strings.NewReader(content), source)
+	if err != nil {
+		return []error{fileError(source, "parse error: %v", err)}
+	}
+	return checkSchema(f, ext, source)
+}
+
+// suggest returns the candidate closest to word by case-insensitive edit
+// distance, or "" if none is close enough to be a likely typo.
+func suggest(word string, candidates []string) string {
+	best, bestDist := "", 0
+	for _, c := range candidates {
+		d := editDistance(strings.ToLower(word), strings.ToLower(c))
+		if best == "" || d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	if best == "" || bestDist > max(2, len(word)/3) {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// checkPublishPort validates a PublishPort= value:
+// [[ip:][hostPort]:]containerPort[/protocol], where ports may be ranges
+// (8000-8010) and an IPv6 address is bracketed.
+func checkPublishPort(v string) error {
+	spec, proto, hasProto := strings.Cut(v, "/")
+	if hasProto {
+		switch proto {
+		case "tcp", "udp", "sctp":
+		default:
+			return fmt.Errorf("unknown protocol %q (expected tcp, udp or sctp)", proto)
+		}
+	}
+
+	var ip string
+	var ports []string
+	if bracketed, ok := strings.CutPrefix(spec, "["); ok {
+		addr, rest, ok := strings.Cut(bracketed, "]")
+		if !ok || !strings.HasPrefix(rest, ":") {
+			return fmt.Errorf("malformed bracketed address")
+		}
+		ip, ports = addr, strings.Split(rest[1:], ":")
+		if len(ports) != 2 {
+			return fmt.Errorf("expected [ip]:hostPort:containerPort")
+		}
+	} else {
+		parts := strings.Split(spec, ":")
+		switch len(parts) {
+		case 1, 2:
+			ports = parts
+		case 3:
+			ip, ports = parts[0], parts[1:]
+		default:
+			return fmt.Errorf("expected [[ip:][hostPort]:]containerPort[/protocol]")
+		}
+	}
+	if ip != "" && net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid IP address %q", ip)
+	}
+
+	container := ports[len(ports)-1]
+	cLo, cHi, err := parsePortRange(container)
+	if err != nil {
+		return fmt.Errorf("container port: %w", err)
+	}
+	if len(ports) == 2 {
+		host := ports[0]
+		if host == "" {
+			if ip == "" {
+				return fmt.Errorf("empty host port")
+			}
+			return nil // ip::containerPort picks a random host port
+		}
+		hLo, hHi, err := parsePortRange(host)
+		if err != nil {
+			return fmt.Errorf("host port: %w", err)
+		}
+		if cHi > cLo && hHi-hLo != cHi-cLo {
+			return fmt.Errorf("host port range %s and container port range %s differ in size", host, container)
+		}
+	}
+	return nil
+}
+
+// parsePortRange parses a port (80) or port range (8000-8010).
+func parsePortRange(s string) (lo, hi int, err error) {
+	a, b, isRange := strings.Cut(s, "-")
+	if lo, err = parsePort(a); err != nil {
+		return 0, 0, err
+	}
+	hi = lo
+	if isRange {
+		if hi, err = parsePort(b); err != nil {
+			return 0, 0, err
+		}
+		if hi < lo {
+			return 0, 0, fmt.Errorf("range %s is reversed", s)
+		}
+	}
+	return lo, hi, nil
+}
+
+func parsePort(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 65535 {
+		return 0, fmt.Errorf("%q is not a port number (1-65535)", s)
+	}
+	return n, nil
+}
+
+// calendarShorthands are the OnCalendar= keywords systemd.time(7) accepts.
+var calendarShorthands = map[string]bool{
+	"minutely": true, "hourly": true, "daily": true, "weekly": true, "monthly": true,
+	"quarterly": true, "semiannually": true, "yearly": true, "annually": true,
+}
+
+var (
+	weekdayRe  = regexp.MustCompile(`^(?i)(mon|tue|wed|thu|fri|sat|sun)[a-z]*$`)
+	timezoneRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+-]*(/[A-Za-z0-9_+-]+)*$`)
+)
+
+// checkCalendar validates an OnCalendar= value in the calendar event syntax
+// of systemd.time(7): [weekdays] [date] [time] [timezone], or a shorthand
+// such as "daily". It checks the shape and ranges of each field, not
+// whether the event ever elapses.
+func checkCalendar(v string) error {
+	if calendarShorthands[strings.ToLower(v)] {
+		return nil
+	}
+	fields := strings.Fields(v)
+	if len(fields) == 0 {
+		return fmt.Errorf("empty calendar event")
+	}
+	if isWeekdaySpec(fields[0]) {
+		fields = fields[1:]
+	}
+	sawDate, sawTime := false, false
+	for i, f := range fields {
+		switch {
+		case strings.Contains(f, ":") && !sawTime:
+			if err := checkCalendarTime(f); err != nil {
+				return err
+			}
+			sawTime = true
+		case (strings.Contains(f, "-") || strings.Contains(f, "~")) && !sawDate && !sawTime:
+			if err := checkCalendarDate(f); err != nil {
+				return err
+			}
+			sawDate = true
+		case i == len(fields)-1 && (sawDate || sawTime) && timezoneRe.MatchString(f):
+			// trailing timezone, e.g. UTC or Europe/Berlin
+		default:
+			return fmt.Errorf("unexpected %q in calendar event", f)
+		}
+	}
+	return nil
+}
+
+// isWeekdaySpec reports whether s is a weekday list such as Mon,Wed or
+// Mon..Fri.
+func isWeekdaySpec(s string) bool {
+	for _, item := range strings.Split(s, ",") {
+		for _, day := range strings.Split(item, "..") {
+			if !weekdayRe.MatchString(day) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkCalendarDate validates [year-]month-day, where the day may follow a
+// "~" (counting from the end of the month) instead of a "-".
+func checkCalendarDate(s string) error {
+	parts := strings.Split(s, "-")
+	if last := parts[len(parts)-1]; strings.Contains(last, "~") {
+		month, day, _ := strings.Cut(last, "~")
+		parts = append(parts[:len(parts)-1], month, day)
+	}
+	bounds := [][2]int{{1, 12}, {1, 31}}
+	names := []string{"month", "day"}
+	switch len(parts) {
+	case 2:
+	case 3:
+		bounds = append([][2]int{{1970, 2199}}, bounds...)
+		names = append([]string{"year"}, names...)
+	default:
+		return fmt.Errorf("date %q: expected [year-]month-day", s)
+	}
+	for i, p := range parts {
+		if err := checkCalendarComponent(p, bounds[i][0], bounds[i][1]); err != nil {
+			return fmt.Errorf("date %q: %s: %w", s, names[i], err)
+		}
+	}
+	return nil
+}
+
+// checkCalendarTime validates hour:minute[:second].
+func checkCalendarTime(s string) error {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return fmt.Errorf("time %q: expected hour:minute[:second]", s)
+	}
+	names := []string{"hour", "minute", "second"}
+	bounds := [][2]int{{0, 23}, {0, 59}, {0, 59}}
+	for i, p := range parts {
+		if i == 2 {
+			p, _, _ = strings.Cut(p, ".") // fractional seconds
+		}
+		if err := checkCalendarComponent(p, bounds[i][0], bounds[i][1]); err != nil {
+			return fmt.Errorf("time %q: %s: %w", s, names[i], err)
+		}
+	}
+	return nil
+}
+
+// checkCalendarComponent validates one date or time component: "*" or a
+// comma-separated list of values, ranges (a..b) and repetitions (a/n, *
+// /n), each value within lo..hi.
+func checkCalendarComponent(s string, lo, hi int) error {
+	if s == "" {
+		return fmt.Errorf("empty")
+	}
+	for _, item := range strings.Split(s, ",") {
+		base, rep, hasRep := strings.Cut(item, "/")
+		if hasRep {
+			if n, err := strconv.Atoi(rep); err != nil || n < 1 {
+				return fmt.Errorf("invalid repetition %q", rep)
+			}
+		}
+		if base == "*" {
+			continue
+		}
+		for _, v := range strings.Split(base, "..") {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", v)
+			}
+			if n < lo || n > hi {
+				return fmt.Errorf("%d is out of range %d-%d", n, lo, hi)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func schemaErrors(t *testing.T, ext, content string) []string {
+	t.Helper()
+	f, err := ParseINIFrom(strings.NewReader(content), "x"+ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, err := range checkSchema(f, ext, "x"+ext) {
+		out = append(out, err.Error())
+	}
+	return out
+}
+
+func TestCheckSchema(t *testing.T) {
+	for _, tc := range []struct {
+		name, ext, content string
+		want               []string
+	}{
+		{"valid container", ".container",
+			"[Unit]\nDescription=web\nConditionPathExists=/srv\n\n[Container]\nImage=nginx\nEnvironment=A=1\nEnvironment=B=2\nPublishPort=127.0.0.1:8080:80/tcp\nX-Team=web\n\n[Service]\nRestart=always\n\n[Install]\nWantedBy=default.target\n\n[X-Quadsync]\nLabel=role=web\n",
+			nil},
+		{"typo", ".container", "[Container]\nImage=nginx\nEnviroment=A=1\n",
+			[]string{"x.container:3: unknown key Enviroment= in [Container] (did you mean Environment=?)"}},
+		{"unknown without suggestion", ".container", "[Container]\nImage=nginx\nFrobnicate=yes\n",
+			[]string{"x.container:3: unknown key Frobnicate= in [Container]"}},
+		{"wrong section", ".container", "[Container]\nImage=nginx\nRestart=always\n",
+			[]string{"x.container:3: Restart= belongs in [Service], not [Container]"}},
+		{"unknown section", ".container", "[Container]\nImage=nginx\n[Instal]\nWantedBy=default.target\n",
+			[]string{"x.container:3: unknown section [Instal] in a .container file (did you mean [Install]?)"}},
+		{"section of another unit type", ".pod", "[Pod]\n[Container]\nImage=nginx\n",
+			[]string{"x.pod:2: unknown section [Container] in a .pod file"}},
+		{"set twice", ".container", "[Container]\nImage=nginx\nImage=caddy\n",
+			[]string{"x.container:3: Image= is set more than once in [Container] (first on line 2) but takes a single value"}},
+		{"empty assignment resets", ".service", "[Service]\nType=\nType=oneshot\nExecStart=/bin/a\nExecStart=/bin/b\n",
+			nil},
+		{"outside any section", ".timer", "OnCalendar=daily\n[Timer]\nOnCalendar=Mon..Fri *-*-* 09:00 Europe/Berlin\n",
+			[]string{"x.timer:1: OnCalendar= is outside any section"}},
+		{"bad calendar", ".timer", "[Timer]\nOnCalendar=*-*-* 25:00\n",
+			[]string{`x.timer:2: OnCalendar=*-*-* 25:00: time "25:00": hour: 25 is out of range 0-23`}},
+		{"bad port", ".pod", "[Pod]\nPublishPort=8080:80:tcp\n",
+			[]string{`x.pod:2: PublishPort=8080:80:tcp: invalid IP address "8080"`}},
+		{"volume", ".volume", "[Volume]\nDriver=local\nVolumeName=data\n", nil},
+		{"unknown types are not checked", ".conf", "[Whatever]\nKey=value\n", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := schemaErrors(t, tc.ext, tc.content)
+			if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCheckPublishPort(t *testing.T) {
+	for _, v := range []string{"80", "8080:80", "8080:80/udp", "127.0.0.1:8080:80", "127.0.0.1::80",
+		"[::1]:8080:80", "8000-8010:9000-9010", "8000-8010:80"} {
+		if err := checkPublishPort(v); err != nil {
+			t.Errorf("checkPublishPort(%q) = %v", v, err)
+		}
+	}
+	for _, v := range []string{"", "80/http", "0", "70000:80", ":80", "a:b:c:d", "[::1:80:80",
+		"8000-8010:9000-9005", "9000-8000:80", "1.2.3:80:80"} {
+		if err := checkPublishPort(v); err == nil {
+			t.Errorf("checkPublishPort(%q) accepted", v)
+		}
+	}
+}
+
+func TestCheckCalendar(t *testing.T) {
+	for _, v := range []string{"daily", "Weekly", "*-*-* *:*:*", "Mon,Wed *-*-* 02:30", "Sat..Sun 10:00",
+		"*:0/15", "2024-12-25", "*-02~03", "Mon *-05~07/1", "*-*-01 04:00:00 UTC", "Fri 18:00:30.5",
+		"Sun"} {
+		if err := checkCalendar(v); err != nil {
+			t.Errorf("checkCalendar(%q) = %v", v, err)
+		}
+	}
+	for _, v := range []string{"fortnightly", "*-13-01", "24:00", "*:60", "Mon Tue", "*-*-* 10:00 10:00",
+		"2024-01-01-01", "*:*/0", "Mon..Fryday"} {
+		if err := checkCalendar(v); err == nil {
+			t.Errorf("checkCalendar(%q) accepted", v)
+		}
+	}
+}
+
+func TestCheckDesiredSchema(t *testing.T) {
+	desired := map[Username]DesiredState{
+		"web": {Files: map[string]string{
+			"web.container":    "[Container]\nImage=nginx\nPull=newer\nImage=caddy\n",
+			"web-data.volume":  "[Volume]\nDriverr=local\n",
+			"web-backup.timer": "[Timer]\nOnCalendar=daily\n\n[Install]\nWantedBy=timers.target\n",
+		}},
+	}
+	var got []string
+	for _, err := range CheckDesired(desired) {
+		got = append(got, err.Error())
+	}
+	sort.Strings(got)
+	want := []string{
+		"merged output for web-data.volume:2: unknown key Driverr= in [Volume] (did you mean Driver=?)",
+		"merged output for web:4: Image= is set more than once in [Container] (first on line 2) but takes a single value",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
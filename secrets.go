@@ -81,34 +81,34 @@ func validateSecretsSection(ini *INIFile) error {
 		case secretKeyEnvironment:
 			name, payload, err := splitEnvironmentSecret(entry.Value)
 			if err != nil {
-				return fmt.Errorf("secret %q: %w", entry.Key, err)
+				return entryError(entry, "secret %q: %v", entry.Key, err)
 			}
 			if !validSecretName.MatchString(name) {
-				return fmt.Errorf("secret %q: invalid secret name %q: must match [A-Za-z_][A-Za-z0-9_]*", entry.Key, name)
+				return entryError(entry, "secret %q: invalid secret name %q: must match [A-Za-z_][A-Za-z0-9_]*", entry.Key, name)
 			}
 			if payload == "" {
-				return fmt.Errorf("secret %q: empty environment value", entry.Key)
+				return entryError(entry, "secret %q: empty environment value", entry.Key)
 			}
 		case secretKeyFile:
 			name, target, payload, err := splitFileSecret(entry.Value)
 			if err != nil {
-				return fmt.Errorf("secret %q: %w", entry.Key, err)
+				return entryError(entry, "secret %q: %v", entry.Key, err)
 			}
 			if !validSecretName.MatchString(name) {
-				return fmt.Errorf("secret %q: invalid secret name %q: must match [A-Za-z_][A-Za-z0-9_]*", entry.Key, name)
+				return entryError(entry, "secret %q: invalid secret name %q: must match [A-Za-z_][A-Za-z0-9_]*", entry.Key, name)
 			}
 			if target == "" {
-				return fmt.Errorf("secret %q: empty file target path", entry.Key)
+				return entryError(entry, "secret %q: empty file target path", entry.Key)
 			}
 			if payload == "" {
-				return fmt.Errorf("secret %q: empty file value", entry.Key)
+				return entryError(entry, "secret %q: empty file value", entry.Key)
 			}
 			if prevTarget, exists := fileTargetsByName[name]; exists && prevTarget != target {
-				return fmt.Errorf("secret %q: file secret target %q collides with %q (derived name %q)", entry.Key, target, prevTarget, name)
+				return entryError(entry, "secret %q: file secret target %q collides with %q (derived name %q)", entry.Key, target, prevTarget, name)
 			}
 			fileTargetsByName[name] = target
 		default:
-			return fmt.Errorf("secret %q: unknown secret directive %q (expected %s or %s)", entry.Key, entry.Key, secretKeyEnvironment, secretKeyFile)
+			return entryError(entry, "secret %q: unknown secret directive %q (expected %s or %s)", entry.Key, entry.Key, secretKeyEnvironment, secretKeyFile)
 		}
 	}
 
@@ -135,7 +135,7 @@ func TestTransformContainerFileInjectsSecrets(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	transformed, secrets, err := transformContainerFile(path, nil, nil, keyFile)
+	transformed, secrets, _, err := transformContainerFile(path, Transforms{AgeKeyFile: keyFile}, selectRootDir)
 	if err != nil {
 		t.Fatal(err)
 	}
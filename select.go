@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// selectRootDir names the repository root in QUADSYNC_SELECT_DIRS.
+const selectRootDir = "."
+
+var validLabelKeyRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// Selector picks which specs a host deploys. Each populated criterion must
+// match (a unit must be in one of Dirs, match one of Names and satisfy every
+// Labels expression); the zero Selector selects everything.
+type Selector struct {
+	Dirs   []string // subdirectory names, "." for the repo root
+	Names  []string // path.Match globs against the container or pod name
+	Labels []string // "key", "key=value" or "key!=value" against [X-Quadsync] Label=
+}
+
+// parseSelector reads QUADSYNC_SELECT_DIRS, QUADSYNC_SELECT_NAMES and
+// QUADSYNC_SELECT_LABELS (each space-separated) from a config env map.
+func parseSelector(env map[string]string) (Selector, error) {
+	s := Selector{
+		Dirs:   strings.Fields(env["QUADSYNC_SELECT_DIRS"]),
+		Names:  strings.Fields(env["QUADSYNC_SELECT_NAMES"]),
+		Labels: strings.Fields(env["QUADSYNC_SELECT_LABELS"]),
+	}
+	for _, g := range s.Names {
+		if _, err := path.Match(g, ""); err != nil {
+			return Selector{}, fmt.Errorf("invalid QUADSYNC_SELECT_NAMES glob %q", g)
+		}
+	}
+	for _, expr := range s.Labels {
+		key, _, _ := splitLabelExpr(expr)
+		if !validLabelKeyRe.MatchString(key) {
+			return Selector{}, fmt.Errorf("invalid QUADSYNC_SELECT_LABELS expression %q", expr)
+		}
+	}
+	return s, nil
+}
+
+// Empty reports whether the selector selects everything.
+func (s Selector) Empty() bool {
+	return len(s.Dirs) == 0 && len(s.Names) == 0 && len(s.Labels) == 0
+}
+
+// String renders the selector for log and check output.
+func (s Selector) String() string {
+	if s.Empty() {
+		return "everything"
+	}
+	var parts []string
+	if len(s.Dirs) > 0 {
+		parts = append(parts, "dirs="+strings.Join(s.Dirs, ","))
+	}
+	if len(s.Names) > 0 {
+		parts = append(parts, "names="+strings.Join(s.Names, ","))
+	}
+	if len(s.Labels) > 0 {
+		parts = append(parts, "labels="+strings.Join(s.Labels, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s Selector) matchDir(dir string) bool {
+	if len(s.Dirs) == 0 {
+		return true
+	}
+	for _, d := range s.Dirs {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// matchUnit reports whether a container or pod named name, defined in file,
+// passes the name and label criteria. A file whose labels cannot be read is
+// selected, so that validation reports the problem instead of the spec
+// silently disappearing from the host.
+func (s Selector) matchUnit(name, file string) bool {
+	if !matchGlobs(s.Names, name) {
+		return false
+	}
+	if len(s.Labels) == 0 {
+		return true
+	}
+	d, err := readDirectives(file)
+	if err != nil {
+		return true
+	}
+	return matchLabels(s.Labels, d.Labels)
+}
+
+// matchGlobs reports whether name matches one of globs; no globs match
+// everything.
+func matchGlobs(globs []string, name string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, g := range globs {
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLabels reports whether labels satisfy every label expression.
+func matchLabels(exprs []string, labels map[string]string) bool {
+	for _, expr := range exprs {
+		key, value, op := splitLabelExpr(expr)
+		got, has := labels[key]
+		switch op {
+		case "":
+			if !has {
+				return false
+			}
+		case "=":
+			if !has || got != value {
+				return false
+			}
+		case "!=":
+			if has && got == value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// splitLabelExpr splits "key=value" / "key!=value" / "key" into its parts.
+func splitLabelExpr(expr string) (key, value, op string) {
+	if k, v, ok := strings.Cut(expr, "!="); ok {
+		return k, v, "!="
+	}
+	if k, v, ok := strings.Cut(expr, "="); ok {
+		return k, v, "="
+	}
+	return expr, "", ""
+}
+
+// filterScope drops the units of one scope (dir is "." for the root) that
+// the selector does not pick. Pod members follow their pod and sidecars
+// follow their owning container; a sidecar without an owner is kept so that
+// validation still reports it.
+func (s Selector) filterScope(specs SubdirSpecs, dir string) SubdirSpecs {
+	if s.Empty() {
+		return specs
+	}
+	if !s.matchDir(dir) {
+		return SubdirSpecs{}
+	}
+
+	var out SubdirSpecs
+	podStems := map[string]bool{}
+	keptPods := map[string]bool{}
+	for _, f := range specs.Pods {
+		stem := strings.TrimSuffix(filepath.Base(f), ".pod")
+		podStems[stem] = true
+		if s.matchUnit(stem, f) {
+			out.Pods = append(out.Pods, f)
+			keptPods[stem] = true
+		}
+	}
+
+	keptStems := map[string]bool{}
+	for _, f := range specs.Containers {
+		name := strings.TrimSuffix(filepath.Base(f), ".container")
+		keep := false
+		if pod := podOf(name, podStems); pod != "" {
+			keep = keptPods[pod]
+		} else {
+			keep = s.matchUnit(name, f)
+		}
+		if keep {
+			out.Containers = append(out.Containers, f)
+			keptStems[name] = true
+		}
+	}
+
+	stems := containerStemsOf(specs.Containers)
+	keepSidecar := func(f string) bool {
+		owner, ok := findSidecarOwner(f, stems)
+		return !ok || keptStems[owner]
+	}
+	for _, f := range specs.Services {
+		if keepSidecar(f) {
+			out.Services = append(out.Services, f)
+		}
+	}
+	for _, f := range specs.Timers {
+		if keepSidecar(f) {
+			out.Timers = append(out.Timers, f)
+		}
+	}
+	for _, f := range specs.Quadlets {
+		if keepSidecar(f) {
+			out.Quadlets = append(out.Quadlets, f)
+		}
+	}
+	return out
+}
+
+// podOf returns the pod stem a container belongs to by name prefix, or "".
+func podOf(name string, podStems map[string]bool) string {
+	for stem := range podStems {
+		if strings.HasPrefix(name, stem+"-") {
+			return stem
+		}
+	}
+	return ""
+}
+
+// SelectedSpecs lists, relative to repoPath, the .container and .pod files
+// that define the units the selector picks: standalone containers and pods.
+// Pod members and sidecars are implied by their owner and not listed.
+func SelectedSpecs(repoPath string, sel Selector) ([]string, error) {
+	root, subdirs, err := discoverContainers(repoPath, sel)
+	if err != nil {
+		return nil, err
+	}
+	scopes := []SubdirSpecs{root}
+	for _, specs := range subdirs {
+		scopes = append(scopes, specs)
+	}
+	var out []string
+	for _, specs := range scopes {
+		podStems := map[string]bool{}
+		for _, f := range specs.Pods {
+			podStems[strings.TrimSuffix(filepath.Base(f), ".pod")] = true
+			out = append(out, f)
+		}
+		for _, f := range specs.Containers {
+			if podOf(strings.TrimSuffix(filepath.Base(f), ".container"), podStems) == "" {
+				out = append(out, f)
+			}
+		}
+	}
+	for i, f := range out {
+		if rel, err := filepath.Rel(repoPath, f); err == nil {
+			out[i] = rel
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
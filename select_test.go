@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	s, err := parseSelector(map[string]string{
+		"QUADSYNC_SELECT_DIRS":   ". webapps",
+		"QUADSYNC_SELECT_NAMES":  "web-* db",
+		"QUADSYNC_SELECT_LABELS": "role=web env!=dev gpu",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Selector{
+		Dirs:   []string{".", "webapps"},
+		Names:  []string{"web-*", "db"},
+		Labels: []string{"role=web", "env!=dev", "gpu"},
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+
+	if s, err := parseSelector(map[string]string{}); err != nil || !s.Empty() {
+		t.Errorf("empty env: got %+v, %v", s, err)
+	}
+	if _, err := parseSelector(map[string]string{"QUADSYNC_SELECT_NAMES": "web-["}); err == nil {
+		t.Error("expected error for bad glob")
+	}
+	if _, err := parseSelector(map[string]string{"QUADSYNC_SELECT_LABELS": "=x"}); err == nil {
+		t.Error("expected error for empty label key")
+	}
+}
+
+// selectionRepo lays out a repo with root containers, a labelled pod with a
+// member and sidecar, and a subdirectory.
+func selectionRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(rel, content string) {
+		p := filepath.Join(dir, rel)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("web-a.container", "[Container]\nImage=x\n\n[X-Quadsync]\nLabel=role=web\nLabel=env=prod\n")
+	write("web-b.container", "[Container]\nImage=x\n\n[X-Quadsync]\nLabel=role=web\nLabel=env=dev\n")
+	write("db.container", "[Container]\nImage=x\n\n[X-Quadsync]\nLabel=role=db\n")
+	write("app.pod", "[Pod]\n\n[X-Quadsync]\nLabel=role=web\n")
+	write("app-main.container", "[Container]\nImage=x\n")
+	write("app-main-refresh.service", "[Service]\nExecStart=/bin/true\n")
+	write("infra/proxy.container", "[Container]\nImage=x\n")
+	return dir
+}
+
+func TestSelectedSpecs(t *testing.T) {
+	dir := selectionRepo(t)
+	tests := []struct {
+		name string
+		sel  Selector
+		want []string
+	}{
+		{"everything", Selector{}, []string{"app.pod", "db.container", "infra/proxy.container", "web-a.container", "web-b.container"}},
+		{"root only", Selector{Dirs: []string{"."}}, []string{"app.pod", "db.container", "web-a.container", "web-b.container"}},
+		{"subdir only", Selector{Dirs: []string{"infra"}}, []string{"infra/proxy.container"}},
+		{"name glob", Selector{Names: []string{"web-*", "proxy"}}, []string{"infra/proxy.container", "web-a.container", "web-b.container"}},
+		{"label", Selector{Labels: []string{"role=web"}}, []string{"app.pod", "web-a.container", "web-b.container"}},
+		{"label and not", Selector{Labels: []string{"role=web", "env!=dev"}}, []string{"app.pod", "web-a.container"}},
+		{"label exists", Selector{Labels: []string{"env"}}, []string{"web-a.container", "web-b.container"}},
+		{"criteria combine", Selector{Dirs: []string{"."}, Names: []string{"web-*"}, Labels: []string{"env=prod"}}, []string{"web-a.container"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectedSpecs(dir, tt.sel)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverContainersSelection(t *testing.T) {
+	dir := selectionRepo(t)
+
+	// Pod members and their sidecars follow the pod.
+	root, subdirs, err := discoverContainers(dir, Selector{Labels: []string{"role=web"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subdirs) != 0 {
+		t.Errorf("expected unselected subdir dropped, got %v", subdirs)
+	}
+	if len(root.Pods) != 1 || len(root.Services) != 1 {
+		t.Errorf("expected pod and its member's sidecar kept, got %+v", root)
+	}
+	names := containerStemsOf(root.Containers)
+	if !reflect.DeepEqual(names, []string{"app-main", "web-a", "web-b"}) {
+		t.Errorf("containers = %v", names)
+	}
+
+	root, _, err = discoverContainers(dir, Selector{Names: []string{"db"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Pods) != 0 || len(root.Services) != 0 || len(root.Containers) != 1 {
+		t.Errorf("expected only db, got %+v", root)
+	}
+}
+
+func TestCheckDirSelection(t *testing.T) {
+	dir := selectionRepo(t)
+	os.WriteFile(filepath.Join(dir, "infra", "broken.container"), []byte("[Container]\n"), 0644)
+
+	if errs := CheckDir(dir, Selector{Dirs: []string{"."}}); len(errs) != 0 {
+		t.Errorf("unselected broken spec reported: %v", errs)
+	}
+	if errs := CheckDir(dir, Selector{}); len(errs) != 1 {
+		t.Errorf("expected broken spec reported, got %v", errs)
+	}
+}
+
+func TestValidateQuadsyncSection(t *testing.T) {
+	errs := checkContent("web", "[Container]\nImage=x\n\n[X-Quadsync]\nLabel=Role=web\nColour=red\n", "web.container")
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "invalid label") || !strings.Contains(errs[1].Error(), "unknown key Colour") {
+		t.Errorf("got %v", errs)
+	}
+}
+
+func TestBuildDesiredStripsQuadsyncSection(t *testing.T) {
+	dir := selectionRepo(t)
+	desired, err := buildDesiredFull(dir, Transforms{DirContainer: map[string]*INIFile{"infra": {}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, state := range desired {
+		for file, content := range state.Files {
+			if strings.Contains(content, "X-Quadsync") {
+				t.Errorf("%s: %s still has [X-Quadsync]:\n%s", name, file, content)
+			}
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // defaultControlSocket is where the daemon listens and the frontend connects.
@@ -53,13 +54,20 @@ func serve(cfg Config, socketPath string) error {
 		return err
 	}
 
+	w := newWatcher(cfg)
+	if cfg.WatchInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go w.run(stop)
+	}
+
 	log.Printf("listening on %s (group %s)", socketPath, cfg.UserGroup)
 	for {
 		conn, err := l.Accept()
 		if err != nil {
 			return fmt.Errorf("accept: %w", err)
 		}
-		go handleConn(cfg, conn)
+		go handleConn(cfg, w, conn)
 	}
 }
 
@@ -85,7 +93,7 @@ func chownSocket(path, group string) error {
 }
 
 // handleConn reads NDJSON requests off a connection until EOF, responding to each.
-func handleConn(cfg Config, conn net.Conn) {
+func handleConn(cfg Config, w *watcher, conn net.Conn) {
 	defer conn.Close()
 	sc := bufio.NewScanner(conn)
 	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
@@ -99,7 +107,7 @@ func handleConn(cfg Config, conn net.Conn) {
 		if err := json.Unmarshal(line, &req); err != nil {
 			resp = Response{OK: false, Error: fmt.Sprintf("bad request: %v", err)}
 		} else {
-			resp = dispatch(cfg, req)
+			resp = dispatch(cfg, w, req)
 		}
 		out, _ := json.Marshal(resp)
 		out = append(out, '\n')
@@ -111,8 +119,9 @@ func handleConn(cfg Config, conn net.Conn) {
 
 // dispatch maps one request to a handler. Every name-scoped op first validates
 // the name against the managed-user set, so the frontend can never reach a
-// service outside quadsync's control.
-func dispatch(cfg Config, req Request) Response {
+// service outside quadsync's control. Syncs go through w so that they share
+// the daemon's schedule and status.
+func dispatch(cfg Config, w *watcher, req Request) Response {
 	switch req.Op {
 	case OpList:
 		return opList(cfg)
@@ -143,7 +152,7 @@ func dispatch(cfg Config, req Request) Response {
 		if err != nil {
 			return errResp(err)
 		}
-		return opRedeploy(cfg, name)
+		return opRedeploy(cfg, w, name)
 	case OpRepull:
 		name, err := requireManaged(cfg, req.Name)
 		if err != nil {
@@ -154,10 +163,34 @@ func dispatch(cfg Config, req Request) Response {
 		}
 		return Response{OK: true, Message: "repulled " + string(name)}
 	case OpSync:
-		if err := Sync(cfg); err != nil {
+		if err := w.syncNow(); err != nil {
 			return errResp(err)
 		}
 		return Response{OK: true, Message: "sync complete"}
+	case OpAllowRemovals:
+		ack := cfg
+		ack.AllowMassDelete = true
+		if err := w.syncNowWith(ack); err != nil {
+			return errResp(err)
+		}
+		return Response{OK: true, Message: "sync complete, removals acknowledged"}
+	case OpStatus:
+		status := w.snapshot()
+		return Response{OK: true, Watch: &status}
+	case OpPlan:
+		plan, err := PlanSync(cfg)
+		if err != nil {
+			return errResp(err)
+		}
+		return Response{OK: true, Plan: plan, Message: plan.String()}
+	case OpReports:
+		return opReports(cfg, req.Count)
+	case OpDrift:
+		drift, err := DetectDrift(cfg)
+		if err != nil {
+			return errResp(err)
+		}
+		return Response{OK: true, Drift: drift, Message: drift.String()}
 	default:
 		return Response{OK: false, Error: "unknown op: " + req.Op}
 	}
@@ -207,7 +240,7 @@ func opList(cfg Config) Response {
 func gatherInfo(cfg Config, name Username) ContainerInfo {
 	info := ContainerInfo{Name: string(name)}
 
-	if props, err := userSystemctlShow(name); err == nil {
+	if props, err := userSystemctlShow(name, string(name)+".service"); err == nil {
 		info.ActiveState = props["ActiveState"]
 		info.SubState = props["SubState"]
 		info.MainPID = props["MainPID"]
@@ -230,15 +263,20 @@ func gatherInfo(cfg Config, name Username) ContainerInfo {
 	if b, err := os.ReadFile(filepath.Join(cfg.StateDir, "hashes", string(name))); err == nil {
 		info.Hash = strings.TrimSpace(string(b))
 	}
+	hashDir := filepath.Join(cfg.StateDir, "hashes")
+	info.Source = readSourceLabel(hashDir, name)
+	if since, ok := readTombstone(hashDir, name); ok {
+		info.Tombstoned = since.UTC().Format(time.RFC3339)
+	}
 	return info
 }
 
 // userSystemctlShow reads unit properties via `systemctl --user show`, run as
 // the target user with XDG_RUNTIME_DIR set. We use runuser (not the -M
 // transport) because -M's stdout cannot be captured by Go (see system.go).
-func userSystemctlShow(name Username) (map[string]string, error) {
-	cmd := fmt.Sprintf("export XDG_RUNTIME_DIR=/run/user/$(id -u); systemctl --user show %s.service -p ActiveState -p SubState -p MainPID -p ActiveEnterTimestamp",
-		string(name))
+func userSystemctlShow(name Username, unit string) (map[string]string, error) {
+	cmd := fmt.Sprintf("export XDG_RUNTIME_DIR=/run/user/$(id -u); systemctl --user show %s -p ActiveState -p SubState -p MainPID -p ActiveEnterTimestamp",
+		shellQuote(unit))
 	out, err := runAsUser(shortTimeout, name, cmd)
 	if err != nil {
 		return nil, err
@@ -311,14 +349,27 @@ func opLogs(name Username, lines int) Response {
 	return Response{OK: true, Logs: out}
 }
 
+func opReports(cfg Config, count int) Response {
+	if count <= 0 {
+		count = 10
+	}
+	count = min(count, reportHistory)
+	reports, err := loadReports(cfg.StateDir, count)
+	if err != nil {
+		return errResp(err)
+	}
+	return Response{OK: true, Reports: reports}
+}
+
 // opRedeploy clears the stored deploy hash (so the next sync treats the spec as
 // new) and runs a sync immediately. Mirrors `quadsync redeploy` + `sync`.
-func opRedeploy(cfg Config, name Username) Response {
+func opRedeploy(cfg Config, w *watcher, name Username) Response {
 	hashFile := filepath.Join(cfg.StateDir, "hashes", string(name))
 	if err := os.Remove(hashFile); err != nil && !os.IsNotExist(err) {
 		return errResp(fmt.Errorf("removing hash: %w", err))
 	}
-	if err := Sync(cfg); err != nil {
+	os.Remove(badRevisionPath(filepath.Join(cfg.StateDir, "hashes"), name))
+	if err := w.syncNow(); err != nil {
 		return errResp(fmt.Errorf("sync after redeploy: %w", err))
 	}
 	return Response{OK: true, Message: "redeployed " + string(name)}
@@ -16,13 +16,14 @@ func startTestDaemon(t *testing.T, cfg Config) string {
 		t.Fatalf("listen: %v", err)
 	}
 	t.Cleanup(func() { l.Close() })
+	w := newWatcher(cfg)
 	go func() {
 		for {
 			conn, err := l.Accept()
 			if err != nil {
 				return
 			}
-			go handleConn(cfg, conn)
+			go handleConn(cfg, w, conn)
 		}
 	}()
 	return sock
@@ -67,3 +68,18 @@ func TestDaemonRejectsUnmanagedAndUnknownOp(t *testing.T) {
 		t.Errorf("expected rejection for unknown op, got %+v", resp)
 	}
 }
+
+func TestDaemonOpStatus(t *testing.T) {
+	sock := startTestDaemon(t, Config{UserGroup: "quadsync-nonexistent-group"})
+
+	resp, err := callSocket(sock, Request{Op: OpStatus})
+	if err != nil {
+		t.Fatalf("callSocket: %v", err)
+	}
+	if !resp.OK || resp.Watch == nil {
+		t.Fatalf("status not ok: %+v", resp)
+	}
+	if resp.Watch.Enabled || !resp.Watch.NextRun.IsZero() {
+		t.Errorf("expected watch mode off without an interval, got %+v", resp.Watch)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -17,6 +18,7 @@ const (
 	defaultTimeout = 60 * time.Second // useradd, userdel, loginctl, chown, git reset
 	gitNetTimeout  = 2 * time.Minute  // git clone, git fetch (network-bound)
 	systemdTimeout = 90 * time.Second // systemctl --user operations (container stop can be slow)
+	archiveTimeout = 30 * time.Minute // tar of a retired user's home
 )
 
 // run executes a command with a timeout and returns combined output.
@@ -34,18 +36,39 @@ func run(timeout time.Duration, name string, args ...string) (string, error) {
 	return string(out), nil
 }
 
+// gitSSHEnv returns the environment for a network git command, pointing
+// GIT_SSH_COMMAND at the source's deploy key when one is configured. Set per
+// command rather than process-wide so each source uses only its own key.
+func gitSSHEnv(sshKey string) []string {
+	env := os.Environ()
+	if sshKey != "" {
+		env = append(env, "GIT_SSH_COMMAND=ssh -i "+sshKey+" -o StrictHostKeyChecking=accept-new")
+	}
+	return env
+}
+
 // gitClone clones a repo.
-func gitClone(url, dest, branch string) error {
-	_, err := run(gitNetTimeout, "git", "clone", "--branch", branch, "--single-branch", "--depth=1", url, dest)
-	return err
+func gitClone(url, dest, branch, sshKey string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), gitNetTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "clone", "--branch", branch, "--single-branch", "--depth=1", url, dest)
+	cmd.Env = gitSSHEnv(sshKey)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("git clone timed out after %s", gitNetTimeout)
+		}
+		return fmt.Errorf("git clone: %w\n%s", err, out)
+	}
+	return nil
 }
 
 // gitFetch fetches and returns whether there are new changes.
-func gitFetch(repoDir, branch string) (bool, error) {
+func gitFetch(repoDir, branch, sshKey string) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), gitNetTimeout)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, "git", "fetch", "origin", branch)
 	cmd.Dir = repoDir
+	cmd.Env = gitSSHEnv(sshKey)
 	if out, err := cmd.CombinedOutput(); err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
 			return false, fmt.Errorf("git fetch timed out after %s", gitNetTimeout)
@@ -73,6 +96,88 @@ func gitFetch(repoDir, branch string) (bool, error) {
 	return strings.TrimSpace(string(headOut)) != strings.TrimSpace(string(fetchOut)), nil
 }
 
+// gitHead returns the commit checked out in repoDir, or "" if there is no
+// checkout yet.
+func gitHead(repoDir string) string {
+	return gitRevParse(repoDir, "HEAD")
+}
+
+// gitRevParse resolves rev to a commit hash, or "" if it cannot be resolved.
+func gitRevParse(repoDir, rev string) string {
+	out, err := run(shortTimeout, "git", "-C", repoDir, "rev-parse", rev)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+// gitToplevel returns the root of the git working tree dir is in, or "" if
+// it is not in one.
+func gitToplevel(dir string) string {
+	out, err := run(shortTimeout, "git", "-C", dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+// gitRemoteURL returns the URL of the origin remote of the repository at
+// repoDir, or "" if it has none.
+func gitRemoteURL(repoDir string) string {
+	out, err := run(shortTimeout, "git", "-C", repoDir, "remote", "get-url", "origin")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+// Commit signature verification modes (QUADSYNC_GIT_VERIFY).
+const (
+	gitVerifySSH = "ssh"
+	gitVerifyGPG = "gpg"
+)
+
+// gitVerifyCommit checks that rev carries a valid signature from one of the
+// keys in keysFile: an allowed_signers file for ssh mode, or an exported
+// public keyring for gpg mode. Only the configured signature format is
+// accepted; the other verifier is pointed at nothing so it always fails.
+func gitVerifyCommit(repoDir, rev, mode, keysFile string) error {
+	args := []string{"-C", repoDir}
+	var env []string
+	switch mode {
+	case gitVerifySSH:
+		args = append(args,
+			"-c", "gpg.ssh.allowedSignersFile="+keysFile,
+			"-c", "gpg.openpgp.program=false")
+	case gitVerifyGPG:
+		home, err := os.MkdirTemp("", "quadsync-gnupg-")
+		if err != nil {
+			return fmt.Errorf("creating gnupg home: %w", err)
+		}
+		defer os.RemoveAll(home)
+		if _, err := run(defaultTimeout, "gpg", "--batch", "--homedir", home, "--import", keysFile); err != nil {
+			return fmt.Errorf("importing keyring %s: %w", keysFile, err)
+		}
+		args = append(args, "-c", "gpg.ssh.allowedSignersFile=/dev/null")
+		env = append(env, "GNUPGHOME="+home)
+	default:
+		return fmt.Errorf("unknown signature verification mode %q", mode)
+	}
+	args = append(args, "verify-commit", rev)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shortTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("git verify-commit timed out after %s", shortTimeout)
+		}
+		return fmt.Errorf("git verify-commit %s: %w\n%s", rev, err, out)
+	}
+	return nil
+}
+
 // gitResetHard resets repo to origin/branch.
 func gitResetHard(repoDir, branch string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
@@ -164,6 +269,72 @@ func userdellTransient(output string) bool {
 		strings.Contains(output, "currently used by process")
 }
 
+// renameUser renames a managed user, its same-named primary group and its
+// subuid/subgid entries, keeping the uid, and moves its home directory to
+// the new name next to the old one, so that a later user reusing the old
+// name gets a home of its own. The user's systemd instance is torn down
+// first (usermod refuses to rename a user with running processes) and
+// linger re-enabled under the new name. It returns the new home directory.
+func renameUser(from, to Username) (string, error) {
+	u, err := lookupUser(string(from))
+	if err != nil {
+		return "", err
+	}
+	home := filepath.Join(filepath.Dir(u.HomeDir), string(to))
+	if _, err := run(defaultTimeout, "loginctl", "disable-linger", string(from)); err != nil {
+		log.Printf("warning: disable-linger %s: %v", from, err)
+	}
+	if _, err := run(systemdTimeout, "loginctl", "terminate-user", string(from)); err != nil {
+		log.Printf("warning: terminate-user %s: %v", from, err)
+	}
+	const maxAttempts = 4
+	for i := 0; ; i++ {
+		out, err := run(defaultTimeout, "usermod", "-l", string(to), "-d", home, "-m", string(from))
+		if err == nil {
+			break
+		}
+		if i == maxAttempts-1 || !userdellTransient(out) {
+			return "", err
+		}
+		log.Printf("usermod %s: transient error, retrying (%d/%d): %s",
+			from, i+1, maxAttempts-1, strings.TrimSpace(out))
+		time.Sleep(2 * time.Second)
+	}
+	if _, err := run(shortTimeout, "getent", "group", string(from)); err == nil {
+		if _, err := run(defaultTimeout, "groupmod", "-n", string(to), string(from)); err != nil {
+			return "", fmt.Errorf("renaming group %s: %w", from, err)
+		}
+	}
+	for _, path := range []string{"/etc/subuid", "/etc/subgid"} {
+		if err := renameSubIDs(path, from, to); err != nil {
+			return "", fmt.Errorf("renaming %s entry: %w", path, err)
+		}
+	}
+	if _, err := run(defaultTimeout, "loginctl", "enable-linger", string(to)); err != nil {
+		return "", fmt.Errorf("enabling linger for %s: %w", to, err)
+	}
+	return home, nil
+}
+
+// podmanDBFiles are podman's rootless state databases, relative to the home
+// directory: SQLite since podman 4.8, BoltDB before.
+var podmanDBFiles = []string{
+	".local/share/containers/storage/db.sql",
+	".local/share/containers/storage/libpod/bolt_state.db",
+}
+
+// resetPodmanDB removes the podman databases under home, for a home that has
+// moved: podman keeps using the storage paths a database records, so it
+// must start a new one to use the new path.
+func resetPodmanDB(home string) error {
+	for _, f := range podmanDBFiles {
+		if err := os.Remove(filepath.Join(home, f)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 // runAsUser executes a shell command as the given user via runuser.
 func runAsUser(timeout time.Duration, username Username, shellCmd string) (string, error) {
 	return run(timeout, "runuser", "-s", "/bin/sh", string(username), "-c", shellCmd)
@@ -217,6 +388,60 @@ func writeQuadletFile(username Username, filename, content string) error {
 	return nil
 }
 
+// readUserFile reads back a deployed managed file from the user's home,
+// choosing the directory by extension like writeQuadletFile. Runs as the
+// target user so a symlink in the home cannot expose files only root can
+// read. ok is false if the file does not exist.
+func readUserFile(username Username, filename string) (content string, ok bool, err error) {
+	dir := userFileDir(filename)
+	shellCmd := fmt.Sprintf("f=~/%s/%s; [ -f \"$f\" ] || exit 3; cat -- \"$f\"", dir, shellQuote(filename))
+	out, err := runAsUser(defaultTimeout, username, shellCmd)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 3 {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("reading %s for %s: %w", filename, username, err)
+	}
+	return out, true, nil
+}
+
+// readUserFiles reads every regular file directly inside the user's quadlet
+// and user-unit directories, keyed by home-relative path (e.g.
+// ".config/containers/systemd/web.container"). Runs as the target user, like
+// readUserFile.
+func readUserFiles(username Username) (map[string]string, error) {
+	// Each file is emitted as <path>\0<content>\0.
+	shellCmd := fmt.Sprintf(
+		"cd ~ || exit 0; for d in %s %s; do for f in \"$d\"/*; do [ -f \"$f\" ] || continue; printf '%%s\\0' \"$f\"; cat -- \"$f\" 2>/dev/null; printf '\\0'; done; done",
+		quadletDir, userUnitDir,
+	)
+	out, err := runAsUser(defaultTimeout, username, shellCmd)
+	if err != nil {
+		return nil, fmt.Errorf("reading managed files for %s: %w", username, err)
+	}
+	files := map[string]string{}
+	parts := strings.Split(out, "\x00")
+	for i := 0; i+1 < len(parts); i += 2 {
+		files[parts[i]] = parts[i+1]
+	}
+	return files, nil
+}
+
+// removeUserFiles deletes the given home-relative paths from the user's
+// home. Runs as the target user.
+func removeUserFiles(username Username, paths []string) error {
+	quoted := make([]string, len(paths))
+	for i, p := range paths {
+		quoted[i] = "~/" + shellQuote(p)
+	}
+	shellCmd := "rm -f -- " + strings.Join(quoted, " ")
+	if _, err := runAsUser(defaultTimeout, username, shellCmd); err != nil {
+		return fmt.Errorf("removing files for %s: %w", username, err)
+	}
+	return nil
+}
+
 // removeAllQuadlets removes all managed files from the user's home (both the
 // quadlet dir and the systemd user-unit dir). Runs as the target user to
 // prevent symlink attacks.
@@ -235,7 +460,13 @@ func removeAllQuadlets(username Username) error {
 // Output goes to the journal rather than being captured, because the machinectl
 // transport (-M) fails when Go pipes stdout/stderr via CombinedOutput().
 func runUserM(username Username, args ...string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), systemdTimeout)
+	return runUserMTimeout(systemdTimeout, username, args...)
+}
+
+// runUserMTimeout is runUserM with a caller-chosen timeout, for operations
+// such as deploy hooks that can legitimately outlast systemdTimeout.
+func runUserMTimeout(timeout time.Duration, username Username, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	cmdArgs := append([]string{"--user", "-M", string(username) + "@"}, args...)
 	cmd := exec.CommandContext(ctx, "systemctl", cmdArgs...)
@@ -244,7 +475,7 @@ func runUserM(username Username, args ...string) error {
 	if err := cmd.Run(); err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
 			return fmt.Errorf("systemctl --user -M %s@ %s: timed out after %s",
-				username, strings.Join(args, " "), systemdTimeout)
+				username, strings.Join(args, " "), timeout)
 		}
 		return fmt.Errorf("systemctl --user -M %s@ %s: %w",
 			username, strings.Join(args, " "), err)
@@ -267,6 +498,12 @@ func stopService(username Username, serviceName string) error {
 	return runUserM(username, "stop", serviceName+".service")
 }
 
+// startUnit starts a user unit and, for a Type=oneshot service, waits for it
+// to finish: systemctl reports failure if it exits non-zero.
+func startUnit(username Username, unit string, timeout time.Duration) error {
+	return runUserMTimeout(timeout, username, "start", unit)
+}
+
 // enableTimer enables and starts a user timer. timerFile is the timer's
 // basename (e.g. "library-refresh.timer"). Idempotent.
 func enableTimer(username Username, timerFile string) error {
@@ -334,6 +571,15 @@ func shellQuote(s string) string {
 	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
+// removePodmanSecrets removes all of a user's podman secrets. Each records
+// the absolute path of its data, which a home directory move would break.
+func removePodmanSecrets(username Username) error {
+	if _, err := runAsUser(defaultTimeout, username, "cd ~ 2>/dev/null; podman secret rm --all"); err != nil {
+		return fmt.Errorf("removing secrets for %s: %w", username, err)
+	}
+	return nil
+}
+
 // createPodmanSecrets creates or replaces podman secrets for a user.
 func createPodmanSecrets(username Username, secrets []ContainerSecret) error {
 	for _, s := range secrets {
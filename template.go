@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+)
+
+// templateVarRe matches a template placeholder: one of templateNames, such
+// as {{.Name}}, {{.Vars.KEY}} for a variable from vars.env, or
+// {{.Host.CPUs}} for a host fact. Anything else in braces, such as podman's
+// {{.State.Status}} in a HealthCmd= or --format string, is not a
+// placeholder and is left alone.
+var templateVarRe = regexp.MustCompile(`\{\{\s*\.((?:` + strings.Join(templateNames, "|") +
+	`)|(?:Vars|Host)\.[A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// templateNames are the placeholders outside the Vars. and Host. namespaces.
+var templateNames = []string{"Name", "Pod", "Dir", "Hostname", "Commit", "UID", "GID", "Home"}
+
+// templateEscape is written where a literal "{{" is wanted, e.g. for a
+// podman --format string, as in Go templates.
+const templateEscape = `{{"{{"}}`
+
+// userTemplateVars are only known once the container's user exists, so
+// they are left in the desired state and filled in when its files are
+// written (see resolveStateUserVars).
+var userTemplateVars = []string{"UID", "GID", "Home"}
+
+// varsFile holds host-level template variables in the transform directory.
+const varsFile = "vars.env"
+
+var validVarNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// hostName is os.Hostname, a variable so tests can pin it.
+var hostName = os.Hostname
+
+// hostFactPrefix starts the placeholders of host facts (see hostFacts).
+const hostFactPrefix = "Host."
+
+// templateContext maps the placeholders of specs, transforms and companion
+// templates to their values: "Name", "Pod", "Dir", "Hostname", "Commit",
+// "Vars.KEY" for each variable of vars.env and "Host.FACT" for each host
+// fact.
+type templateContext map[string]string
+
+// newTemplateContext is the context shared by every unit built from one
+// checkout: the host's name, the commit being deployed, vars and facts.
+func newTemplateContext(vars map[string]string, commit string, facts map[string]string) templateContext {
+	c := templateContext{"Commit": commit}
+	if h, err := hostName(); err == nil {
+		c["Hostname"] = h
+	}
+	for k, v := range vars {
+		c["Vars."+k] = v
+	}
+	for k, v := range facts {
+		c[hostFactPrefix+k] = v
+	}
+	return c
+}
+
+// with returns a copy of c with the given name, value pairs set.
+func (c templateContext) with(kv ...string) templateContext {
+	out := templateContext{}
+	for k, v := range c {
+		out[k] = v
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		out[kv[i]] = kv[i+1]
+	}
+	return out
+}
+
+// unresolvedVar is a placeholder in a desired file that has no value.
+type unresolvedVar struct {
+	File string // file name in the desired state
+	Var  string // placeholder as written, e.g. "{{.Vars.DOMAIN}}"
+}
+
+// templateUse collects what expanding the files of one desired state
+// depended on.
+type templateUse struct {
+	Unresolved    []unresolvedVar
+	MissingFacts  []unresolvedVar // placeholders of host facts the host has no value for
+	UserTemplated []string        // files left with userTemplateVars to fill in
+}
+
+// expand replaces the placeholders in content, the file filename, with
+// their values and each templateEscape with "{{". Placeholders without a
+// value are left as written and added to use.Unresolved, or, for a host fact
+// the host could not determine, to use.MissingFacts.
+//
+// userTemplateVars without a value are left for a second expand once the
+// user exists (see resolveStateUserVars): the file is added to
+// use.UserTemplated and stays escaped, every literal "{{" in it, including
+// those of the values filled in now, written as templateEscape, so that
+// the second pass fills in only the placeholders of the template itself.
+func (c templateContext) expand(filename, content string, use *templateUse) string {
+	parts := strings.Split(content, templateEscape)
+	deferred := false
+	for _, part := range parts {
+		for _, m := range templateVarRe.FindAllStringSubmatch(part, -1) {
+			if _, ok := c[m[1]]; !ok && isUserTemplateVar(m[1]) {
+				deferred = true
+			}
+		}
+	}
+	for i, part := range parts {
+		parts[i] = templateVarRe.ReplaceAllStringFunc(part, func(m string) string {
+			name := templateVarRe.FindStringSubmatch(m)[1]
+			if v, ok := c[name]; ok {
+				if deferred {
+					v = strings.ReplaceAll(v, "{{", templateEscape)
+				}
+				return v
+			}
+			if fact, isFact := strings.CutPrefix(name, hostFactPrefix); isFact && isHostFact(fact) {
+				use.MissingFacts = append(use.MissingFacts, unresolvedVar{File: filename, Var: m})
+			} else if !isUserTemplateVar(name) {
+				use.Unresolved = append(use.Unresolved, unresolvedVar{File: filename, Var: m})
+			}
+			return m
+		})
+	}
+	if deferred {
+		use.UserTemplated = append(use.UserTemplated, filename)
+		return strings.Join(parts, templateEscape)
+	}
+	return strings.Join(parts, "{{")
+}
+
+func isUserTemplateVar(name string) bool {
+	for _, v := range userTemplateVars {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupUser is user.Lookup, a variable so tests can stand in for the
+// passwd database.
+var lookupUser = user.Lookup
+
+// resolveStateUserVars returns state with the {{.UID}}, {{.GID}} and
+// {{.Home}} placeholders of its UserTemplated files filled in for the user
+// name, which must exist by then. Other files are returned as they are.
+func resolveStateUserVars(name Username, state DesiredState) (DesiredState, error) {
+	if len(state.UserTemplated) == 0 {
+		return state, nil
+	}
+	u, err := lookupUser(string(name))
+	if err != nil {
+		return DesiredState{}, fmt.Errorf("looking up user %s: %w", name, err)
+	}
+	ctx := templateContext{"UID": u.Uid, "GID": u.Gid, "Home": u.HomeDir}
+	files := make(map[string]string, len(state.Files))
+	for filename, content := range state.Files {
+		files[filename] = content
+	}
+	for _, filename := range state.UserTemplated {
+		files[filename] = ctx.expand(filename, files[filename], &templateUse{})
+	}
+	state.Files = files
+	state.UserTemplated = nil
+	return state, nil
+}
+
+// loadVars reads the host-level template variables of a vars.env file.
+func loadVars(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", varsFile, err)
+	}
+	vars := parseEnvFile(string(data))
+	for k := range vars {
+		if !validVarNameRe.MatchString(k) {
+			return nil, fmt.Errorf("%s: invalid variable name %q", varsFile, k)
+		}
+	}
+	return vars, nil
+}
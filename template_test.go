@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTemplateExpand(t *testing.T) {
+	ctx := templateContext{"Name": "web", "Vars.DOMAIN": "example.com"}
+	var use templateUse
+	got := ctx.expand("web.container", `[Container]
+Label=app={{.Name}} host={{ .Vars.DOMAIN }}
+Environment=X={{.Vars.MISSING}} Y={{.Host.Nope}}
+HealthCmd=podman inspect --format {{"{{"}}.ID}} {{.State.Health.Status}} {{.Config.User}} {{.Status}}
+`, &use)
+
+	want := `[Container]
+Label=app=web host=example.com
+Environment=X={{.Vars.MISSING}} Y={{.Host.Nope}}
+HealthCmd=podman inspect --format {{.ID}} {{.State.Health.Status}} {{.Config.User}} {{.Status}}
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	wantUnresolved := []unresolvedVar{
+		{File: "web.container", Var: "{{.Vars.MISSING}}"},
+		{File: "web.container", Var: "{{.Host.Nope}}"},
+	}
+	if !reflect.DeepEqual(use.Unresolved, wantUnresolved) {
+		t.Errorf("unresolved = %v, want %v", use.Unresolved, wantUnresolved)
+	}
+}
+
+func TestResolveUserVars(t *testing.T) {
+	orig := lookupUser
+	t.Cleanup(func() { lookupUser = orig })
+	lookups := 0
+	lookupUser = func(name string) (*user.User, error) {
+		lookups++
+		if name != "web" {
+			return nil, errors.New("unknown user")
+		}
+		return &user.User{Uid: "1001", Gid: "1002", HomeDir: "/home/web"}, nil
+	}
+
+	ctx := templateContext{"Name": "web", "Vars.FORMAT": "{{.UID}}"}
+	var use templateUse
+	container := ctx.expand("web.container", `[Container]
+User={{.UID}}:{{.GID}}
+Volume={{.Home}}/data:/data
+Label={{.Other}}
+HealthCmd=podman inspect --format {{"{{"}}.UID}}
+Environment=FORMAT={{.Vars.FORMAT}}
+`, &use)
+	if !reflect.DeepEqual(use.UserTemplated, []string{"web.container"}) {
+		t.Fatalf("UserTemplated = %v", use.UserTemplated)
+	}
+	service := "[Service]\nExecStart=/bin/echo {{.UID}}\n"
+	state := DesiredState{
+		Files:         map[string]string{"web.container": container, "web-job.service": service},
+		UserTemplated: use.UserTemplated,
+	}
+
+	resolved, err := resolveStateUserVars("web", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Escaped braces and those of a filled-in value stay literal.
+	want := `[Container]
+User=1001:1002
+Volume=/home/web/data:/data
+Label={{.Other}}
+HealthCmd=podman inspect --format {{.UID}}
+Environment=FORMAT={{.UID}}
+`
+	if got := resolved.Files["web.container"]; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := resolved.Files["web-job.service"]; got != service {
+		t.Errorf("verbatim sidecar changed: %q", got)
+	}
+	if lookups != 1 {
+		t.Errorf("looked up the user %d times, want 1", lookups)
+	}
+
+	plain := DesiredState{Files: map[string]string{"ghost.container": "Image=x\n"}}
+	if got, err := resolveStateUserVars("ghost", plain); err != nil || got.Files["ghost.container"] != "Image=x\n" {
+		t.Errorf("state without user placeholders: got %v, %v", got.Files, err)
+	}
+	if _, err := resolveStateUserVars("ghost", state); err == nil {
+		t.Error("expected an error for a user that does not exist")
+	}
+}
+
+func TestBuildDesiredTemplateVars(t *testing.T) {
+	origHost := hostName
+	t.Cleanup(func() { hostName = origHost })
+	hostName = func() (string, error) { return "node1", nil }
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "site.container"),
+		[]byte("[Container]\nImage=nginx\nEnvironment=HOST={{.Hostname}} DOMAIN={{.Vars.DOMAIN}} DIR={{.Dir}}\n"), 0644)
+	sub := filepath.Join(repo, "apps")
+	os.Mkdir(sub, 0755)
+	os.WriteFile(filepath.Join(sub, "shop.pod"), []byte("[Pod]\nPodName={{.Name}}\n"), 0644)
+	os.WriteFile(filepath.Join(sub, "shop-web.container"),
+		[]byte("[Container]\nImage=shop\nEnvironment=POD={{.Pod}} DIR={{.Dir}} NAME={{.Name}}\n"), 0644)
+	os.WriteFile(filepath.Join(sub, "shop-web-data.volume"), []byte("[Volume]\nLabel=owner={{.Name}}\n"), 0644)
+
+	tdir := t.TempDir()
+	os.WriteFile(filepath.Join(tdir, varsFile), []byte("DOMAIN=example.com\n"), 0644)
+	os.WriteFile(filepath.Join(tdir, "_base-cache.volume"), []byte("[Volume]\nLabel=pod={{.Pod}} unknown={{.Vars.BOGUS}}\n"), 0644)
+	tr, err := loadAllTransforms(tdir)
+	if err != nil {
+		t.Fatalf("loadAllTransforms: %v", err)
+	}
+	desired, err := buildDesiredFull(repo, tr)
+	if err != nil {
+		t.Fatalf("buildDesiredFull: %v", err)
+	}
+
+	site := desired["site"]
+	if want := "Environment=HOST=node1 DOMAIN=example.com DIR=.\n"; !strings.Contains(site.Files["site.container"], want) {
+		t.Errorf("site.container missing %q:\n%s", want, site.Files["site.container"])
+	}
+	// {{.Pod}} is not defined outside a pod.
+	if !reflect.DeepEqual(site.Unresolved, []unresolvedVar{
+		{File: "site-cache.volume", Var: "{{.Pod}}"},
+		{File: "site-cache.volume", Var: "{{.Vars.BOGUS}}"},
+	}) {
+		t.Errorf("site unresolved = %v", site.Unresolved)
+	}
+
+	shop := desired["shop"]
+	for file, want := range map[string]string{
+		"shop.pod":              "PodName=shop\n",
+		"shop-web.container":    "Environment=POD=shop DIR=apps NAME=shop-web\n",
+		"shop-web-data.volume":  "Label=owner=shop-web\n",
+		"shop-web-cache.volume": "Label=pod=shop unknown={{.Vars.BOGUS}}\n",
+	} {
+		if !strings.Contains(shop.Files[file], want) {
+			t.Errorf("%s missing %q:\n%s", file, want, shop.Files[file])
+		}
+	}
+
+	var msgs []string
+	for _, e := range CheckDesired(map[Username]DesiredState{"shop": shop}) {
+		msgs = append(msgs, e.Error())
+	}
+	if want := "merged output for shop-web-cache.volume: unknown template variable {{.Vars.BOGUS}}"; !strings.Contains(strings.Join(msgs, "\n"), want) {
+		t.Errorf("expected %q in CheckDesired errors, got %v", want, msgs)
+	}
+}
+
+func TestLoadVarsInvalidName(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, varsFile), []byte("GOOD=1\nBAD-NAME=2\n"), 0644)
+	if _, err := loadAllTransforms(dir); err == nil || !strings.Contains(err.Error(), `invalid variable name "BAD-NAME"`) {
+		t.Fatalf("expected invalid name error, got %v", err)
+	}
+}
+
+func TestCheckTemplatedValues(t *testing.T) {
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "web.container"), []byte("[Container]\nImage=nginx\nPublishPort={{.Vars.PORT}}:80\n"), 0644)
+	if errs := CheckDir(repo, Selector{}); len(errs) != 0 {
+		t.Fatalf("templated spec rejected before expansion: %v", errs)
+	}
+
+	// The filled-in value is checked in the merged output.
+	for port, wantErr := range map[string]bool{"8080": false, "http": true} {
+		tdir := t.TempDir()
+		os.WriteFile(filepath.Join(tdir, varsFile), []byte("PORT="+port+"\n"), 0644)
+		tr, err := loadAllTransforms(tdir)
+		if err != nil {
+			t.Fatalf("loadAllTransforms: %v", err)
+		}
+		desired, err := buildDesiredFull(repo, tr)
+		if err != nil {
+			t.Fatalf("buildDesiredFull: %v", err)
+		}
+		errs := CheckDesired(desired)
+		if wantErr != (len(errs) > 0) {
+			t.Errorf("PORT=%s: errors = %v", port, errs)
+		}
+		if wantErr && len(errs) > 0 && !strings.Contains(errs[0].Error(), `"http" is not a port number`) {
+			t.Errorf("PORT=%s: error = %v", port, errs[0])
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Retention policies for users whose container left the desired state.
+const (
+	RetainDelete  = "delete"  // delete the user and its home right away
+	RetainKeep    = "keep"    // stop the container, keep the home until purged
+	RetainArchive = "archive" // like keep, plus a tarball of the home that outlives the purge
+)
+
+// defaultRetentionGrace is how long a tombstoned user is kept by default.
+const defaultRetentionGrace = 7 * 24 * time.Hour
+
+// Retention decides what a sync does with a user whose container is no
+// longer in the desired state.
+type Retention struct {
+	Policy string        // RetainDelete, RetainKeep or RetainArchive
+	Grace  time.Duration // how long a tombstone is kept before a sync purges it; 0 waits for `quadsync purge`
+}
+
+// Removal actions for a user that is no longer desired.
+const (
+	removalWait      = ""          // tombstoned and within the grace period
+	removalDelete    = "delete"    // delete outright (RetainDelete)
+	removalTombstone = "tombstone" // stop and mark as tombstoned
+	removalPurge     = "purge"     // tombstone expired, delete
+)
+
+// removalAction decides what to do with an undesired user at time now.
+func removalAction(r Retention, hashDir string, name Username, now time.Time) string {
+	since, tombstoned := readTombstone(hashDir, name)
+	switch {
+	case !tombstoned && r.Policy == RetainDelete:
+		return removalDelete
+	case !tombstoned:
+		return removalTombstone
+	case r.Grace > 0 && now.Sub(since) >= r.Grace:
+		return removalPurge
+	default:
+		return removalWait
+	}
+}
+
+func tombstonePath(hashDir string, name Username) string {
+	return filepath.Join(hashDir, string(name)+".tombstone")
+}
+
+// readTombstone returns when name was tombstoned, if it is.
+func readTombstone(hashDir string, name Username) (time.Time, bool) {
+	b, err := os.ReadFile(tombstonePath(hashDir, name))
+	if err != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(b)))
+	if err != nil {
+		// A damaged tombstone still marks the user as removed; date it now
+		// so the grace period starts over rather than purging early.
+		return time.Now(), true
+	}
+	return t, true
+}
+
+// tombstoneContainer retires a user that left the desired state without
+// deleting it: timers and the service are stopped, managed files removed so
+// nothing restarts them, and the home (volumes included) is left in place
+// until a purge. With RetainArchive the home is also archived under the
+// state dir first. If the container comes back, the next deploy picks the
+// existing user and its data up again.
+func tombstoneContainer(config Config, hashDir string, name Username, cr *ContainerReport) error {
+	log.Printf("%s: removed from the repo, stopping and keeping its data (retention %s)", name, config.Retention.Policy)
+	stopContainer(name, cr)
+	if err := removeAllQuadlets(name); err != nil {
+		log.Printf("warning: removing quadlets for %s: %v", name, err)
+		cr.warn("removing quadlets: %v", err)
+	}
+	if err := daemonReload(name); err != nil {
+		log.Printf("warning: daemon-reload for %s: %v", name, err)
+	}
+	if config.Retention.Policy == RetainArchive {
+		archive, err := archiveHome(config, name, time.Now())
+		if err != nil {
+			return fmt.Errorf("archiving home of %s: %w", name, err)
+		}
+		log.Printf("%s: home archived to %s", name, archive)
+	}
+	if err := os.WriteFile(tombstonePath(hashDir, name), []byte(time.Now().UTC().Format(time.RFC3339)), 0644); err != nil {
+		return fmt.Errorf("writing tombstone for %s: %w", name, err)
+	}
+	// Without a hash, a returning container is redeployed in full.
+	os.Remove(filepath.Join(hashDir, string(name)))
+	clearRevisionState(hashDir, name)
+	return nil
+}
+
+// clearTombstone un-tombstones name, returning whether it was tombstoned.
+func clearTombstone(hashDir string, name Username) bool {
+	return os.Remove(tombstonePath(hashDir, name)) == nil
+}
+
+// archiveHome writes a tarball of the user's home to StateDir/archive,
+// keeping numeric owners so rootless podman storage can be restored.
+func archiveHome(config Config, name Username, now time.Time) (string, error) {
+	u, err := user.Lookup(string(name))
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(config.StateDir, "archive")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, fmt.Sprintf("%s-%s.tar.gz", name, now.UTC().Format("20060102T150405Z")))
+	if _, err := run(archiveTimeout, "tar", "--numeric-owner", "-czf", dest,
+		"-C", filepath.Dir(u.HomeDir), filepath.Base(u.HomeDir)); err != nil {
+		os.Remove(dest)
+		return "", err
+	}
+	return dest, nil
+}
+
+// Purge deletes a tombstoned user and its home right away, without waiting
+// for the grace period. Users that are not tombstoned are refused, so a
+// typo cannot delete a running container.
+func Purge(config Config, name Username) error {
+	lockFile, err := lockSync(config)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+
+	hashDir := filepath.Join(config.StateDir, "hashes")
+	if _, ok := readTombstone(hashDir, name); !ok {
+		return fmt.Errorf("%s is not tombstoned (only users removed from the repo can be purged)", name)
+	}
+	cr := ContainerReport{Name: string(name)}
+	return removeContainer(hashDir, name, &cr)
+}
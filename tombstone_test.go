@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemovalAction(t *testing.T) {
+	hashDir := t.TempDir()
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	os.WriteFile(tombstonePath(hashDir, "old"), []byte(now.Add(-8*24*time.Hour).Format(time.RFC3339)), 0644)
+	os.WriteFile(tombstonePath(hashDir, "recent"), []byte(now.Add(-time.Hour).Format(time.RFC3339)), 0644)
+
+	week := 7 * 24 * time.Hour
+	cases := []struct {
+		retention Retention
+		name      Username
+		want      string
+	}{
+		{Retention{Policy: RetainDelete}, "live", removalDelete},
+		{Retention{Policy: RetainKeep, Grace: week}, "live", removalTombstone},
+		{Retention{Policy: RetainArchive, Grace: week}, "live", removalTombstone},
+		{Retention{Policy: RetainKeep, Grace: week}, "recent", removalWait},
+		{Retention{Policy: RetainKeep, Grace: week}, "old", removalPurge},
+		{Retention{Policy: RetainKeep}, "old", removalWait}, // no grace: only an explicit purge
+		{Retention{Policy: RetainDelete, Grace: week}, "recent", removalWait},
+	}
+	for _, tc := range cases {
+		if got := removalAction(tc.retention, hashDir, tc.name, now); got != tc.want {
+			t.Errorf("%+v %s: got %q, want %q", tc.retention, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLoadConfigRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.env")
+	base := "QUADSYNC_GIT_URL=https://example.com/repo.git\n"
+
+	os.WriteFile(path, []byte(base), 0644)
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Retention != (Retention{Policy: RetainDelete, Grace: defaultRetentionGrace}) {
+		t.Errorf("default retention = %+v", c.Retention)
+	}
+
+	os.WriteFile(path, []byte(base+"QUADSYNC_RETENTION=archive\nQUADSYNC_RETENTION_GRACE=0\n"), 0644)
+	if c, err = LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if c.Retention != (Retention{Policy: RetainArchive}) {
+		t.Errorf("retention = %+v", c.Retention)
+	}
+
+	os.WriteFile(path, []byte(base+"QUADSYNC_RETENTION=shred\n"), 0644)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "QUADSYNC_RETENTION") {
+		t.Errorf("expected invalid retention error, got %v", err)
+	}
+}
+
+func TestBuildPlanRetention(t *testing.T) {
+	hashDir := t.TempDir()
+	os.WriteFile(tombstonePath(hashDir, "kept"), []byte(time.Now().Format(time.RFC3339)), 0644)
+	os.WriteFile(tombstonePath(hashDir, "expired"), []byte(time.Now().Add(-48*time.Hour).Format(time.RFC3339)), 0644)
+
+	plan, err := buildPlan(hashDir, Config{Retention: Retention{Policy: RetainKeep, Grace: 24 * time.Hour}}, nil, []Username{"gone", "kept", "expired"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plan.Retire, []string{"gone"}) || !reflect.DeepEqual(plan.Remove, []string{"expired"}) {
+		t.Errorf("Retire = %v, Remove = %v", plan.Retire, plan.Remove)
+	}
+	if !strings.Contains(plan.String(), "Users to stop and tombstone (data kept):\n  - gone\n") {
+		t.Errorf("rendered plan:\n%s", plan.String())
+	}
+}
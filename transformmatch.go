@@ -0,0 +1,191 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// sectionTransformMatch holds the conditions of a matched transform. It is
+// removed when the transform is loaded, so it never reaches a merged spec.
+const sectionTransformMatch = "X-Quadsync-Match"
+
+// TransformMatch is the condition a matched transform applies under. As in
+// a Selector, every populated criterion must match: the unit must be in one
+// of Dirs, match one of Names and one of Images, and satisfy every Labels
+// expression.
+type TransformMatch struct {
+	Selector
+	Images []string // globs against the spec's own [Container] Image=; "*" also matches "/"
+}
+
+// MatchedTransform is a transform file with an [X-Quadsync-Match] section:
+// it applies to the specs of its unit type that satisfy the match, wherever
+// they live in the repo, rather than to a directory.
+type MatchedTransform struct {
+	File  string // file name in the transform directory, e.g. "postgres.container"
+	Ext   string // unit type it applies to, e.g. ".container"
+	Match TransformMatch
+	INI   *INIFile // the transform, without its match section
+}
+
+// parseTransformMatch reads the conditions of an [X-Quadsync-Match]
+// section. Each key takes a space-separated list and may be repeated.
+func parseTransformMatch(sec *Section, file string) (TransformMatch, error) {
+	var m TransformMatch
+	for _, e := range sec.Entries {
+		values := strings.Fields(e.Value)
+		switch e.Key {
+		case "":
+			continue
+		case "Dir":
+			m.Dirs = append(m.Dirs, values...)
+		case "Name":
+			m.Names = append(m.Names, values...)
+		case "Image":
+			m.Images = append(m.Images, values...)
+		case "Label":
+			m.Labels = append(m.Labels, values...)
+		default:
+			return TransformMatch{}, entryError(e, "[%s] unknown key %s", sectionTransformMatch, e.Key)
+		}
+		for _, v := range values {
+			switch e.Key {
+			case "Name":
+				if _, err := path.Match(v, ""); err != nil {
+					return TransformMatch{}, entryError(e, "[%s] invalid %s= glob %q", sectionTransformMatch, e.Key, v)
+				}
+			case "Label":
+				if key, _, _ := splitLabelExpr(v); !validLabelKeyRe.MatchString(key) {
+					return TransformMatch{}, entryError(e, "[%s] invalid label expression %q", sectionTransformMatch, v)
+				}
+			}
+		}
+	}
+	if m.Empty() && len(m.Images) == 0 {
+		return TransformMatch{}, sectionError(file, sec, "[%s] has no conditions", sectionTransformMatch)
+	}
+	return m, nil
+}
+
+// matches reports whether the unit name, defined by spec in directory dir
+// ("." for the repo root), satisfies the match.
+func (m TransformMatch) matches(name, dir string, spec *INIFile) bool {
+	if !m.matchDir(dir) || !matchGlobs(m.Names, name) {
+		return false
+	}
+	if len(m.Images) > 0 {
+		sec := spec.GetSection("Container")
+		if sec == nil || !sec.HasKey("Image") || !matchImage(m.Images, lastValue(sec, "Image")) {
+			return false
+		}
+	}
+	return matchLabels(m.Labels, parseDirectives(spec).Labels)
+}
+
+// matchImage reports whether image matches one of globs, in which "*"
+// matches any run of characters, slashes included, so that "*/postgres:*"
+// matches every registry's postgres image, and "?" any single character.
+func matchImage(globs []string, image string) bool {
+	for _, g := range globs {
+		var re strings.Builder
+		re.WriteString("^")
+		for _, r := range g {
+			switch r {
+			case '*':
+				re.WriteString(".*")
+			case '?':
+				re.WriteString(".")
+			default:
+				re.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		re.WriteString("$")
+		if regexp.MustCompile(re.String()).MatchString(image) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastValue returns the value of the last entry for key in sec, the one
+// that takes effect for a single-valued key.
+func lastValue(sec *Section, key string) string {
+	var v string
+	for _, e := range sec.Entries {
+		if e.Key == key {
+			v = e.Value
+		}
+	}
+	return v
+}
+
+// transformsFor returns the transforms that apply to the unit name of type
+// ext defined by spec in directory dir ("." for the repo root), in the order
+// they are merged, along with their file names: the base transform for the
+// type, the directory transform, and then every matched transform whose
+// conditions the spec satisfies, by file name.
+func transformsFor(t Transforms, ext, name, dir string, spec *INIFile) ([]*INIFile, []string) {
+	var list []*INIFile
+	var files []string
+	add := func(f *INIFile, file string) {
+		if f != nil {
+			list = append(list, f)
+			files = append(files, file)
+		}
+	}
+	switch ext {
+	case ".container":
+		add(t.Base, "_base.container")
+		if dir != selectRootDir {
+			add(t.DirContainer[dir], dir+ext)
+		}
+	case ".pod":
+		add(t.BasePod, "_base.pod")
+		if dir != selectRootDir {
+			add(t.DirPod[dir], dir+ext)
+		}
+	default:
+		if dir != selectRootDir {
+			add(t.DirQuadlet[dir+ext], dir+ext)
+		}
+	}
+	for _, mt := range t.Matched {
+		if mt.Ext == ext && mt.Match.matches(name, dir, spec) {
+			add(mt.INI, mt.File)
+		}
+	}
+	return list, files
+}
+
+// scopeDir names the directory of a scope for transformsFor: nil, the repo
+// root, is ".".
+func scopeDir(dirName *string) string {
+	if dirName == nil {
+		return selectRootDir
+	}
+	return *dirName
+}
+
+// unitStem is a file's name without its directory and extension.
+func unitStem(file string) string {
+	return strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+}
+
+// appendNew appends to list the names it does not already hold.
+func appendNew(list []string, names ...string) []string {
+	for _, n := range names {
+		seen := false
+		for _, have := range list {
+			if have == n {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			list = append(list, n)
+		}
+	}
+	return list
+}
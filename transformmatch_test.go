@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadAllTransformsMatched(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "web.container"), []byte("[Container]\nNetwork=web\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "postgres.container"), []byte(`[X-Quadsync-Match]
+Image=*/postgres:* docker.io/library/postgres
+Label=tier=db
+
+[Container]
+ShmSize=256m
+`), 0644)
+
+	tr, err := loadAllTransforms(dir)
+	if err != nil {
+		t.Fatalf("loadAllTransforms: %v", err)
+	}
+	if _, ok := tr.DirContainer["postgres"]; ok {
+		t.Error("matched transform must not be a directory transform")
+	}
+	if _, ok := tr.DirContainer["web"]; !ok {
+		t.Error("missing directory transform web")
+	}
+	if len(tr.Matched) != 1 {
+		t.Fatalf("expected 1 matched transform, got %d", len(tr.Matched))
+	}
+	mt := tr.Matched[0]
+	if mt.File != "postgres.container" || mt.Ext != ".container" {
+		t.Errorf("unexpected matched transform %s (%s)", mt.File, mt.Ext)
+	}
+	if !reflect.DeepEqual(mt.Match.Images, []string{"*/postgres:*", "docker.io/library/postgres"}) {
+		t.Errorf("Images = %v", mt.Match.Images)
+	}
+	if mt.INI.GetSection(sectionTransformMatch) != nil {
+		t.Error("match section should be removed from the transform")
+	}
+}
+
+func TestLoadAllTransformsMatchedErrors(t *testing.T) {
+	cases := []struct {
+		name, file, content, want string
+	}{
+		{"unknown key", "x.container", "[X-Quadsync-Match]\nColour=blue\n", "x.container:2: [X-Quadsync-Match] unknown key Colour"},
+		{"bad glob", "x.container", "[X-Quadsync-Match]\nName=[web\n", `invalid Name= glob "[web"`},
+		{"bad label", "x.pod", "[X-Quadsync-Match]\nLabel=Tier=db\n", `invalid label expression "Tier=db"`},
+		{"no conditions", "x.container", "[X-Quadsync-Match]\n# nothing\n", "x.container:1: [X-Quadsync-Match] has no conditions"},
+		{"base", "_base.container", "[X-Quadsync-Match]\nName=web\n", "not allowed in a base transform"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			os.WriteFile(filepath.Join(dir, c.file), []byte(c.content), 0644)
+			_, err := loadAllTransforms(dir)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("expected error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestBuildDesiredMatchedTransforms(t *testing.T) {
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "db.container"),
+		[]byte("[Container]\nImage=docker.io/library/postgres:16\n"), 0644)
+	os.WriteFile(filepath.Join(repo, "site.container"),
+		[]byte("[Container]\nImage=nginx\n\n[X-Quadsync]\nLabel=public=true\n"), 0644)
+	sub := filepath.Join(repo, "apps")
+	os.Mkdir(sub, 0755)
+	os.WriteFile(filepath.Join(sub, "shop.pod"), []byte("[Pod]\n"), 0644)
+	os.WriteFile(filepath.Join(sub, "shop-db.container"),
+		[]byte("[Container]\nImage=docker.io/library/postgres:15\n"), 0644)
+	os.WriteFile(filepath.Join(sub, "shop-web.container"),
+		[]byte("[Container]\nImage=shop\n\n[X-Quadsync]\nLabel=public=false\n"), 0644)
+
+	tdir := t.TempDir()
+	os.WriteFile(filepath.Join(tdir, "_base.container"), []byte("[Service]\nRestart=always\n"), 0644)
+	os.WriteFile(filepath.Join(tdir, "apps.container"), []byte("[Container]\nLabel=dir=apps\n"), 0644)
+	os.WriteFile(filepath.Join(tdir, "postgres.container"),
+		[]byte("[X-Quadsync-Match]\nImage=*/postgres:*\n\n[Container]\nShmSize=256m\n"), 0644)
+	os.WriteFile(filepath.Join(tdir, "public.container"),
+		[]byte("[X-Quadsync-Match]\nLabel=public=true\n\n[Container]\nNetwork!=public.network\n"), 0644)
+	os.WriteFile(filepath.Join(tdir, "shop.pod"),
+		[]byte("[X-Quadsync-Match]\nName=shop\nDir=apps\n\n[Pod]\nPublishPort=8080:80\n"), 0644)
+	tr, err := loadAllTransforms(tdir)
+	if err != nil {
+		t.Fatalf("loadAllTransforms: %v", err)
+	}
+
+	desired, err := buildDesiredFull(repo, tr)
+	if err != nil {
+		t.Fatalf("buildDesiredFull: %v", err)
+	}
+
+	db := desired["db"]
+	if !strings.Contains(db.Files["db.container"], "ShmSize=256m") {
+		t.Errorf("postgres transform not applied to db:\n%s", db.Files["db.container"])
+	}
+	if want := []string{"_base.container", "postgres.container"}; !reflect.DeepEqual(db.Transforms, want) {
+		t.Errorf("db transforms = %v, want %v", db.Transforms, want)
+	}
+
+	site := desired["site"]
+	if !strings.Contains(site.Files["site.container"], "Network=public.network") {
+		t.Errorf("public transform not applied to site:\n%s", site.Files["site.container"])
+	}
+	if strings.Contains(site.Files["site.container"], "ShmSize") {
+		t.Errorf("postgres transform applied to site:\n%s", site.Files["site.container"])
+	}
+
+	shop := desired["shop"]
+	if !strings.Contains(shop.Files["shop.pod"], "PublishPort=8080:80") {
+		t.Errorf("shop.pod transform not applied:\n%s", shop.Files["shop.pod"])
+	}
+	if !strings.Contains(shop.Files["shop-db.container"], "ShmSize=256m") {
+		t.Errorf("postgres transform not applied to pod member:\n%s", shop.Files["shop-db.container"])
+	}
+	if strings.Contains(shop.Files["shop-web.container"], "public.network") {
+		t.Errorf("public transform applied to public=false member:\n%s", shop.Files["shop-web.container"])
+	}
+	want := []string{"shop.pod", "_base.container", "apps.container", "postgres.container"}
+	if !reflect.DeepEqual(shop.Transforms, want) {
+		t.Errorf("shop transforms = %v, want %v", shop.Transforms, want)
+	}
+}
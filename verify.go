@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The offline validators QUADSYNC_QUADLET_VERIFY runs over the merged output
+// of a sync. Variables so tests can substitute stubs.
+var (
+	quadletGenerator = "/usr/libexec/podman/quadlet"
+	systemdAnalyze   = "systemd-analyze"
+)
+
+// verifyTimeout bounds each validator run.
+const verifyTimeout = time.Minute
+
+// quadletExts are the unit types podman's quadlet generator converts.
+var quadletExts = map[string]bool{
+	".container": true, ".pod": true, ".volume": true, ".network": true,
+	".kube": true, ".image": true, ".build": true,
+}
+
+// verifyDesired runs podman's quadlet generator in dry-run mode over the
+// files of each desired state, and `systemd-analyze verify` over its
+// .service and .timer sidecars, in a throwaway directory. It catches specs
+// that pass quadsync's own checks but that quadlet rejects, which would
+// otherwise only show as a service missing after daemon-reload.
+func verifyDesired(desired map[Username]DesiredState) []error {
+	var errs []error
+	for _, name := range sortedNames(desired) {
+		if len(desired[name].MissingFacts) > 0 {
+			continue // not deployable on this host; see missingFactsError
+		}
+		errs = append(errs, verifyState(name, desired[name])...)
+	}
+	return errs
+}
+
+// verifyState validates one desired state; see verifyDesired.
+func verifyState(name Username, state DesiredState) []error {
+	source := fmt.Sprintf("merged output for %s", name)
+	dir, err := os.MkdirTemp("", "quadsync-verify-")
+	if err != nil {
+		return []error{fileError(source, "creating verify directory: %v", err)}
+	}
+	defer os.RemoveAll(dir)
+
+	var quadlets, sidecars []string
+	for _, filename := range sortedKeys(state.Files) {
+		if filepath.Base(filename) != filename {
+			return []error{fileError(source, "unexpected file name %q", filename)}
+		}
+		if err := os.WriteFile(filepath.Join(dir, filename), []byte(state.Files[filename]), 0644); err != nil {
+			return []error{fileError(source, "writing %s: %v", filename, err)}
+		}
+		switch ext := filepath.Ext(filename); {
+		case quadletExts[ext]:
+			quadlets = append(quadlets, filename)
+		case ext == ".service" || ext == ".timer":
+			sidecars = append(sidecars, filepath.Join(dir, filename))
+		}
+	}
+
+	if len(quadlets) > 0 {
+		stdout, stderr, err := runVerifier([]string{"QUADLET_UNIT_DIRS=" + dir}, quadletGenerator, "-dryrun", "-user")
+		if err != nil {
+			return verifierErrors(source, "quadlet", dir, stderr, err)
+		}
+		// Write the generated services next to the sidecars, so that
+		// systemd-analyze can resolve the sidecars' references to them.
+		for unit, content := range splitDryrun(stdout) {
+			if _, exists := state.Files[unit]; exists || filepath.Base(unit) != unit {
+				continue
+			}
+			if err := os.WriteFile(filepath.Join(dir, unit), []byte(content), 0644); err != nil {
+				return []error{fileError(source, "writing generated %s: %v", unit, err)}
+			}
+		}
+	}
+
+	if len(sidecars) > 0 {
+		args := append([]string{"verify", "--man=no"}, sidecars...)
+		// The trailing colon keeps systemd's default search path after dir.
+		_, stderr, err := runVerifier([]string{"SYSTEMD_UNIT_PATH=" + dir + ":"}, systemdAnalyze, args...)
+		if err != nil {
+			return verifierErrors(source, "systemd-analyze verify", dir, stderr, err)
+		}
+	}
+	return nil
+}
+
+// runVerifier runs a validator with extra environment, returning its
+// stdout and stderr separately.
+func runVerifier(env []string, name string, args ...string) (stdout, stderr string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", verifyTimeout)
+	}
+	return out.String(), errOut.String(), err
+}
+
+// verifierErrors turns a failed validator run into one error per line of
+// its diagnostics, with the throwaway directory stripped from paths.
+func verifierErrors(source, tool, dir, stderr string, err error) []error {
+	var errs []error
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(strings.ReplaceAll(line, dir+"/", ""))
+		if line != "" {
+			errs = append(errs, fileError(source, "%s: %s", tool, line))
+		}
+	}
+	if len(errs) == 0 {
+		errs = append(errs, fileError(source, "%s: %v", tool, err))
+	}
+	return errs
+}
+
+// splitDryrun splits the output of `quadlet -dryrun`, which prints each
+// generated unit after a "---<unit>---" line, into unit contents by name.
+func splitDryrun(out string) map[string]string {
+	units := map[string]string{}
+	var name string
+	var b strings.Builder
+	flush := func() {
+		if name != "" {
+			units[name] = b.String()
+		}
+		b.Reset()
+	}
+	for _, line := range strings.SplitAfter(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "---") && strings.HasSuffix(trimmed, "---") && len(trimmed) > 6 {
+			flush()
+			name = trimmed[3 : len(trimmed)-3]
+			continue
+		}
+		b.WriteString(line)
+	}
+	flush()
+	return units
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubVerifiers replaces quadlet and systemd-analyze with shell scripts for
+// the duration of a test. The quadlet stub rejects any file containing
+// "Bad=" and otherwise generates <name>.service for web.container; the
+// systemd-analyze stub fails unless that generated unit is on its search path
+// and rejects any unit containing "Broken".
+func stubVerifiers(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	quadlet := filepath.Join(dir, "quadlet")
+	analyze := filepath.Join(dir, "systemd-analyze")
+	os.WriteFile(quadlet, []byte(`#!/bin/sh
+[ "$1 $2" = "-dryrun -user" ] || { echo "bad args: $*" >&2; exit 2; }
+if grep -l 'Bad=' "$QUADLET_UNIT_DIRS"/* >/dev/null 2>&1; then
+	f=$(grep -l 'Bad=' "$QUADLET_UNIT_DIRS"/* | head -n1)
+	echo "quadlet-generator[1]: converting \"$f\": unsupported key 'Bad' in group 'Container'" >&2
+	exit 1
+fi
+printf -- '---web.service---\n[Service]\nExecStart=/bin/true\n\n'
+`), 0755)
+	os.WriteFile(analyze, []byte(`#!/bin/sh
+unitdir=${SYSTEMD_UNIT_PATH%:}
+[ -f "$unitdir/web.service" ] || { echo "web.service not found" >&2; exit 1; }
+shift 2
+for f in "$@"; do
+	if grep -q Broken "$f"; then echo "$f:2: Unknown key name 'Broken' in section 'Timer'" >&2; exit 1; fi
+done
+`), 0755)
+	oldQ, oldA := quadletGenerator, systemdAnalyze
+	quadletGenerator, systemdAnalyze = quadlet, analyze
+	t.Cleanup(func() { quadletGenerator, systemdAnalyze = oldQ, oldA })
+}
+
+func TestVerifyDesired(t *testing.T) {
+	stubVerifiers(t)
+
+	valid := map[Username]DesiredState{"web": {Files: map[string]string{
+		"web.container":    "[Container]\nImage=nginx\n",
+		"web-backup.timer": "[Timer]\nOnCalendar=daily\n",
+	}}}
+	if errs := verifyDesired(valid); len(errs) != 0 {
+		t.Fatalf("valid state: %v", errs)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"quadlet rejects", map[string]string{
+			"web.container": "[Container]\nImage=nginx\nBad=1\n",
+		}, `merged output for web: quadlet: quadlet-generator[1]: converting "web.container": unsupported key 'Bad' in group 'Container'`},
+		{"systemd-analyze rejects", map[string]string{
+			"web.container":    "[Container]\nImage=nginx\n",
+			"web-backup.timer": "[Timer]\nBroken=1\n",
+		}, `merged output for web: systemd-analyze verify: web-backup.timer:2: Unknown key name 'Broken' in section 'Timer'`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := verifyDesired(map[Username]DesiredState{"web": {Files: tc.files}})
+			if len(errs) != 1 || errs[0].Error() != tc.want {
+				t.Fatalf("errors = %v, want %s", errs, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplitDryrun(t *testing.T) {
+	out := "---web.service---\n[Unit]\nA=1\n\n---web-data-volume.service---\n[Service]\nB=2\n"
+	got := splitDryrun(out)
+	if len(got) != 2 || got["web.service"] != "[Unit]\nA=1\n\n" || !strings.HasPrefix(got["web-data-volume.service"], "[Service]") {
+		t.Errorf("splitDryrun = %q", got)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// defaultMaxBackoff caps the retry delay after repeated sync failures when
+// QUADSYNC_WATCH_MAX_BACKOFF is unset (raised to the interval if that is
+// longer).
+const defaultMaxBackoff = time.Hour
+
+// WatchStatus is the daemon's reconcile-loop state, returned by OpStatus.
+type WatchStatus struct {
+	Enabled             bool      `json:"enabled"`            // a watch interval is configured
+	Interval            string    `json:"interval,omitempty"` // QUADSYNC_WATCH_INTERVAL
+	Running             bool      `json:"running"`            // a sync is in progress
+	NextRun             time.Time `json:"next_run,omitzero"`  // zero when watch mode is off
+	LastStarted         time.Time `json:"last_started,omitzero"`
+	LastFinished        time.Time `json:"last_finished,omitzero"`
+	LastOK              bool      `json:"last_ok"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// watcher runs syncs for the serve daemon: on its own schedule when watch
+// mode is on, and on demand for OpSync/OpRedeploy. Syncs started by the
+// daemon run one at a time; overlap with a CLI `quadsync sync` is prevented
+// by the sync.lock flock that Sync itself takes.
+type watcher struct {
+	cfg     Config
+	sync    func(Config) error // Sync; replaced in tests
+	trigger chan struct{}      // an on-demand sync finished; reschedule
+	runMu   sync.Mutex         // serialises syncs within the daemon
+
+	mu     sync.Mutex
+	status WatchStatus
+}
+
+func newWatcher(cfg Config) *watcher {
+	return &watcher{
+		cfg:     cfg,
+		sync:    Sync,
+		trigger: make(chan struct{}, 1),
+		status: WatchStatus{
+			Enabled:  cfg.WatchInterval > 0,
+			Interval: durationString(cfg.WatchInterval),
+		},
+	}
+}
+
+// run is the reconcile loop. It returns when stop is closed; it is not
+// started at all when no watch interval is configured.
+func (w *watcher) run(stop <-chan struct{}) {
+	log.Printf("watch: syncing every %s", w.cfg.WatchInterval)
+	for {
+		delay := w.nextDelay()
+		w.mu.Lock()
+		w.status.NextRun = time.Now().Add(delay)
+		w.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			if err := w.syncOnce(w.cfg); err != nil {
+				log.Printf("watch: sync failed: %v", err)
+			}
+		case <-w.trigger:
+			timer.Stop()
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// syncNow runs a sync on demand and restarts the watch schedule from now.
+func (w *watcher) syncNow() error {
+	return w.syncNowWith(w.cfg)
+}
+
+// syncNowWith is syncNow with a one-off config for this run, such as one
+// that acknowledges a mass removal.
+func (w *watcher) syncNowWith(cfg Config) error {
+	err := w.syncOnce(cfg)
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// syncOnce runs one sync with cfg and records its result.
+func (w *watcher) syncOnce(cfg Config) error {
+	w.runMu.Lock()
+	defer w.runMu.Unlock()
+
+	w.mu.Lock()
+	w.status.Running = true
+	w.status.LastStarted = time.Now()
+	w.mu.Unlock()
+
+	err := w.sync(cfg)
+
+	w.mu.Lock()
+	w.status.Running = false
+	w.status.LastFinished = time.Now()
+	switch {
+	case err == nil:
+		w.status.LastOK = true
+		w.status.LastError = ""
+		w.status.ConsecutiveFailures = 0
+	case errors.Is(err, errSyncLocked):
+		// Someone else's sync is running; not a failure of ours, and no
+		// reason to back off.
+	default:
+		w.status.LastOK = false
+		w.status.LastError = err.Error()
+		w.status.ConsecutiveFailures++
+	}
+	w.mu.Unlock()
+	return err
+}
+
+// nextDelay is the watch interval, doubled for every consecutive failure up
+// to the backoff cap, plus a random jitter so a fleet polling the same repo
+// spreads out.
+func (w *watcher) nextDelay() time.Duration {
+	w.mu.Lock()
+	failures := w.status.ConsecutiveFailures
+	w.mu.Unlock()
+
+	delay := backoff(w.cfg.WatchInterval, failures, w.maxBackoff())
+	if w.cfg.WatchJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(w.cfg.WatchJitter)))
+	}
+	return delay
+}
+
+func (w *watcher) maxBackoff() time.Duration {
+	if w.cfg.WatchMaxBackoff > 0 {
+		return w.cfg.WatchMaxBackoff
+	}
+	return max(defaultMaxBackoff, w.cfg.WatchInterval)
+}
+
+// backoff returns interval·2^failures, capped at limit.
+func backoff(interval time.Duration, failures int, limit time.Duration) time.Duration {
+	d := interval
+	for i := 0; i < failures && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// snapshot returns a copy of the current status.
+func (w *watcher) snapshot() WatchStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
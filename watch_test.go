@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 5 * time.Minute},
+		{1, 10 * time.Minute},
+		{2, 20 * time.Minute},
+		{3, 40 * time.Minute},
+		{4, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff(5*time.Minute, tt.failures, time.Hour); got != tt.want {
+			t.Errorf("backoff(5m, %d, 1h) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestWatcherStatus(t *testing.T) {
+	w := newWatcher(Config{WatchInterval: time.Minute})
+	results := []error{errors.New("git fetch: boom"), errors.New("git fetch: boom"), errSyncLocked, nil}
+	w.sync = func(Config) error {
+		err := results[0]
+		results = results[1:]
+		return err
+	}
+
+	w.syncNow()
+	w.syncNow()
+	st := w.snapshot()
+	if st.LastOK || st.LastError != "git fetch: boom" || st.ConsecutiveFailures != 2 {
+		t.Errorf("after two failures: %+v", st)
+	}
+	if d := w.nextDelay(); d < 4*time.Minute || d >= 4*time.Minute+6*time.Second {
+		t.Errorf("expected ~4m backoff after two failures, got %s", d)
+	}
+
+	// Losing the lock to a CLI sync is neither a failure nor a success.
+	w.syncNow()
+	if st := w.snapshot(); st.ConsecutiveFailures != 2 {
+		t.Errorf("lock contention counted as failure: %+v", st)
+	}
+
+	w.syncNow()
+	st = w.snapshot()
+	if !st.LastOK || st.LastError != "" || st.ConsecutiveFailures != 0 || st.Running {
+		t.Errorf("after success: %+v", st)
+	}
+	if !st.Enabled || st.Interval != "1m0s" || st.LastFinished.Before(st.LastStarted) {
+		t.Errorf("unexpected status: %+v", st)
+	}
+}
+
+func TestWatcherLoop(t *testing.T) {
+	w := newWatcher(Config{WatchInterval: 20 * time.Millisecond})
+	var runs atomic.Int32
+	w.sync = func(Config) error {
+		runs.Add(1)
+		return nil
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		w.run(stop)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runs.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if runs.Load() < 3 {
+		t.Fatalf("expected the loop to sync repeatedly, got %d runs", runs.Load())
+	}
+	if st := w.snapshot(); st.NextRun.IsZero() {
+		t.Error("expected next run to be scheduled")
+	}
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop did not stop")
+	}
+}
+
+func TestWatchStatusJSONOmitsZeroTimes(t *testing.T) {
+	data, err := json.Marshal(newWatcher(Config{}).snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"next_run", "last_started", "last_finished"} {
+		if strings.Contains(string(data), field) {
+			t.Errorf("idle status has %s: %s", field, data)
+		}
+	}
+}
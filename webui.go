@@ -34,6 +34,10 @@ func cmdWebUI() {
 	mux.HandleFunc("GET /api/containers/{name}/logs", srv.handleLogs)
 	mux.HandleFunc("POST /api/containers/{name}/{action}", srv.handleAction)
 	mux.HandleFunc("POST /api/sync", srv.handleSync)
+	mux.HandleFunc("POST /api/plan", srv.handlePlan) // fetches the sources, like a sync
+	mux.HandleFunc("GET /api/reports", srv.handleReports)
+	mux.HandleFunc("GET /api/status", srv.handleStatus)
+	mux.HandleFunc("GET /api/drift", srv.handleDrift)
 
 	httpSrv := &http.Server{Addr: *addr, Handler: mux}
 
@@ -116,6 +120,23 @@ func (s *webServer) handleSync(w http.ResponseWriter, r *http.Request) {
 	s.call(w, Request{Op: OpSync})
 }
 
+func (s *webServer) handlePlan(w http.ResponseWriter, r *http.Request) {
+	s.call(w, Request{Op: OpPlan})
+}
+
+func (s *webServer) handleReports(w http.ResponseWriter, r *http.Request) {
+	count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+	s.call(w, Request{Op: OpReports, Count: count})
+}
+
+func (s *webServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.call(w, Request{Op: OpStatus})
+}
+
+func (s *webServer) handleDrift(w http.ResponseWriter, r *http.Request) {
+	s.call(w, Request{Op: OpDrift})
+}
+
 func writeJSON(w http.ResponseWriter, code int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)